@@ -2,16 +2,17 @@ package resource
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
-	"sync/atomic"
 	"time"
 
-	"github.com/disintegration/imaging"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -28,20 +29,63 @@ const (
 	userIDContextKey = "user-id"
 	// thumbnailImagePath is the directory to store image thumbnails.
 	thumbnailImagePath = ".thumbnail_cache"
+	// defaultCacheControl is the Cache-Control header of resources without their own.
+	defaultCacheControl = "max-age=3600"
+	// privateCacheControl is the Cache-Control header of resources of non-public memos,
+	// so they don't linger in shared or browser caches once access is revoked.
+	privateCacheControl = "private, no-store"
+	// pdfThumbnailSettingName is the workspace setting enabling pdf thumbnails, see v1.SystemSettingPDFThumbnailName.
+	pdfThumbnailSettingName = "pdf-thumbnail"
+	// videoThumbnailSettingName is the workspace setting enabling video thumbnails, see v1.SystemSettingVideoThumbnailName.
+	videoThumbnailSettingName = "video-thumbnail"
+	// thumbnailFormatSettingName is the workspace setting for the format thumbnails are encoded in, see v1.SystemSettingThumbnailFormatName.
+	thumbnailFormatSettingName = "thumbnail-format"
+	// thumbnailJPEGQualitySettingName is the workspace setting for the quality of jpeg thumbnails, see v1.SystemSettingThumbnailJPEGQualityName.
+	thumbnailJPEGQualitySettingName = "thumbnail-jpeg-quality"
+	// activeContentModeSettingName is the workspace setting for serving scriptable resources, see v1.SystemSettingActiveContentModeName.
+	activeContentModeSettingName = "active-content-mode"
+	// disableHardeningHeadersSettingName is the workspace setting disabling referrer and framing restrictions, see v1.SystemSettingDisableResourceHardeningHeadersName.
+	disableHardeningHeadersSettingName = "disable-resource-hardening-headers"
+	// downloadRateLimitSettingName is the workspace setting for the download rate of a connection, see v1.SystemSettingDownloadRateLimitName.
+	downloadRateLimitSettingName = "download-rate-limit"
+
+	// activeContentModeAttachment serves scriptable resources as downloads.
+	activeContentModeAttachment = "attachment"
+	// activeContentModePlain serves scriptable resources inline as plain text.
+	activeContentModePlain = "plain"
 )
 
+// transcodedImageFormats are the formats images can be requested in with the `format` query param.
+// Other formats, such as webp which has no encoder available, are served in the original format.
+var transcodedImageFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+}
+
 type ResourceService struct {
 	Profile *profile.Profile
 	Store   *store.Store
+
+	thumbnailGenerator *thumbnailGenerator
 }
 
 func NewResourceService(profile *profile.Profile, store *store.Store) *ResourceService {
+	// Generations interrupted by a previous shutdown leave their temp files behind.
+	if _, err := removeThumbnailTempFiles(filepath.Join(profile.Data, thumbnailImagePath)); err != nil {
+		log.Warn("failed to remove thumbnail temp files", zap.Error(err))
+	}
 	return &ResourceService{
-		Profile: profile,
-		Store:   store,
+		Profile:            profile,
+		Store:              store,
+		thumbnailGenerator: newThumbnailGenerator(profile.ThumbnailConcurrency),
 	}
 }
 
+// Shutdown waits for the in-flight thumbnail generations to finish, until ctx is done.
+func (s *ResourceService) Shutdown(ctx context.Context) error {
+	return s.thumbnailGenerator.wait(ctx)
+}
+
 func (s *ResourceService) RegisterRoutes(g *echo.Group) {
 	g.GET("/r/:resourceName", s.streamResource)
 	g.GET("/r/:resourceName/*", s.streamResource)
@@ -57,10 +101,11 @@ func (s *ResourceService) streamResource(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to find resource by id: %s", resourceName)).SetInternal(err)
 	}
-	if resource == nil {
+	if resource == nil || resource.IsExpired(time.Now().Unix()) {
 		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Resource not found: %s", resourceName))
 	}
 	// Check the related memo visibility.
+	private := false
 	if resource.MemoID != nil {
 		memo, err := s.Store.GetMemo(ctx, &store.FindMemo{
 			ID: resource.MemoID,
@@ -69,6 +114,7 @@ func (s *ResourceService) streamResource(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to find memo by ID: %v", resource.MemoID)).SetInternal(err)
 		}
 		if memo != nil && memo.Visibility != store.Public {
+			private = true
 			userID, ok := c.Get(userIDContextKey).(int32)
 			if !ok || (memo.Visibility == store.Private && userID != resource.CreatorID) {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Resource visibility not match")
@@ -76,89 +122,454 @@ func (s *ResourceService) streamResource(c echo.Context) error {
 		}
 	}
 
+	return s.StreamResourceContent(c, resource, private)
+}
+
+// StreamResourceContent writes the content of the resource to the response with its security, cache and content headers.
+// The access to the resource must have been checked, private resources are never kept in shared caches.
+// The thumbnail, format and download query params of the request are honoured.
+func (s *ResourceService) StreamResourceContent(c echo.Context, resource *store.Resource, private bool) error {
+	ctx := c.Request().Context()
+	// Set the security headers first so that every response of the resource carries them.
+	s.setSecurityHeaders(c)
+	cacheControl := defaultCacheControl
+	if private {
+		// Takes precedence over the resource's own header, which may allow shared caching.
+		cacheControl = privateCacheControl
+	} else if resource.CacheControl != nil {
+		cacheControl = *resource.CacheControl
+	}
+	c.Response().Writer.Header().Set(echo.HeaderCacheControl, cacheControl)
+	contentType := getContentType(resource)
+	var thumbnail *thumbnailType
+	if c.QueryParam("thumbnail") == "1" {
+		// Types without a registered thumbnail are served as-is.
+		thumbnail = s.findEnabledThumbnailType(ctx, contentType)
+	}
+	requestedFormatType, ok := transcodedImageFormats[c.QueryParam("format")]
+	transcoded := ok && strings.HasPrefix(contentType, "image/") && requestedFormatType != contentType
+
+	// Checked before the content is read, the same way for every content type.
+	lastModified := time.Unix(resource.LastModifiedTs(), 0).UTC()
+	c.Response().Writer.Header().Set(echo.HeaderLastModified, lastModified.Format(http.TimeFormat))
+	etag := ""
+	if thumbnail == nil && !transcoded && resource.Sha256 != "" {
+		etag = fmt.Sprintf(`"%s"`, resource.Sha256)
+	}
+	if isNotModified(c.Request(), etag, lastModified) {
+		if etag != "" {
+			c.Response().Writer.Header().Set("ETag", etag)
+		}
+		return c.NoContent(http.StatusNotModified)
+	}
+
 	blob := resource.Blob
-	if resource.InternalPath != "" {
-		resourcePath := filepath.FromSlash(resource.InternalPath)
-		if !filepath.IsAbs(resourcePath) {
-			resourcePath = filepath.Join(s.Profile.Data, resourcePath)
+	if thumbnail != nil {
+		// Cached thumbnails are served without reading the content.
+		thumbnailPath, thumbnailContentType := s.getThumbnailPath(ctx, resource, thumbnail)
+		thumbnailBlob, err := s.generateThumbnail(ctx, thumbnail, resource, thumbnailPath)
+		if err != nil {
+			log.Warn(fmt.Sprintf("failed to get or generate thumbnail with path %s", thumbnailPath), zap.Error(err))
+			// The content is served as-is.
+			thumbnail = nil
+		} else {
+			blob = thumbnailBlob
+			if thumbnailContentType != "" {
+				contentType = thumbnailContentType
+			}
 		}
+	}
+
+	if thumbnail == nil && !transcoded && resource.Sha256 != "" {
+		// The hash of the content is a stable strong validator, so interrupted downloads are resumed with If-Range.
+		c.Response().Writer.Header().Set("ETag", fmt.Sprintf(`"%s"`, resource.Sha256))
+	}
 
-		src, err := os.Open(resourcePath)
+	// Resources only linked externally have no content of their own.
+	if thumbnail == nil && len(blob) == 0 && (resource.InternalPath != "" || resource.ExternalLink == "") {
+		src, err := s.Store.GetResourceContent(ctx, resource)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to open the local resource: %s", resourcePath)).SetInternal(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to open the resource content").SetInternal(err)
 		}
 		defer src.Close()
+		if !transcoded {
+			// The content is served as-is, so local files are sent straight from the disk.
+			return s.serveResourceStream(c, resource, &resourceStream{
+				reader:      src,
+				contentType: contentType,
+			})
+		}
 		blob, err = io.ReadAll(src)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to read the local resource: %s", resourcePath)).SetInternal(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to read the resource content").SetInternal(err)
 		}
 	}
 
-	if c.QueryParam("thumbnail") == "1" && util.HasPrefixes(resource.Type, "image/png", "image/jpeg") {
-		ext := filepath.Ext(resource.Filename)
-		thumbnailPath := filepath.Join(s.Profile.Data, thumbnailImagePath, fmt.Sprintf("%d%s", resource.ID, ext))
-		thumbnailBlob, err := getOrGenerateThumbnailImage(blob, thumbnailPath)
-		if err != nil {
-			log.Warn(fmt.Sprintf("failed to get or generate local thumbnail with path %s", thumbnailPath), zap.Error(err))
-		} else {
-			blob = thumbnailBlob
+	// Images are transcoded after the thumbnail is made, so both can be requested at once.
+	if format := c.QueryParam("format"); format != "" && strings.HasPrefix(contentType, "image/") {
+		if formatType, ok := transcodedImageFormats[format]; ok && formatType != contentType {
+			variant, jpegQuality := "original", 0
+			if c.QueryParam("thumbnail") == "1" {
+				variant, jpegQuality = "thumbnail", s.getThumbnailJPEGQuality(ctx)
+			}
+			transcodedPath := filepath.Join(s.Profile.Data, thumbnailImagePath, fmt.Sprintf("%d-%d-%s.%s", resource.ID, resource.UpdatedTs, variant, format))
+			transcodedBlob, err := s.thumbnailGenerator.getOrGenerate(transcodedPath, func(dstPath string) error {
+				return transcodeImage(blob, dstPath, jpegQuality)
+			})
+			if err != nil {
+				log.Warn(fmt.Sprintf("failed to get or transcode image with path %s", transcodedPath), zap.Error(err))
+			} else {
+				blob = transcodedBlob
+				contentType = formatType
+			}
 		}
 	}
 
-	c.Response().Writer.Header().Set(echo.HeaderCacheControl, "max-age=3600")
-	c.Response().Writer.Header().Set(echo.HeaderContentSecurityPolicy, "default-src 'none'; script-src 'none'; img-src 'self'; media-src 'self'; sandbox;")
-	c.Response().Writer.Header().Set("Content-Disposition", fmt.Sprintf(`filename="%s"`, resource.Filename))
-	resourceType := strings.ToLower(resource.Type)
+	return s.serveResourceStream(c, resource, &resourceStream{
+		reader:      bytes.NewReader(blob),
+		contentType: contentType,
+	})
+}
+
+// resourceStream is the content served for a resource, the original one or a thumbnail or transcoded variant.
+type resourceStream struct {
+	reader      io.Reader
+	contentType string
+}
+
+// serveResourceStream sets the content headers of the resource and writes the stream to the response.
+// Seekable streams are served with support for byte ranges, unless they're compressed, other streams as a whole.
+// Every stream is throttled to the download rate limit of the workspace.
+func (s *ResourceService) serveResourceStream(c echo.Context, resource *store.Resource, stream *resourceStream) error {
+	contentType := stream.contentType
+	dispositionType := "inline"
+	if c.QueryParam("download") == "1" {
+		dispositionType = "attachment"
+	}
+	if isActiveContentType(contentType) {
+		switch s.getActiveContentMode(c.Request().Context()) {
+		case activeContentModePlain:
+			contentType = echo.MIMETextPlainCharsetUTF8
+		case activeContentModeAttachment:
+			dispositionType = "attachment"
+		}
+	}
+	filename := resource.Filename
+	if filename == "" {
+		// Resources created before filenames were derived may have none.
+		filename = util.DefaultLinkFilename(resource.ExternalLink, stream.contentType)
+	}
+	disposition := contentDisposition(dispositionType, filename)
+	reader := stream.reader
+	if bytesPerSecond := s.getDownloadRateLimit(c.Request().Context()); bytesPerSecond > 0 {
+		reader = newThrottledReader(c.Request().Context(), reader, bytesPerSecond)
+	}
+
+	c.Response().Writer.Header().Set(echo.HeaderContentDisposition, disposition)
+	resourceType := strings.ToLower(contentType)
 	if strings.HasPrefix(resourceType, "text") {
 		resourceType = echo.MIMETextPlainCharsetUTF8
-	} else if strings.HasPrefix(resourceType, "video") || strings.HasPrefix(resourceType, "audio") {
-		http.ServeContent(c.Response(), c.Request(), resource.Filename, time.Unix(resource.UpdatedTs, 0), bytes.NewReader(blob))
+	}
+	// Compression breaks byte ranges, so range requests are always served as-is.
+	if isCompressibleType(contentType) && acceptsGzip(c.Request()) && c.Request().Header.Get("Range") == "" {
+		// The compressed content is another representation, the strong validator of the content doesn't apply.
+		c.Response().Header().Del("ETag")
+		return streamGzip(c, resourceType, reader)
+	}
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		c.Response().Header().Set(echo.HeaderContentType, resourceType)
+		// Multiple ranges are served as multipart/byteranges, unsatisfiable ones are rejected with 416.
+		http.ServeContent(sendfileResponse{c.Response()}, c.Request(), resource.Filename, time.Unix(resource.LastModifiedTs(), 0), seeker)
 		return nil
 	}
-	return c.Stream(http.StatusOK, resourceType, bytes.NewReader(blob))
+	// The Range header is ignored and the whole content is served.
+	c.Response().Header().Set("Accept-Ranges", "none")
+	return c.Stream(http.StatusOK, resourceType, reader)
+}
+
+// getContentType returns the type of the resource, guessed from its filename extension when the stored type is generic.
+func getContentType(resource *store.Resource) string {
+	switch strings.ToLower(strings.TrimSpace(resource.Type)) {
+	case "", "application/octet-stream", "binary/octet-stream":
+		if contentType := mime.TypeByExtension(filepath.Ext(resource.Filename)); contentType != "" {
+			return contentType
+		}
+	}
+	return resource.Type
 }
 
-var availableGeneratorAmount int32 = 32
+// isNotModified reports whether the cached copy of a GET or HEAD request is still valid.
+// If-None-Match takes precedence, If-Modified-Since is ignored whenever it's sent (RFC 9110 §13.1.3).
+// The etag is empty when the served content has no validator.
+// Otherwise If-Modified-Since must be at or after lastModified.
+func isNotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return matchesETag(header, etag)
+	}
+	header := r.Header.Get(echo.HeaderIfModifiedSince)
+	if header == "" {
+		return false
+	}
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return false
+	}
+	// The header has a one second resolution.
+	return !lastModified.Truncate(time.Second).After(since)
+}
 
-func getOrGenerateThumbnailImage(srcBlob []byte, dstPath string) ([]byte, error) {
-	if _, err := os.Stat(dstPath); err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return nil, errors.Wrap(err, "failed to check thumbnail image stat")
+// matchesETag reports whether the If-None-Match header is "*" or any of its entity tags weakly matches etag.
+func matchesETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || (etag != "" && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/")) {
+			return true
 		}
+	}
+	return false
+}
 
-		if atomic.LoadInt32(&availableGeneratorAmount) <= 0 {
-			return nil, errors.New("not enough available generator amount")
+// contentDisposition formats a Content-Disposition header value for the filename.
+// Filenames that aren't plain ASCII get a sanitized fallback and an RFC 5987 encoded `filename*` parameter.
+func contentDisposition(dispositionType, filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
 		}
-		atomic.AddInt32(&availableGeneratorAmount, -1)
-		defer func() {
-			atomic.AddInt32(&availableGeneratorAmount, 1)
-		}()
+		return r
+	}, filename)
+	if fallback == filename {
+		return fmt.Sprintf(`%s; filename="%s"`, dispositionType, filename)
+	}
 
-		reader := bytes.NewReader(srcBlob)
-		src, err := imaging.Decode(reader, imaging.AutoOrientation(true))
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to decode thumbnail image")
+	var encoded strings.Builder
+	for _, b := range []byte(filename) {
+		if ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') || strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
 		}
-		thumbnailImage := imaging.Resize(src, 512, 0, imaging.Lanczos)
+	}
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, dispositionType, fallback, encoded.String())
+}
+
+// RegenerateThumbnail deletes the cached thumbnails of the resource and generates its thumbnail again.
+// It returns ErrThumbnailUnsupported when the resource has no thumbnail.
+func (s *ResourceService) RegenerateThumbnail(ctx context.Context, resource *store.Resource) error {
+	thumbnail := s.findEnabledThumbnailType(ctx, resource.Type)
+	if thumbnail == nil || resource.ExternalLink != "" {
+		return ErrThumbnailUnsupported
+	}
+	s.Store.DeleteResourceThumbnails(resource.ID)
+	thumbnailPath, _ := s.getThumbnailPath(ctx, resource, thumbnail)
+	if _, err := s.generateThumbnail(ctx, thumbnail, resource, thumbnailPath); err != nil {
+		return errors.Wrap(err, "failed to generate thumbnail")
+	}
+	return nil
+}
+
+// findEnabledThumbnailType returns the thumbnail type of mimeType, nil if there's none or it's disabled in the workspace.
+func (s *ResourceService) findEnabledThumbnailType(ctx context.Context, mimeType string) *thumbnailType {
+	thumbnail := findThumbnailType(mimeType)
+	if thumbnail == nil || (thumbnail.enabled != nil && !thumbnail.enabled(ctx, s)) {
+		return nil
+	}
+	return thumbnail
+}
+
+// getThumbnailPath returns the path the thumbnail of the resource is cached at and the type it's served with,
+// empty to keep the type of the resource. The extension of the path follows the workspace thumbnail format.
+func (s *ResourceService) getThumbnailPath(ctx context.Context, resource *store.Resource, thumbnail *thumbnailType) (string, string) {
+	extension, contentType := thumbnail.extension, thumbnail.contentType
+	if extension == "" {
+		extension = filepath.Ext(resource.Filename)
+	}
+	if format := s.getThumbnailFormat(ctx); format != nil {
+		extension, contentType = format.extension, format.contentType
+	}
+	return filepath.Join(s.Profile.Data, thumbnailImagePath, fmt.Sprintf("%d-%d%s", resource.ID, resource.UpdatedTs, extension)), contentType
+}
 
-		dstDir := filepath.Dir(dstPath)
-		if err := os.MkdirAll(dstDir, os.ModePerm); err != nil {
-			return nil, errors.Wrap(err, "failed to create thumbnail dir")
+// getThumbnailFormat returns the format thumbnails are encoded in, nil to keep the format of each thumbnail type.
+func (s *ResourceService) getThumbnailFormat(ctx context.Context) *thumbnailFormat {
+	value := s.Store.GetWorkspaceSettingWithDefaultValue(ctx, thumbnailFormatSettingName, "")
+	if value == "" {
+		return nil
+	}
+	name := ""
+	if err := json.Unmarshal([]byte(value), &name); err != nil {
+		log.Warn("failed to unmarshal thumbnail format", zap.Error(err))
+		return nil
+	}
+	if name == "" || name == "original" {
+		return nil
+	}
+	if format, ok := thumbnailFormats[name]; ok {
+		return format
+	}
+	return thumbnailFormats["jpeg"]
+}
+
+// getThumbnailJPEGQuality returns the quality jpeg thumbnails are encoded at, 0 for the default quality of imaging.
+func (s *ResourceService) getThumbnailJPEGQuality(ctx context.Context) int {
+	value := s.Store.GetWorkspaceSettingWithDefaultValue(ctx, thumbnailJPEGQualitySettingName, "")
+	if value == "" {
+		return 0
+	}
+	quality := 0
+	if err := json.Unmarshal([]byte(value), &quality); err != nil || quality < 1 || quality > 100 {
+		log.Warn("invalid thumbnail jpeg quality", zap.String("value", value), zap.Error(err))
+		return 0
+	}
+	return quality
+}
+
+// generateThumbnail returns the thumbnail of the resource cached at thumbnailPath, generating it when it's not cached.
+func (s *ResourceService) generateThumbnail(ctx context.Context, thumbnail *thumbnailType, resource *store.Resource, thumbnailPath string) ([]byte, error) {
+	jpegQuality := s.getThumbnailJPEGQuality(ctx)
+	return s.thumbnailGenerator.getOrGenerate(thumbnailPath, func(dstPath string) error {
+		return thumbnail.generate(s.thumbnailGenerator, s.getThumbnailSource(ctx, resource), dstPath, jpegQuality)
+	})
+}
+
+// getThumbnailSource returns the content of the resource for its thumbnail.
+func (s *ResourceService) getThumbnailSource(ctx context.Context, resource *store.Resource) *thumbnailSource {
+	if len(resource.Blob) > 0 {
+		return newBlobThumbnailSource(resource.Blob)
+	}
+	source := &thumbnailSource{
+		open: func() (io.ReadCloser, error) {
+			return s.Store.GetResourceContent(ctx, resource)
+		},
+	}
+	if resource.Compression == "" {
+		source.localPath = s.Store.GetResourceLocalPath(resource)
+	}
+	return source
+}
+
+// isPDFThumbnailEnabled reports whether pdf thumbnails are enabled by the workspace and can be rendered.
+func (s *ResourceService) isPDFThumbnailEnabled(ctx context.Context) bool {
+	if !s.thumbnailGenerator.canRenderPDF() {
+		return false
+	}
+	return s.Store.GetWorkspaceSettingWithDefaultValue(ctx, pdfThumbnailSettingName, "false") == "true"
+}
+
+// isVideoThumbnailEnabled reports whether video thumbnails are enabled by the workspace and can be extracted.
+func (s *ResourceService) isVideoThumbnailEnabled(ctx context.Context) bool {
+	if !s.thumbnailGenerator.canExtractVideoFrame() {
+		return false
+	}
+	return s.Store.GetWorkspaceSettingWithDefaultValue(ctx, videoThumbnailSettingName, "false") == "true"
+}
+
+// setSecurityHeaders sets the headers hardening the browser handling of the resource.
+// Referrer and framing restrictions can be disabled by the workspace, nosniff is always sent.
+func (s *ResourceService) setSecurityHeaders(c echo.Context) {
+	header := c.Response().Header()
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	if s.Store.GetWorkspaceSettingWithDefaultValue(c.Request().Context(), disableHardeningHeadersSettingName, "false") == "true" {
+		header.Set(echo.HeaderContentSecurityPolicy, "default-src 'none'; script-src 'none'; img-src 'self'; media-src 'self'; sandbox;")
+		return
+	}
+	header.Set(echo.HeaderContentSecurityPolicy, "default-src 'none'; script-src 'none'; img-src 'self'; media-src 'self'; frame-ancestors 'none'; sandbox;")
+	header.Set(echo.HeaderXFrameOptions, "DENY")
+	header.Set(echo.HeaderReferrerPolicy, "no-referrer")
+}
+
+// isActiveContentType reports whether browsers may execute scripts embedded in the content when rendered inline.
+func isActiveContentType(mimeType string) bool {
+	mimeType = strings.ToLower(mimeType)
+	return util.HasPrefixes(mimeType, "image/svg+xml", "text/html", "application/xhtml+xml", "text/xml", "application/xml")
+}
+
+// getActiveContentMode returns how scriptable resources are served, attachment by default.
+func (s *ResourceService) getActiveContentMode(ctx context.Context) string {
+	mode := activeContentModeAttachment
+	value := s.Store.GetWorkspaceSettingWithDefaultValue(ctx, activeContentModeSettingName, "")
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &mode); err != nil {
+			log.Warn("failed to unmarshal active content mode", zap.Error(err))
+			return activeContentModeAttachment
 		}
+	}
+	return mode
+}
+
+// sendfileResponse exposes the io.ReaderFrom of the connection, which echo.Response hides,
+// so local files are copied to the response by the kernel with sendfile.
+type sendfileResponse struct {
+	*echo.Response
+}
+
+// observedReader is a reader recording the storage operation it is read for, see metric.ObserveStorageReader.
+type observedReader interface {
+	Unwrap() io.Reader
+	Record(size int64, err error)
+}
 
-		if err := imaging.Save(thumbnailImage, dstPath); err != nil {
-			return nil, errors.Wrap(err, "failed to resize thumbnail image")
+func (r sendfileResponse) ReadFrom(src io.Reader) (int64, error) {
+	if !r.Committed {
+		r.WriteHeader(http.StatusOK)
+	}
+	readerFrom, ok := r.Writer.(io.ReaderFrom)
+	if !ok {
+		// Hide ReadFrom so the copy doesn't loop back here.
+		return io.Copy(struct{ io.Writer }{r.Response}, src)
+	}
+	// The connection only uses sendfile for files, possibly limited like by http.ServeContent,
+	// so observed files are copied directly and the copied bytes recorded afterwards.
+	var observed observedReader
+	if limited, ok := src.(*io.LimitedReader); ok {
+		if observed, ok = limited.R.(observedReader); ok {
+			src = &io.LimitedReader{R: observed.Unwrap(), N: limited.N}
 		}
+	} else if observed, ok = src.(observedReader); ok {
+		src = observed.Unwrap()
+	}
+	size, err := readerFrom.ReadFrom(src)
+	if observed != nil {
+		observed.Record(size, err)
 	}
+	r.Size += size
+	return size, err
+}
 
-	dstFile, err := os.Open(dstPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to open the local resource")
+// streamGzip writes the content of src gzip-compressed to the response.
+func streamGzip(c echo.Context, contentType string, src io.Reader) error {
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentEncoding, "gzip")
+	header.Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+	c.Response().WriteHeader(http.StatusOK)
+
+	gzipWriter := gzip.NewWriter(c.Response())
+	if _, err := io.Copy(gzipWriter, src); err != nil {
+		_ = gzipWriter.Close()
+		return err
 	}
-	defer dstFile.Close()
-	dstBlob, err := io.ReadAll(dstFile)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read the local resource")
+	return gzipWriter.Close()
+}
+
+// isCompressibleType returns true if the content of the given mime type benefits from compression.
+// Images, videos and archives are already compressed.
+func isCompressibleType(mimeType string) bool {
+	mimeType = strings.ToLower(mimeType)
+	return util.HasPrefixes(mimeType, "text/", "image/svg+xml", "application/json")
+}
+
+// acceptsGzip returns true if the client accepts gzip content encoding.
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range strings.Split(r.Header.Get(echo.HeaderAcceptEncoding), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
 	}
-	return dstBlob, nil
+	return false
 }
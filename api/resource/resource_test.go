@@ -0,0 +1,902 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lithammer/shortuuid/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usememos/memos/server/service/metric"
+	"github.com/usememos/memos/store"
+	teststore "github.com/usememos/memos/test/store"
+)
+
+const testSVG = `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`
+
+func TestStreamResourceActiveContent(t *testing.T) {
+	tests := []struct {
+		mode            string
+		wantType        string
+		wantDisposition string
+	}{
+		{
+			mode:            "",
+			wantType:        "image/svg+xml",
+			wantDisposition: `attachment; filename="drawing.svg"`,
+		},
+		{
+			mode:            `"plain"`,
+			wantType:        echo.MIMETextPlainCharsetUTF8,
+			wantDisposition: `inline; filename="drawing.svg"`,
+		},
+		{
+			mode:            `"inline"`,
+			wantType:        "image/svg+xml",
+			wantDisposition: `inline; filename="drawing.svg"`,
+		},
+	}
+	for _, test := range tests {
+		ctx := context.Background()
+		ts := teststore.NewTestingStore(ctx, t)
+		if test.mode != "" {
+			_, err := ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{
+				Name:  activeContentModeSettingName,
+				Value: test.mode,
+			})
+			require.NoError(t, err)
+		}
+		resource, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     "drawing.svg",
+			Blob:         []byte(testSVG),
+			Type:         "image/svg+xml",
+			Size:         int64(len(testSVG)),
+		})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/o/r/"+resource.ResourceName, nil), rec)
+		c.SetParamNames("resourceName")
+		c.SetParamValues(resource.ResourceName)
+		require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, test.wantType, rec.Header().Get(echo.HeaderContentType))
+		require.Equal(t, test.wantDisposition, rec.Header().Get(echo.HeaderContentDisposition))
+		require.Equal(t, "nosniff", rec.Header().Get(echo.HeaderXContentTypeOptions))
+		require.Equal(t, "DENY", rec.Header().Get(echo.HeaderXFrameOptions))
+		require.Equal(t, "no-referrer", rec.Header().Get(echo.HeaderReferrerPolicy))
+		require.Equal(t, testSVG, rec.Body.String())
+		ts.Close()
+	}
+}
+
+func TestStreamResourceDisposition(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "notes.txt",
+		Blob:         []byte("hello"),
+		Type:         "text/plain",
+		Size:         5,
+	})
+	require.NoError(t, err)
+
+	for query, want := range map[string]string{
+		"":            `inline; filename="notes.txt"`,
+		"?download=1": `attachment; filename="notes.txt"`,
+	} {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/o/r/"+resource.ResourceName+query, nil), rec)
+		c.SetParamNames("resourceName")
+		c.SetParamValues(resource.ResourceName)
+		require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+		require.Equal(t, want, rec.Header().Get(echo.HeaderContentDisposition))
+	}
+}
+
+func TestStreamResourceCacheControl(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	avatar, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "avatar.txt",
+		Blob:         []byte("hello"),
+		Type:         "text/plain",
+		Size:         5,
+	})
+	require.NoError(t, err)
+	cacheControl := "public, max-age=31536000, immutable"
+	avatar, err = ts.UpdateResource(ctx, &store.UpdateResource{
+		ID:           avatar.ID,
+		CacheControl: &cacheControl,
+	})
+	require.NoError(t, err)
+	document, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "document.txt",
+		Blob:         []byte("hello"),
+		Type:         "text/plain",
+		Size:         5,
+	})
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		resource *store.Resource
+		want     string
+	}{
+		{resource: avatar, want: cacheControl},
+		{resource: document, want: defaultCacheControl},
+	} {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/o/r/"+test.resource.ResourceName, nil), rec)
+		c.SetParamNames("resourceName")
+		c.SetParamValues(test.resource.ResourceName)
+		require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+		require.Equal(t, test.want, rec.Header().Get(echo.HeaderCacheControl))
+	}
+}
+
+func TestStreamResourcePrivateCacheControl(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	cacheControl := "public, max-age=31536000, immutable"
+	createResource := func(visibility store.Visibility) *store.Resource {
+		memo, err := ts.CreateMemo(ctx, &store.Memo{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Content:      "memo",
+			Visibility:   visibility,
+		})
+		require.NoError(t, err)
+		resource, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     "attachment.txt",
+			Blob:         []byte("hello"),
+			Type:         "text/plain",
+			Size:         5,
+			MemoID:       &memo.ID,
+		})
+		require.NoError(t, err)
+		resource, err = ts.UpdateResource(ctx, &store.UpdateResource{
+			ID:           resource.ID,
+			CacheControl: &cacheControl,
+		})
+		require.NoError(t, err)
+		return resource
+	}
+
+	for _, test := range []struct {
+		visibility store.Visibility
+		want       string
+	}{
+		{visibility: store.Public, want: cacheControl},
+		{visibility: store.Protected, want: privateCacheControl},
+		{visibility: store.Private, want: privateCacheControl},
+	} {
+		resource := createResource(test.visibility)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/o/r/"+resource.ResourceName, nil), rec)
+		c.SetParamNames("resourceName")
+		c.SetParamValues(resource.ResourceName)
+		c.Set(userIDContextKey, int32(101))
+		require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+		require.Equal(t, test.want, rec.Header().Get(echo.HeaderCacheControl), "visibility %s", test.visibility)
+	}
+}
+
+func TestStreamResourceIfModifiedSince(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	text, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "notes.txt",
+		Blob:         []byte("hello"),
+		Type:         "text/plain",
+		Size:         5,
+	})
+	require.NoError(t, err)
+	video, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "clip.mp4",
+		Blob:         []byte("video"),
+		Type:         "video/mp4",
+		Size:         5,
+	})
+	require.NoError(t, err)
+
+	for _, resource := range []*store.Resource{text, video} {
+		lastModified := time.Unix(resource.UpdatedTs, 0).UTC()
+		for _, test := range []struct {
+			ifModifiedSince string
+			wantCode        int
+		}{
+			{ifModifiedSince: "", wantCode: http.StatusOK},
+			{ifModifiedSince: lastModified.Format(http.TimeFormat), wantCode: http.StatusNotModified},
+			{ifModifiedSince: lastModified.Add(time.Hour).Format(http.TimeFormat), wantCode: http.StatusNotModified},
+			{ifModifiedSince: lastModified.Add(-time.Hour).Format(http.TimeFormat), wantCode: http.StatusOK},
+			{ifModifiedSince: "yesterday", wantCode: http.StatusOK},
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/o/r/"+resource.ResourceName, nil)
+			if test.ifModifiedSince != "" {
+				req.Header.Set(echo.HeaderIfModifiedSince, test.ifModifiedSince)
+			}
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			c.SetParamNames("resourceName")
+			c.SetParamValues(resource.ResourceName)
+			require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+			require.Equal(t, test.wantCode, rec.Code, "%s with If-Modified-Since %q", resource.Type, test.ifModifiedSince)
+			require.Equal(t, lastModified.Format(http.TimeFormat), rec.Header().Get(echo.HeaderLastModified))
+			if test.wantCode == http.StatusNotModified {
+				require.Empty(t, rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestStreamResourceIfNoneMatch(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "clip.mp4",
+		Blob:         []byte("video"),
+		Type:         "video/mp4",
+		Size:         5,
+	})
+	require.NoError(t, err)
+	etag := `"` + resource.Sha256 + `"`
+	lastModified := time.Unix(resource.UpdatedTs, 0).UTC()
+
+	for _, test := range []struct {
+		ifNoneMatch     string
+		ifModifiedSince string
+		wantCode        int
+	}{
+		{ifNoneMatch: etag, wantCode: http.StatusNotModified},
+		{ifNoneMatch: `"stale", W/` + etag, wantCode: http.StatusNotModified},
+		{ifNoneMatch: "*", wantCode: http.StatusNotModified},
+		// If-Modified-Since is ignored when If-None-Match is sent.
+		{ifNoneMatch: `"stale"`, ifModifiedSince: lastModified.Add(time.Hour).Format(http.TimeFormat), wantCode: http.StatusOK},
+		{ifNoneMatch: etag, ifModifiedSince: lastModified.Add(-time.Hour).Format(http.TimeFormat), wantCode: http.StatusNotModified},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/o/r/"+resource.ResourceName, nil)
+		req.Header.Set("If-None-Match", test.ifNoneMatch)
+		if test.ifModifiedSince != "" {
+			req.Header.Set(echo.HeaderIfModifiedSince, test.ifModifiedSince)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("resourceName")
+		c.SetParamValues(resource.ResourceName)
+		require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+		require.Equal(t, test.wantCode, rec.Code, "If-None-Match %s with If-Modified-Since %q", test.ifNoneMatch, test.ifModifiedSince)
+		require.Equal(t, etag, rec.Header().Get("ETag"))
+		if test.wantCode == http.StatusOK {
+			require.Equal(t, "video", rec.Body.String())
+		}
+	}
+}
+
+func TestStreamResourceOriginalTs(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	originalTs := int64(1136214245)
+	for _, resourceType := range []string{"text/plain", "video/mp4"} {
+		resource, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     "file",
+			Blob:         []byte("hello"),
+			Type:         resourceType,
+			Size:         5,
+			OriginalTs:   &originalTs,
+		})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/o/r/"+resource.ResourceName, nil), rec)
+		c.SetParamNames("resourceName")
+		c.SetParamValues(resource.ResourceName)
+		require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, time.Unix(originalTs, 0).UTC().Format(http.TimeFormat), rec.Header().Get(echo.HeaderLastModified), resourceType)
+	}
+}
+
+func TestStreamResourceFormat(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	source := &bytes.Buffer{}
+	require.NoError(t, png.Encode(source, image.NewRGBA(image.Rect(0, 0, 1024, 768))))
+	photo, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "photo.png",
+		Blob:         source.Bytes(),
+		Type:         "image/png",
+		Size:         int64(source.Len()),
+	})
+	require.NoError(t, err)
+	broken, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "broken.png",
+		Blob:         []byte("not an image"),
+		Type:         "image/png",
+		Size:         12,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		resource  *store.Resource
+		query     string
+		wantType  string
+		wantWidth int
+	}{
+		{resource: photo, query: "?format=jpeg", wantType: "image/jpeg", wantWidth: 1024},
+		{resource: photo, query: "?format=jpeg&thumbnail=1", wantType: "image/jpeg", wantWidth: thumbnailWidth},
+		{resource: photo, query: "?format=png", wantType: "image/png", wantWidth: 1024},
+		{resource: photo, query: "?format=webp", wantType: "image/png", wantWidth: 1024},
+		{resource: broken, query: "?format=jpeg", wantType: "image/png"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/o/r/"+test.resource.ResourceName+test.query, nil), rec)
+		c.SetParamNames("resourceName")
+		c.SetParamValues(test.resource.ResourceName)
+		require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+		require.Equal(t, http.StatusOK, rec.Code, test.query)
+		require.Equal(t, test.wantType, rec.Header().Get(echo.HeaderContentType), test.query)
+		if test.wantWidth == 0 {
+			require.Equal(t, "not an image", rec.Body.String())
+			continue
+		}
+		config, format, err := image.DecodeConfig(rec.Body)
+		require.NoError(t, err, test.query)
+		require.Equal(t, strings.TrimPrefix(test.wantType, "image/"), format, test.query)
+		require.Equal(t, test.wantWidth, config.Width, test.query)
+	}
+}
+
+func TestStreamResourceThumbnailFormat(t *testing.T) {
+	source := &bytes.Buffer{}
+	require.NoError(t, png.Encode(source, image.NewRGBA(image.Rect(0, 0, 1024, 768))))
+	tests := []struct {
+		format     string
+		wantType   string
+		wantFormat string
+	}{
+		{format: "", wantType: "image/png", wantFormat: "png"},
+		{format: `"original"`, wantType: "image/png", wantFormat: "png"},
+		{format: `"jpeg"`, wantType: "image/jpeg", wantFormat: "jpeg"},
+		// No webp encoder is available, so it falls back to jpeg.
+		{format: `"webp"`, wantType: "image/jpeg", wantFormat: "jpeg"},
+	}
+	for _, test := range tests {
+		ctx := context.Background()
+		ts := teststore.NewTestingStore(ctx, t)
+		if test.format != "" {
+			_, err := ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{
+				Name:  thumbnailFormatSettingName,
+				Value: test.format,
+			})
+			require.NoError(t, err)
+		}
+		photo, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     "photo.png",
+			Blob:         source.Bytes(),
+			Type:         "image/png",
+			Size:         int64(source.Len()),
+		})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/o/r/"+photo.ResourceName+"?thumbnail=1", nil), rec)
+		c.SetParamNames("resourceName")
+		c.SetParamValues(photo.ResourceName)
+		require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+		require.Equal(t, test.wantType, rec.Header().Get(echo.HeaderContentType), "format %s", test.format)
+		decoded, format, err := image.Decode(rec.Body)
+		require.NoError(t, err)
+		require.Equal(t, test.wantFormat, format, "format %s", test.format)
+		require.Equal(t, thumbnailWidth, decoded.Bounds().Dx())
+		ts.Close()
+	}
+}
+
+func TestRegenerateThumbnail(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	source := &bytes.Buffer{}
+	require.NoError(t, png.Encode(source, image.NewRGBA(image.Rect(0, 0, 1024, 768))))
+	photo, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "photo.png",
+		Blob:         source.Bytes(),
+		Type:         "image/png",
+		Size:         int64(source.Len()),
+	})
+	require.NoError(t, err)
+	text, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "notes.txt",
+		Blob:         []byte("hello"),
+		Type:         "text/plain",
+		Size:         5,
+	})
+	require.NoError(t, err)
+
+	s := NewResourceService(ts.Profile, ts)
+	thumbnailPath, _ := s.getThumbnailPath(ctx, photo, findThumbnailType(photo.Type))
+	require.NoError(t, os.MkdirAll(filepath.Dir(thumbnailPath), os.ModePerm))
+	require.NoError(t, os.WriteFile(thumbnailPath, []byte("corrupted"), 0644))
+
+	require.NoError(t, s.RegenerateThumbnail(ctx, photo))
+	thumbnail, err := os.ReadFile(thumbnailPath)
+	require.NoError(t, err)
+	decoded, _, err := image.Decode(bytes.NewReader(thumbnail))
+	require.NoError(t, err)
+	require.Equal(t, thumbnailWidth, decoded.Bounds().Dx())
+
+	require.ErrorIs(t, s.RegenerateThumbnail(ctx, text), ErrThumbnailUnsupported)
+}
+
+func TestStreamResourceGenericType(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	source := &bytes.Buffer{}
+	require.NoError(t, png.Encode(source, image.NewRGBA(image.Rect(0, 0, 16, 16))))
+
+	tests := []struct {
+		filename string
+		mimeType string
+		wantType string
+	}{
+		{filename: "photo.png", mimeType: "application/octet-stream", wantType: "image/png"},
+		{filename: "photo.png", mimeType: "", wantType: "image/png"},
+		{filename: "notes.txt", mimeType: "application/octet-stream", wantType: echo.MIMETextPlainCharsetUTF8},
+		{filename: "archive.unknown", mimeType: "application/octet-stream", wantType: "application/octet-stream"},
+		{filename: "photo.png", mimeType: "image/jpeg", wantType: "image/jpeg"},
+	}
+	for _, test := range tests {
+		resource, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     test.filename,
+			Blob:         source.Bytes(),
+			Type:         test.mimeType,
+			Size:         int64(source.Len()),
+		})
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/o/r/"+resource.ResourceName, nil), rec)
+		c.SetParamNames("resourceName")
+		c.SetParamValues(resource.ResourceName)
+		require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+		require.Equal(t, test.wantType, rec.Header().Get(echo.HeaderContentType), "%s %q", test.filename, test.mimeType)
+	}
+}
+
+func TestServeResourceStream(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewResourceService(ts.Profile, ts)
+	resource := &store.Resource{
+		Filename:  "clip.mp4",
+		Type:      "video/mp4",
+		UpdatedTs: time.Now().Unix(),
+	}
+	content := "0123456789"
+
+	tests := []struct {
+		reader     io.Reader
+		wantStatus int
+		wantBody   string
+	}{
+		// Seekable streams honour byte ranges.
+		{reader: strings.NewReader(content), wantStatus: http.StatusPartialContent, wantBody: "2345"},
+		// Other streams are served as a whole.
+		{reader: io.MultiReader(strings.NewReader(content)), wantStatus: http.StatusOK, wantBody: content},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/o/r/test", nil)
+		request.Header.Set("Range", "bytes=2-5")
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(request, rec)
+		require.NoError(t, s.serveResourceStream(c, resource, &resourceStream{reader: test.reader, contentType: resource.Type}))
+		require.Equal(t, test.wantStatus, rec.Code)
+		require.Equal(t, test.wantBody, rec.Body.String())
+		require.Equal(t, "video/mp4", rec.Header().Get(echo.HeaderContentType))
+		require.Equal(t, `inline; filename="clip.mp4"`, rec.Header().Get(echo.HeaderContentDisposition))
+	}
+}
+
+func TestServeResourceStreamRanges(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewResourceService(ts.Profile, ts)
+	resource := &store.Resource{
+		Filename:  "archive.zip",
+		Type:      "application/zip",
+		UpdatedTs: time.Now().Unix(),
+	}
+	content := "0123456789"
+
+	tests := []struct {
+		seekable         bool
+		rangeHeader      string
+		wantStatus       int
+		wantBody         string
+		wantAcceptRanges string
+	}{
+		{seekable: true, wantStatus: http.StatusOK, wantBody: content, wantAcceptRanges: "bytes"},
+		{seekable: true, rangeHeader: "bytes=2-5", wantStatus: http.StatusPartialContent, wantBody: "2345", wantAcceptRanges: "bytes"},
+		{seekable: true, rangeHeader: "bytes=20-30", wantStatus: http.StatusRequestedRangeNotSatisfiable},
+		{seekable: false, wantStatus: http.StatusOK, wantBody: content, wantAcceptRanges: "none"},
+		{seekable: false, rangeHeader: "bytes=2-5", wantStatus: http.StatusOK, wantBody: content, wantAcceptRanges: "none"},
+		{seekable: false, rangeHeader: "bytes=0-1,4-5", wantStatus: http.StatusOK, wantBody: content, wantAcceptRanges: "none"},
+	}
+	for _, test := range tests {
+		var reader io.Reader = strings.NewReader(content)
+		if !test.seekable {
+			reader = io.MultiReader(reader)
+		}
+		request := httptest.NewRequest(http.MethodGet, "/o/r/test", nil)
+		if test.rangeHeader != "" {
+			request.Header.Set("Range", test.rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(request, rec)
+		require.NoError(t, s.serveResourceStream(c, resource, &resourceStream{reader: reader, contentType: resource.Type}))
+		require.Equal(t, test.wantStatus, rec.Code, "%+v", test)
+		require.Equal(t, test.wantAcceptRanges, rec.Header().Get("Accept-Ranges"), "%+v", test)
+		if test.wantBody != "" {
+			require.Equal(t, test.wantBody, rec.Body.String(), "%+v", test)
+		}
+	}
+
+	// Multiple ranges of seekable streams are served as multipart/byteranges.
+	request := httptest.NewRequest(http.MethodGet, "/o/r/test", nil)
+	request.Header.Set("Range", "bytes=0-1,4-5")
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(request, rec)
+	require.NoError(t, s.serveResourceStream(c, resource, &resourceStream{reader: strings.NewReader(content), contentType: resource.Type}))
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get(echo.HeaderContentType))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/byteranges", mediaType)
+	parts := []string{}
+	partReader := multipart.NewReader(rec.Body, params["boundary"])
+	for {
+		part, err := partReader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.Equal(t, "application/zip", part.Header.Get(echo.HeaderContentType))
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		parts = append(parts, string(body))
+	}
+	require.Equal(t, []string{"01", "45"}, parts)
+}
+
+func TestServeResourceStreamDefaultFilename(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewResourceService(ts.Profile, ts)
+	for _, test := range []struct {
+		resource *store.Resource
+		want     string
+	}{
+		{resource: &store.Resource{ExternalLink: "https://example.com/files/photo.jpg", Type: "image/jpeg"}, want: `inline; filename="photo.jpg"`},
+		{resource: &store.Resource{ExternalLink: "https://example.com/", Type: "image/jpeg"}, want: `inline; filename="download.jpg"`},
+	} {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/o/r/test", nil), rec)
+		require.NoError(t, s.serveResourceStream(c, test.resource, &resourceStream{reader: strings.NewReader("test"), contentType: test.resource.Type}))
+		require.Equal(t, test.want, rec.Header().Get(echo.HeaderContentDisposition))
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{
+			filename: "report.pdf",
+			want:     `inline; filename="report.pdf"`,
+		},
+		{
+			filename: `my "quoted" file.txt`,
+			want:     `inline; filename="my _quoted_ file.txt"; filename*=UTF-8''my%20%22quoted%22%20file.txt`,
+		},
+		{
+			filename: "фото.jpg",
+			want:     `inline; filename="____.jpg"; filename*=UTF-8''%D1%84%D0%BE%D1%82%D0%BE.jpg`,
+		},
+		{
+			filename: "line\nbreak.txt",
+			want:     `inline; filename="line_break.txt"; filename*=UTF-8''line%0Abreak.txt`,
+		},
+	}
+	for _, test := range tests {
+		if got := contentDisposition("inline", test.filename); got != test.want {
+			t.Errorf("contentDisposition %q: got %s, want %s.", test.filename, got, test.want)
+		}
+	}
+}
+
+func TestServeResourceStreamDownloadRateLimit(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewResourceService(ts.Profile, ts)
+	bytesPerSecond := 8000
+	_, err := ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: downloadRateLimitSettingName, Value: strconv.Itoa(bytesPerSecond)})
+	require.NoError(t, err)
+	resource := &store.Resource{
+		Filename:  "clip.mp4",
+		Type:      "video/mp4",
+		UpdatedTs: time.Now().Unix(),
+	}
+	content := strings.Repeat("0123456789", 2*bytesPerSecond/10)
+
+	for _, reader := range []io.Reader{
+		// Served by http.ServeContent.
+		strings.NewReader(content),
+		// Served by c.Stream.
+		io.MultiReader(strings.NewReader(content)),
+	} {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/o/r/test", nil), rec)
+		startTime := time.Now()
+		require.NoError(t, s.serveResourceStream(c, resource, &resourceStream{reader: reader, contentType: resource.Type}))
+		elapsed := time.Since(startTime)
+		require.Equal(t, content, rec.Body.String())
+		// The first second of bytes is sent at once, the rest at the limit.
+		require.GreaterOrEqual(t, elapsed, 900*time.Millisecond)
+		require.Less(t, elapsed, 3*time.Second)
+	}
+}
+
+func TestStreamResourceCachedVideoThumbnail(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewResourceService(ts.Profile, ts)
+	// Never run, the poster is cached.
+	s.thumbnailGenerator.ffmpegPath = "ffmpeg"
+	_, err := ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: videoThumbnailSettingName, Value: "true"})
+	require.NoError(t, err)
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "clip.mp4",
+		// The video isn't read to serve the cached poster, so it may be missing.
+		InternalPath: "assets/missing.mp4",
+		Type:         "video/mp4",
+		Size:         1 << 30,
+	})
+	require.NoError(t, err)
+	thumbnailPath, _ := s.getThumbnailPath(ctx, resource, findThumbnailType(resource.Type))
+	require.NoError(t, os.MkdirAll(filepath.Dir(thumbnailPath), os.ModePerm))
+	require.NoError(t, os.WriteFile(thumbnailPath, []byte("poster"), 0644))
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/o/r/test?thumbnail=1", nil), rec)
+	require.NoError(t, s.StreamResourceContent(c, resource, false))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "poster", rec.Body.String())
+	require.Equal(t, "image/jpeg", rec.Header().Get(echo.HeaderContentType))
+}
+
+func TestThumbnailSourceFilePath(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewResourceService(ts.Profile, ts)
+	dir := t.TempDir()
+
+	// Local files are given to the tools as-is, without being read.
+	local := s.getThumbnailSource(ctx, &store.Resource{InternalPath: "assets/clip.mp4"})
+	path, err := local.filePath(dir, "source")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(ts.Profile.Data, "assets", "clip.mp4"), path)
+
+	// Compressed local files and the other contents are streamed to a temp file.
+	compressed := s.getThumbnailSource(ctx, &store.Resource{InternalPath: "assets/clip.mp4.gz", Compression: store.ResourceCompressionGzip})
+	require.Empty(t, compressed.localPath)
+	path, err = newBlobThumbnailSource([]byte("video")).filePath(dir, "source")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "source"), path)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "video", string(content))
+}
+
+func TestStreamResourceLocalFile(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	content := "0123456789"
+	require.NoError(t, os.MkdirAll(filepath.Join(ts.Profile.Data, "assets"), os.ModePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(ts.Profile.Data, "assets", "archive.zip"), []byte(content), 0644))
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "archive.zip",
+		InternalPath: "assets/archive.zip",
+		Type:         "application/zip",
+		Size:         int64(len(content)),
+	})
+	require.NoError(t, err)
+
+	// The file is served as-is, with support for byte ranges.
+	request := httptest.NewRequest(http.MethodGet, "/o/r/"+resource.ResourceName, nil)
+	request.Header.Set("Range", "bytes=2-5")
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(request, rec)
+	c.SetParamNames("resourceName")
+	c.SetParamValues(resource.ResourceName)
+	require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	require.Equal(t, "2345", rec.Body.String())
+	require.Equal(t, "application/zip", rec.Header().Get(echo.HeaderContentType))
+	require.Equal(t, "4", rec.Header().Get(echo.HeaderContentLength))
+}
+
+func TestStreamResourceResumedDownload(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	content := "0123456789"
+	require.NoError(t, os.MkdirAll(filepath.Join(ts.Profile.Data, "assets"), os.ModePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(ts.Profile.Data, "assets", "clip.mp4"), []byte(content), 0644))
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "clip.mp4",
+		InternalPath: "assets/clip.mp4",
+		Type:         "video/mp4",
+		Size:         int64(len(content)),
+		Sha256:       "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882",
+	})
+	require.NoError(t, err)
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/o/r/"+resource.ResourceName, nil)
+		for key, values := range header {
+			request.Header[key] = values
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(request, rec)
+		c.SetParamNames("resourceName")
+		c.SetParamValues(resource.ResourceName)
+		require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+		return rec
+	}
+
+	rec := get(nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	require.Equal(t, `"`+resource.Sha256+`"`, etag)
+
+	// The download is resumed while the content is unchanged.
+	rec = get(http.Header{"Range": {"bytes=4-"}, "If-Range": {etag}})
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	require.Equal(t, "456789", rec.Body.String())
+	require.Equal(t, etag, rec.Header().Get("ETag"))
+
+	// Otherwise the whole content is served again.
+	rec = get(http.Header{"Range": {"bytes=4-"}, "If-Range": {`"stale"`}})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, content, rec.Body.String())
+}
+
+// fileReadFromRecorder is a recorder whose connection supports io.ReaderFrom, recording whether it's given a file for sendfile.
+type fileReadFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFile bool
+}
+
+func (r *fileReadFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	file := src
+	if limited, ok := src.(*io.LimitedReader); ok {
+		file = limited.R
+	}
+	_, r.readFile = file.(*os.File)
+	// Hide ReadFrom so the copy goes through Write.
+	return io.Copy(struct{ io.Writer }{r.ResponseRecorder}, src)
+}
+
+// getStorageBytes returns the bytes of the storage operation recorded by the Prometheus metrics.
+func getStorageBytes(t *testing.T, operation, provider string) int64 {
+	rec := httptest.NewRecorder()
+	metric.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	prefix := `memos_storage_operation_bytes_total{operation="` + operation + `",provider="` + provider + `"} `
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, prefix); ok {
+			size, err := strconv.ParseFloat(value, 64)
+			require.NoError(t, err)
+			return int64(size)
+		}
+	}
+	return 0
+}
+
+func TestStreamResourceObservedLocalFile(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	metric.EnablePrometheus()
+	content := "0123456789"
+	require.NoError(t, os.MkdirAll(filepath.Join(ts.Profile.Data, "assets"), os.ModePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(ts.Profile.Data, "assets", "archive.zip"), []byte(content), 0644))
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "archive.zip",
+		InternalPath: "assets/archive.zip",
+		Type:         "application/zip",
+		Size:         int64(len(content)),
+	})
+	require.NoError(t, err)
+	downloaded := getStorageBytes(t, "download", "local")
+
+	// The observed file is still sent with sendfile, and the sent bytes are recorded.
+	request := httptest.NewRequest(http.MethodGet, "/o/r/"+resource.ResourceName, nil)
+	request.Header.Set("Range", "bytes=2-5")
+	rec := &fileReadFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	c := echo.New().NewContext(request, rec)
+	c.SetParamNames("resourceName")
+	c.SetParamValues(resource.ResourceName)
+	require.NoError(t, NewResourceService(ts.Profile, ts).streamResource(c))
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	require.Equal(t, "2345", rec.Body.String())
+	require.True(t, rec.readFile)
+	require.Equal(t, downloaded+4, getStorageBytes(t, "download", "local"))
+}
@@ -0,0 +1,73 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	"github.com/usememos/memos/internal/log"
+)
+
+// maxThrottledReadSize bounds a single read of a throttled stream, so the bytes are sent steadily.
+const maxThrottledReadSize = 32 * 1024
+
+// throttledReader reads from r at most at the rate of its limiter, until ctx is done.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+// newThrottledReader returns r limited to bytesPerSecond, it keeps r seekable when it is.
+func newThrottledReader(ctx context.Context, r io.Reader, bytesPerSecond int64) io.Reader {
+	readSize := int(min(bytesPerSecond, maxThrottledReadSize))
+	throttled := &throttledReader{
+		ctx:     ctx,
+		r:       r,
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), readSize),
+	}
+	if seeker, ok := r.(io.Seeker); ok {
+		return &throttledReadSeeker{throttledReader: throttled, seeker: seeker}
+	}
+	return throttled
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// throttledReadSeeker is a seekable throttledReader, so byte ranges are still served.
+type throttledReadSeeker struct {
+	*throttledReader
+	seeker io.Seeker
+}
+
+func (r *throttledReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
+
+// getDownloadRateLimit returns the download rate limit of a connection in bytes per second, 0 when downloads aren't limited.
+func (s *ResourceService) getDownloadRateLimit(ctx context.Context) int64 {
+	value := s.Store.GetWorkspaceSettingWithDefaultValue(ctx, downloadRateLimitSettingName, "")
+	if value == "" {
+		return 0
+	}
+	var bytesPerSecond int64
+	if err := json.Unmarshal([]byte(value), &bytesPerSecond); err != nil || bytesPerSecond < 0 {
+		log.Warn("invalid download rate limit", zap.String("value", value), zap.Error(err))
+		return 0
+	}
+	return bytesPerSecond
+}
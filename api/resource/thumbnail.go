@@ -0,0 +1,456 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// defaultThumbnailConcurrency is the default amount of thumbnails generated at the same time.
+	defaultThumbnailConcurrency = 32
+	// thumbnailWaitTimeout is how long a request waits in the queue for a free generator.
+	thumbnailWaitTimeout = 10 * time.Second
+	// thumbnailWidth is the width of generated thumbnails in pixels.
+	thumbnailWidth = 512
+	// pdfRenderTimeout is the maximum time allowed to render a pdf page.
+	pdfRenderTimeout = 30 * time.Second
+	// videoFrameTimeout is the maximum time allowed to extract a video frame.
+	videoFrameTimeout = 30 * time.Second
+	// thumbnailTempPrefix is the name prefix of thumbnails being generated.
+	thumbnailTempPrefix = ".tmp-"
+)
+
+// ErrThumbnailUnsupported is returned when thumbnails can't be made for a resource.
+var ErrThumbnailUnsupported = errors.New("thumbnails are not supported for the resource")
+
+// thumbnailType describes how thumbnails are made for a kind of resource, see registerThumbnailType.
+type thumbnailType struct {
+	// extension is the file extension of the thumbnails, empty to keep the one of the resource filename.
+	extension string
+	// contentType is the type thumbnails are served with, empty to keep the type of the resource.
+	contentType string
+	// enabled reports whether the thumbnails are enabled in the workspace, nil means they always are.
+	enabled func(ctx context.Context, s *ResourceService) bool
+	// generate writes the thumbnail of src at dstPath, jpeg thumbnails are encoded at jpegQuality.
+	generate func(g *thumbnailGenerator, src *thumbnailSource, dstPath string, jpegQuality int) error
+}
+
+// thumbnailSource is the content a thumbnail is generated from. It's only read when the thumbnail isn't cached yet.
+type thumbnailSource struct {
+	// open returns the content, the caller closes it.
+	open func() (io.ReadCloser, error)
+	// localPath is the path of the content on the local disk, empty when it isn't stored there as-is.
+	localPath string
+}
+
+// newBlobThumbnailSource returns the source of content already in memory.
+func newBlobThumbnailSource(blob []byte) *thumbnailSource {
+	return &thumbnailSource{
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(blob)), nil
+		},
+	}
+}
+
+// readAll returns the whole content of the source.
+func (s *thumbnailSource) readAll() ([]byte, error) {
+	reader, err := s.open()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the thumbnail source")
+	}
+	defer reader.Close()
+	blob, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the thumbnail source")
+	}
+	return blob, nil
+}
+
+// filePath returns the path of a file with the content of the source, for external tools.
+// It's the local file itself when there's one, else the content is streamed to a file named name in dir.
+func (s *thumbnailSource) filePath(dir, name string) (string, error) {
+	if s.localPath != "" {
+		// Absolute, so the path is never taken for an option of the tool.
+		return filepath.Abs(s.localPath)
+	}
+	reader, err := s.open()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open the thumbnail source")
+	}
+	defer reader.Close()
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temp file")
+	}
+	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to write the thumbnail source to temp file")
+	}
+	return path, nil
+}
+
+// thumbnailFormat is an encoding thumbnails can be saved in regardless of their type, see thumbnailFormatSettingName.
+type thumbnailFormat struct {
+	extension   string
+	contentType string
+}
+
+// thumbnailFormats are the formats with an available encoder by setting value.
+// The other accepted values, webp and avif, fall back to jpeg.
+var thumbnailFormats = map[string]*thumbnailFormat{
+	"jpeg": {extension: ".jpg", contentType: "image/jpeg"},
+	"png":  {extension: ".png", contentType: "image/png"},
+}
+
+// thumbnailTypes are the registered thumbnail types by MIME type prefix.
+var thumbnailTypes = map[string]*thumbnailType{}
+
+func init() {
+	for _, mimeType := range []string{"image/png", "image/jpeg"} {
+		registerThumbnailType(mimeType, &thumbnailType{
+			generate: func(_ *thumbnailGenerator, src *thumbnailSource, dstPath string, jpegQuality int) error {
+				srcBlob, err := src.readAll()
+				if err != nil {
+					return err
+				}
+				return generateThumbnailImage(srcBlob, dstPath, jpegQuality)
+			},
+		})
+	}
+	registerThumbnailType("application/pdf", &thumbnailType{
+		extension:   ".png",
+		contentType: "image/png",
+		enabled: func(ctx context.Context, s *ResourceService) bool {
+			return s.isPDFThumbnailEnabled(ctx)
+		},
+		generate: (*thumbnailGenerator).generatePDFThumbnail,
+	})
+	registerThumbnailType("video/", &thumbnailType{
+		extension:   ".jpg",
+		contentType: "image/jpeg",
+		enabled: func(ctx context.Context, s *ResourceService) bool {
+			return s.isVideoThumbnailEnabled(ctx)
+		},
+		generate: (*thumbnailGenerator).generateVideoThumbnail,
+	})
+}
+
+// registerThumbnailType makes thumbnails available for the resources whose type starts with mimePrefix.
+// It is meant to be called from init functions.
+func registerThumbnailType(mimePrefix string, thumbnail *thumbnailType) {
+	if _, ok := thumbnailTypes[mimePrefix]; ok {
+		panic(fmt.Sprintf("thumbnail type %s is already registered", mimePrefix))
+	}
+	thumbnailTypes[mimePrefix] = thumbnail
+}
+
+// findThumbnailType returns the thumbnail type registered with the longest prefix of mimeType, nil if there's none.
+func findThumbnailType(mimeType string) *thumbnailType {
+	var found *thumbnailType
+	foundPrefix := ""
+	for prefix, thumbnail := range thumbnailTypes {
+		if strings.HasPrefix(mimeType, prefix) && len(prefix) > len(foundPrefix) {
+			found, foundPrefix = thumbnail, prefix
+		}
+	}
+	return found
+}
+
+// thumbnailGenerator generates image thumbnails with bounded concurrency.
+// Concurrent requests for the same thumbnail path are coalesced into a single generation.
+type thumbnailGenerator struct {
+	semaphore   chan struct{}
+	group       singleflight.Group
+	waitTimeout time.Duration
+	// inflight tracks the generations in progress, they go on even when the requester gave up.
+	inflight sync.WaitGroup
+	// mutex guards closed, so no generation is added to inflight once it's waited for.
+	mutex  sync.Mutex
+	closed bool
+	// pdfRendererPath is the path of the pdftoppm binary, empty if it's not available.
+	pdfRendererPath string
+	// ffmpegPath is the path of the ffmpeg binary, empty if it's not available.
+	ffmpegPath string
+}
+
+func newThumbnailGenerator(concurrency int) *thumbnailGenerator {
+	if concurrency <= 0 {
+		concurrency = defaultThumbnailConcurrency
+	}
+	// PDF rendering is optional and only enabled when pdftoppm (poppler-utils) is installed.
+	pdfRendererPath, _ := exec.LookPath("pdftoppm")
+	// Video poster frames are only extracted when ffmpeg is installed.
+	ffmpegPath, _ := exec.LookPath("ffmpeg")
+	return &thumbnailGenerator{
+		semaphore:       make(chan struct{}, concurrency),
+		waitTimeout:     thumbnailWaitTimeout,
+		pdfRendererPath: pdfRendererPath,
+		ffmpegPath:      ffmpegPath,
+	}
+}
+
+// getOrGenerate returns the thumbnail stored at dstPath, generating it with generate if it does not exist yet.
+// The source of the thumbnail is only read by generate, so cached thumbnails don't need it.
+func (g *thumbnailGenerator) getOrGenerate(dstPath string, generate func(dstPath string) error) ([]byte, error) {
+	blob, err, _ := g.group.Do(dstPath, func() (any, error) {
+		if err := g.begin(); err != nil {
+			return nil, err
+		}
+		defer g.inflight.Done()
+		if _, err := os.Stat(dstPath); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, errors.Wrap(err, "failed to check thumbnail image stat")
+			}
+
+			timer := time.NewTimer(g.waitTimeout)
+			defer timer.Stop()
+			select {
+			case g.semaphore <- struct{}{}:
+			case <-timer.C:
+				return nil, errors.New("timed out waiting for an available thumbnail generator")
+			}
+			defer func() {
+				<-g.semaphore
+			}()
+
+			// Generate into a temporary file renamed once complete, so that a half-written thumbnail is never read.
+			tempPath := filepath.Join(filepath.Dir(dstPath), fmt.Sprintf("%s%d-%s", thumbnailTempPrefix, time.Now().UnixNano(), filepath.Base(dstPath)))
+			if err := generate(tempPath); err != nil {
+				_ = os.Remove(tempPath)
+				return nil, err
+			}
+			if err := os.Rename(tempPath, dstPath); err != nil {
+				_ = os.Remove(tempPath)
+				return nil, errors.Wrap(err, "failed to move the generated thumbnail")
+			}
+		}
+
+		dstFile, err := os.Open(dstPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open the local resource")
+		}
+		defer dstFile.Close()
+		dstBlob, err := io.ReadAll(dstFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the local resource")
+		}
+		return dstBlob, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blob.([]byte), nil
+}
+
+// begin adds a generation to the in-flight ones, it fails once the generator is waited for.
+func (g *thumbnailGenerator) begin() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.closed {
+		return errors.New("the thumbnail generator is shut down")
+	}
+	g.inflight.Add(1)
+	return nil
+}
+
+// wait blocks until the in-flight generations are done or ctx is done.
+// No generation is started afterwards.
+func (g *thumbnailGenerator) wait(ctx context.Context) error {
+	g.mutex.Lock()
+	g.closed = true
+	g.mutex.Unlock()
+	done := make(chan struct{})
+	go func() {
+		g.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "failed to wait for in-flight thumbnail generations")
+	}
+}
+
+// removeThumbnailTempFiles removes the temp files left in dir by interrupted generations.
+func removeThumbnailTempFiles(dir string) (int, error) {
+	tempPaths, err := filepath.Glob(filepath.Join(dir, thumbnailTempPrefix+"*"))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list thumbnail temp files")
+	}
+	removed := 0
+	for _, tempPath := range tempPaths {
+		if err := os.Remove(tempPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, errors.Wrap(err, "failed to remove thumbnail temp file")
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// generateThumbnailImage resizes the image srcBlob at dstPath, jpegQuality 0 encodes jpeg thumbnails at the default quality.
+func generateThumbnailImage(srcBlob []byte, dstPath string, jpegQuality int) error {
+	reader := bytes.NewReader(srcBlob)
+	src, err := imaging.Decode(reader, imaging.AutoOrientation(true))
+	if err != nil {
+		return errors.Wrap(err, "failed to decode thumbnail image")
+	}
+	thumbnailImage := imaging.Resize(src, thumbnailWidth, 0, imaging.Lanczos)
+
+	dstDir := filepath.Dir(dstPath)
+	if err := os.MkdirAll(dstDir, os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to create thumbnail dir")
+	}
+
+	if err := saveImage(thumbnailImage, dstPath, jpegQuality); err != nil {
+		return errors.Wrap(err, "failed to resize thumbnail image")
+	}
+	return nil
+}
+
+// transcodeImage re-encodes the image srcBlob at dstPath, in the format matching the extension of dstPath.
+func transcodeImage(srcBlob []byte, dstPath string, jpegQuality int) error {
+	src, err := imaging.Decode(bytes.NewReader(srcBlob), imaging.AutoOrientation(true))
+	if err != nil {
+		return errors.Wrap(err, "failed to decode image")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to create thumbnail dir")
+	}
+	if err := saveImage(src, dstPath, jpegQuality); err != nil {
+		return errors.Wrap(err, "failed to encode image")
+	}
+	return nil
+}
+
+// saveImage encodes img at dstPath in the format matching its extension, jpeg images at jpegQuality unless it's 0.
+func saveImage(img image.Image, dstPath string, jpegQuality int) error {
+	options := []imaging.EncodeOption{}
+	if jpegQuality > 0 {
+		options = append(options, imaging.JPEGQuality(jpegQuality))
+	}
+	return imaging.Save(img, dstPath, options...)
+}
+
+// saveThumbnail writes the image blob encoded with extension at dstPath, re-encoding it when dstPath has
+// another extension or a jpeg blob has to be encoded at jpegQuality.
+func saveThumbnail(blob []byte, extension string, dstPath string, jpegQuality int) error {
+	if !strings.EqualFold(filepath.Ext(dstPath), extension) || (jpegQuality > 0 && extension == ".jpg") {
+		return transcodeImage(blob, dstPath, jpegQuality)
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to create thumbnail dir")
+	}
+	return os.WriteFile(dstPath, blob, 0644)
+}
+
+// canRenderPDF reports whether pdf thumbnails can be generated.
+func (g *thumbnailGenerator) canRenderPDF() bool {
+	return g.pdfRendererPath != ""
+}
+
+// generatePDFThumbnail renders the first page of the pdf src as a png image at dstPath.
+func (g *thumbnailGenerator) generatePDFThumbnail(src *thumbnailSource, dstPath string, jpegQuality int) error {
+	if !g.canRenderPDF() {
+		return errors.New("pdf renderer is not available")
+	}
+
+	tempDir, err := os.MkdirTemp("", "memos-pdf-thumbnail")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp dir")
+	}
+	defer os.RemoveAll(tempDir)
+	srcPath, err := src.filePath(tempDir, "source.pdf")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pdfRenderTimeout)
+	defer cancel()
+	outputPrefix := filepath.Join(tempDir, "page")
+	cmd := exec.CommandContext(ctx, g.pdfRendererPath, "-f", "1", "-l", "1", "-singlefile", "-png", "-scale-to", strconv.Itoa(thumbnailWidth), srcPath, outputPrefix)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to render pdf page: %s", strings.TrimSpace(string(output)))
+	}
+
+	pageBlob, err := os.ReadFile(outputPrefix + ".png")
+	if err != nil {
+		return errors.Wrap(err, "failed to read rendered pdf page")
+	}
+	if err := saveThumbnail(pageBlob, ".png", dstPath, jpegQuality); err != nil {
+		return errors.Wrap(err, "failed to write pdf thumbnail")
+	}
+	return nil
+}
+
+// canExtractVideoFrame reports whether video poster thumbnails can be generated.
+func (g *thumbnailGenerator) canExtractVideoFrame() bool {
+	return g.ffmpegPath != ""
+}
+
+// generateVideoThumbnail extracts a frame at about one second of the video src as a jpeg image at dstPath.
+// Local videos are read by ffmpeg from their file, others are streamed to a temp file first.
+func (g *thumbnailGenerator) generateVideoThumbnail(src *thumbnailSource, dstPath string, jpegQuality int) error {
+	if !g.canExtractVideoFrame() {
+		return errors.New("ffmpeg is not available")
+	}
+
+	tempDir, err := os.MkdirTemp("", "memos-video-thumbnail")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp dir")
+	}
+	defer os.RemoveAll(tempDir)
+	srcPath, err := src.filePath(tempDir, "source")
+	if err != nil {
+		return err
+	}
+
+	framePath := filepath.Join(tempDir, "frame.jpg")
+	// Videos shorter than the seek offset produce no frame, so retry with the first frame.
+	for _, offset := range []string{"1", "0"} {
+		if err := g.extractVideoFrame(srcPath, framePath, offset); err != nil {
+			return err
+		}
+		if _, err := os.Stat(framePath); err == nil {
+			break
+		}
+	}
+
+	frameBlob, err := os.ReadFile(framePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to read extracted video frame")
+	}
+	if err := saveThumbnail(frameBlob, ".jpg", dstPath, jpegQuality); err != nil {
+		return errors.Wrap(err, "failed to write video thumbnail")
+	}
+	return nil
+}
+
+func (g *thumbnailGenerator) extractVideoFrame(srcPath, framePath, offset string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), videoFrameTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, g.ffmpegPath, "-y", "-loglevel", "error", "-ss", offset, "-i", srcPath, "-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:-2", thumbnailWidth), framePath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to extract video frame: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
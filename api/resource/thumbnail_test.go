@@ -0,0 +1,226 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/usememos/memos/server/profile"
+)
+
+func TestThumbnailGeneratorCoalescesRequests(t *testing.T) {
+	dstPath := filepath.Join(t.TempDir(), "thumbnail.png")
+	generator := newThumbnailGenerator(4)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var generated int32
+	generate := func(dstPath string) error {
+		if atomic.AddInt32(&generated, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return os.WriteFile(dstPath, []byte("thumbnail"), 0644)
+	}
+
+	const requests = 8
+	var wg sync.WaitGroup
+	results := make([][]byte, requests)
+	errs := make([]error, requests)
+	run := func(i int) {
+		defer wg.Done()
+		results[i], errs[i] = generator.getOrGenerate(dstPath, generate)
+	}
+	wg.Add(requests)
+	go run(0)
+	<-started
+	for i := 1; i < requests; i++ {
+		go run(i)
+	}
+	// Give the other requests time to join the in-flight generation.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&generated))
+	for i := 0; i < requests; i++ {
+		require.NoError(t, errs[i])
+		require.Equal(t, []byte("thumbnail"), results[i])
+	}
+}
+
+func TestThumbnailGeneratorWaitTimeout(t *testing.T) {
+	dir := t.TempDir()
+	generator := newThumbnailGenerator(1)
+	generator.waitTimeout = 10 * time.Millisecond
+	started := make(chan struct{})
+	release := make(chan struct{})
+	generate := func(dstPath string) error {
+		close(started)
+		<-release
+		return os.WriteFile(dstPath, []byte("thumbnail"), 0644)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := generator.getOrGenerate(filepath.Join(dir, "first.png"), generate)
+		done <- err
+	}()
+	<-started
+
+	_, err := generator.getOrGenerate(filepath.Join(dir, "second.png"), generate)
+	require.Error(t, err)
+
+	close(release)
+	require.NoError(t, <-done)
+}
+
+func TestThumbnailGeneratorSingleDecode(t *testing.T) {
+	dstPath := filepath.Join(t.TempDir(), "thumbnail.png")
+	generator := newThumbnailGenerator(4)
+	source := &bytes.Buffer{}
+	require.NoError(t, png.Encode(source, image.NewRGBA(image.Rect(0, 0, 1024, 768))))
+
+	var decoded int32
+	generate := func(dstPath string) error {
+		atomic.AddInt32(&decoded, 1)
+		// Keep the generation in flight long enough for all requests to arrive.
+		time.Sleep(50 * time.Millisecond)
+		return generateThumbnailImage(source.Bytes(), dstPath, 0)
+	}
+
+	// The thumbnail must never be observed half-written.
+	stop := make(chan struct{})
+	observed := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				observed <- nil
+				return
+			default:
+			}
+			if blob, err := os.ReadFile(dstPath); err == nil {
+				if _, err := png.Decode(bytes.NewReader(blob)); err != nil {
+					observed <- err
+					return
+				}
+			}
+		}
+	}()
+
+	const requests = 32
+	var wg sync.WaitGroup
+	errs := make([]error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = generator.getOrGenerate(dstPath, generate)
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+
+	require.NoError(t, <-observed)
+	require.Equal(t, int32(1), atomic.LoadInt32(&decoded))
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(dstPath))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestThumbnailGeneratorShutdownWaits(t *testing.T) {
+	dstPath := filepath.Join(t.TempDir(), "thumbnail.png")
+	generator := newThumbnailGenerator(4)
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		_, _ = generator.getOrGenerate(dstPath, func(dstPath string) error {
+			close(started)
+			<-release
+			return os.WriteFile(dstPath, []byte("thumbnail"), 0644)
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Error(t, generator.wait(ctx))
+
+	close(release)
+	require.NoError(t, generator.wait(context.Background()))
+	require.FileExists(t, dstPath)
+}
+
+func TestThumbnailGeneratorAfterWait(t *testing.T) {
+	dstPath := filepath.Join(t.TempDir(), "thumbnail.png")
+	generator := newThumbnailGenerator(4)
+	require.NoError(t, generator.wait(context.Background()))
+
+	// Generations started after the shutdown wait are refused rather than racing it.
+	_, err := generator.getOrGenerate(dstPath, func(dstPath string) error {
+		return os.WriteFile(dstPath, []byte("thumbnail"), 0644)
+	})
+	require.Error(t, err)
+	require.NoFileExists(t, dstPath)
+}
+
+func TestNewResourceServiceRemovesThumbnailTempFiles(t *testing.T) {
+	data := t.TempDir()
+	thumbnailDir := filepath.Join(data, thumbnailImagePath)
+	require.NoError(t, os.MkdirAll(thumbnailDir, os.ModePerm))
+	tempPath := filepath.Join(thumbnailDir, thumbnailTempPrefix+"1700000000-1-1700000000.png")
+	thumbnailPath := filepath.Join(thumbnailDir, "1-1700000000.png")
+	require.NoError(t, os.WriteFile(tempPath, []byte("partial"), 0644))
+	require.NoError(t, os.WriteFile(thumbnailPath, []byte("thumbnail"), 0644))
+
+	NewResourceService(&profile.Profile{Data: data}, nil)
+	require.NoFileExists(t, tempPath)
+	require.FileExists(t, thumbnailPath)
+}
+
+func TestFindThumbnailType(t *testing.T) {
+	require.Equal(t, thumbnailTypes["image/png"], findThumbnailType("image/png"))
+	require.Equal(t, thumbnailTypes["application/pdf"], findThumbnailType("application/pdf"))
+	require.Equal(t, thumbnailTypes["video/"], findThumbnailType("video/mp4"))
+	require.Nil(t, findThumbnailType("image/gif"))
+	require.Nil(t, findThumbnailType("text/plain"))
+
+	registerThumbnailType("video/webm", &thumbnailType{extension: ".png"})
+	defer delete(thumbnailTypes, "video/webm")
+	require.Equal(t, ".png", findThumbnailType("video/webm").extension)
+	require.Equal(t, ".jpg", findThumbnailType("video/mp4").extension)
+}
+
+func TestGenerateThumbnailImageJPEGQuality(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1024, 768))
+	random := rand.New(rand.NewSource(1))
+	random.Read(img.Pix)
+	source := &bytes.Buffer{}
+	require.NoError(t, png.Encode(source, img))
+
+	dir := t.TempDir()
+	sizes := map[int]int64{}
+	for _, quality := range []int{0, 95, 10} {
+		dstPath := filepath.Join(dir, fmt.Sprintf("thumbnail-%d.jpg", quality))
+		require.NoError(t, generateThumbnailImage(source.Bytes(), dstPath, quality))
+		stat, err := os.Stat(dstPath)
+		require.NoError(t, err)
+		sizes[quality] = stat.Size()
+	}
+	// The default quality of imaging is 95.
+	require.Equal(t, sizes[95], sizes[0])
+	require.Less(t, sizes[10], sizes[95])
+}
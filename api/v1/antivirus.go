@@ -0,0 +1,143 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/usememos/memos/internal/log"
+	"github.com/usememos/memos/plugin/clamav"
+	"github.com/usememos/memos/store"
+)
+
+// errAntivirusUnavailable is returned when an upload can't be scanned and the antivirus fails closed.
+var errAntivirusUnavailable = errors.New("antivirus is unavailable")
+
+// resourceInfectedError is returned when the antivirus finds a threat in an upload.
+type resourceInfectedError struct {
+	signature string
+}
+
+func (e *resourceInfectedError) Error() string {
+	return fmt.Sprintf("file is infected: %s", e.signature)
+}
+
+// getAntivirus returns the antivirus config of the workspace, nil when uploads aren't scanned.
+func getAntivirus(ctx context.Context, s *store.Store) (*Antivirus, error) {
+	value := s.GetWorkspaceSettingWithDefaultValue(ctx, SystemSettingAntivirusName.String(), "")
+	if value == "" {
+		return nil, nil
+	}
+	antivirus := &Antivirus{}
+	if err := json.Unmarshal([]byte(value), antivirus); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal antivirus")
+	}
+	if antivirus.Address == "" {
+		return nil, nil
+	}
+	return antivirus, nil
+}
+
+// scanningReader tees the upload read from it to the antivirus, so the blob is scanned while it's stored.
+// The read reaching the end of the upload waits for the verdict and fails when the upload is infected,
+// so the storages never complete an infected blob.
+type scanningReader struct {
+	src        io.Reader
+	pipeWriter *io.PipeWriter
+	results    chan scanResult
+	failClosed bool
+	// teeFailed is set once the antivirus stopped reading the upload.
+	teeFailed bool
+	// err is returned by every read once the verdict is known.
+	err error
+}
+
+type scanResult struct {
+	result *clamav.Result
+	err    error
+}
+
+// newScanningReader starts scanning src with the antivirus, the scan stops when ctx is done.
+func newScanningReader(ctx context.Context, antivirus *Antivirus, src io.Reader) *scanningReader {
+	pipeReader, pipeWriter := io.Pipe()
+	r := &scanningReader{
+		src:        src,
+		pipeWriter: pipeWriter,
+		results:    make(chan scanResult, 1),
+		failClosed: antivirus.FailClosed,
+	}
+	// Unblock the scan when the upload is abandoned before its end.
+	stop := context.AfterFunc(ctx, func() {
+		pipeWriter.CloseWithError(ctx.Err())
+	})
+	go func() {
+		defer stop()
+		client := &clamav.Client{Address: antivirus.Address}
+		result, err := client.ScanStream(ctx, pipeReader)
+		if err != nil {
+			pipeReader.CloseWithError(err)
+		} else {
+			pipeReader.Close()
+		}
+		r.results <- scanResult{result: result, err: err}
+	}()
+	return r
+}
+
+func (r *scanningReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.src.Read(p)
+	if n > 0 && !r.teeFailed {
+		if _, err := r.pipeWriter.Write(p[:n]); err != nil {
+			r.teeFailed = true
+		}
+	}
+	if err == io.EOF {
+		r.pipeWriter.Close()
+		r.err = r.verdict()
+		if r.err == nil {
+			r.err = io.EOF
+		}
+		return n, r.err
+	}
+	if err != nil {
+		r.pipeWriter.CloseWithError(err)
+	}
+	return n, err
+}
+
+// verdict waits for the scan result and returns the error failing the upload, if any.
+func (r *scanningReader) verdict() error {
+	result := <-r.results
+	if result.err != nil {
+		if r.failClosed {
+			return errors.Wrap(errAntivirusUnavailable, result.err.Error())
+		}
+		log.Warn("Failed to scan upload, storing it unscanned", zap.Error(result.err))
+		return nil
+	}
+	if result.result.Infected {
+		return &resourceInfectedError{signature: result.result.Signature}
+	}
+	return nil
+}
+
+// convertScanError returns the HTTP error of an upload rejected by the antivirus, nil for other errors.
+func convertScanError(err error) *echo.HTTPError {
+	infectedErr := &resourceInfectedError{}
+	if errors.As(err, &infectedErr) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("File is infected: %s", infectedErr.signature)).SetInternal(err)
+	}
+	if errors.Is(err, errAntivirusUnavailable) {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Failed to scan file").SetInternal(err)
+	}
+	return nil
+}
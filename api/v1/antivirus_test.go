@@ -0,0 +1,243 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lithammer/shortuuid/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usememos/memos/store"
+	teststore "github.com/usememos/memos/test/store"
+)
+
+// startFakeClamd answers INSTREAM scans like clamd, the streams containing EICAR are infected.
+// beforeReply, when set, is called once a stream is received, before its verdict is sent.
+func startFakeClamd(t *testing.T, beforeReply func()) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := io.ReadFull(conn, make([]byte, len("zINSTREAM\x00"))); err != nil {
+					return
+				}
+				content := &bytes.Buffer{}
+				for {
+					var length uint32
+					if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+						return
+					}
+					if length == 0 {
+						break
+					}
+					if _, err := io.CopyN(content, conn, int64(length)); err != nil {
+						return
+					}
+				}
+				if beforeReply != nil {
+					beforeReply()
+				}
+				reply := "stream: OK\x00"
+				if strings.Contains(content.String(), "EICAR") {
+					reply = "stream: Eicar-Test-Signature FOUND\x00"
+				}
+				_, _ = conn.Write([]byte(reply))
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestSaveResourceBlobAntivirus(t *testing.T) {
+	address := startFakeClamd(t, nil)
+	// Nothing listens on the address of a closed listener.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unavailableAddress := listener.Addr().String()
+	listener.Close()
+
+	tests := []struct {
+		antivirus  Antivirus
+		storageID  int32
+		content    string
+		wantStatus int
+	}{
+		{antivirus: Antivirus{Address: address}, storageID: DatabaseStorage, content: "hello"},
+		{antivirus: Antivirus{Address: address}, storageID: DatabaseStorage, content: "EICAR", wantStatus: http.StatusUnprocessableEntity},
+		{antivirus: Antivirus{Address: address}, storageID: LocalStorage, content: "hello"},
+		{antivirus: Antivirus{Address: address}, storageID: LocalStorage, content: "EICAR", wantStatus: http.StatusUnprocessableEntity},
+		{antivirus: Antivirus{Address: unavailableAddress}, storageID: LocalStorage, content: "hello"},
+		{antivirus: Antivirus{Address: unavailableAddress, FailClosed: true}, storageID: LocalStorage, content: "hello", wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		ctx := context.Background()
+		ts := teststore.NewTestingStore(ctx, t)
+		s := NewAPIV1Service("", ts.Profile, ts, nil)
+		antivirus, err := json.Marshal(test.antivirus)
+		require.NoError(t, err)
+		storageID, err := json.Marshal(test.storageID)
+		require.NoError(t, err)
+		for name, value := range map[SystemSettingName][]byte{SystemSettingAntivirusName: antivirus, SystemSettingStorageServiceIDName: storageID} {
+			_, err := ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: name.String(), Value: string(value)})
+			require.NoError(t, err)
+		}
+
+		create := &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     "test.txt",
+			Type:         "text/plain",
+			Size:         int64(len(test.content)),
+		}
+		err = s.SaveResourceBlob(ctx, create, strings.NewReader(test.content))
+		if err == nil {
+			_, err = ts.CreateResource(ctx, create)
+		}
+		if test.wantStatus == 0 {
+			require.NoError(t, err, "%+v", test)
+		} else {
+			require.Error(t, err, "%+v", test)
+			httpErr := convertScanError(err)
+			require.NotNil(t, httpErr, "%+v", test)
+			require.Equal(t, test.wantStatus, httpErr.Code)
+		}
+
+		// Rejected uploads are never stored.
+		resources, err := ts.ListResources(ctx, &store.FindResource{})
+		require.NoError(t, err)
+		if test.wantStatus == 0 {
+			require.Len(t, resources, 1)
+		} else {
+			require.Empty(t, resources)
+			entries, err := os.ReadDir(filepath.Join(ts.Profile.Data, "assets"))
+			if !os.IsNotExist(err) {
+				require.NoError(t, err)
+			}
+			require.Empty(t, entries)
+		}
+		ts.Close()
+	}
+}
+
+func TestDatabaseResourceHiddenUntilScanned(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	resourceName := shortuuid.New()
+	mutex, peekedContents := sync.Mutex{}, []string{}
+	address := startFakeClamd(t, func() {
+		resource, err := ts.GetResource(ctx, &store.FindResource{ResourceName: &resourceName})
+		if err != nil || resource == nil {
+			return
+		}
+		reader, err := ts.GetResourceContent(ctx, resource)
+		if err != nil {
+			return
+		}
+		defer reader.Close()
+		content, _ := io.ReadAll(reader)
+		mutex.Lock()
+		defer mutex.Unlock()
+		peekedContents = append(peekedContents, string(content))
+	})
+	antivirus, err := json.Marshal(Antivirus{Address: address})
+	require.NoError(t, err)
+	_, err = ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: SystemSettingAntivirusName.String(), Value: string(antivirus)})
+	require.NoError(t, err)
+
+	// Larger than a blob chunk, so most of it is stored before the verdict.
+	content := strings.Repeat("EICAR ", 3*MebiByte/6)
+	create := &store.Resource{
+		ResourceName: resourceName,
+		CreatorID:    101,
+		Filename:     "test.txt",
+		Type:         "text/plain",
+		Size:         int64(len(content)),
+	}
+	require.NoError(t, s.SaveResourceBlob(ctx, create, strings.NewReader(content)))
+	_, err = ts.CreateResource(ctx, create)
+	httpErr := convertScanError(err)
+	require.NotNil(t, httpErr)
+	require.Equal(t, http.StatusUnprocessableEntity, httpErr.Code)
+	// The infected content can't be read while it's scanned.
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Equal(t, []string{""}, peekedContents)
+}
+
+func TestReplaceResourceWithInfectedUpload(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	antivirus, err := json.Marshal(Antivirus{Address: startFakeClamd(t, nil)})
+	require.NoError(t, err)
+	_, err = ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: SystemSettingAntivirusName.String(), Value: string(antivirus)})
+	require.NoError(t, err)
+
+	content := "previous content"
+	create := &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "notes.txt",
+		Type:         "text/plain",
+		Size:         int64(len(content)),
+	}
+	require.NoError(t, s.SaveResourceBlob(ctx, create, strings.NewReader(content)))
+	resource, err := ts.CreateResource(ctx, create)
+	require.NoError(t, err)
+
+	// Larger than a blob chunk, so most of it is stored before the verdict.
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "infected.bin")
+	require.NoError(t, err)
+	_, err = part.Write([]byte(strings.Repeat("EICAR ", 3*MebiByte/6)))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/resource/blob", body)
+	request.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	file, sourceFile, err := s.openUploadFile(echo.New().NewContext(request, httptest.NewRecorder()))
+	require.NoError(t, err)
+	defer sourceFile.Close()
+
+	_, err = s.replaceResourceBlob(ctx, resource, file, sourceFile, nil)
+	httpErr, ok := err.(*echo.HTTPError)
+	require.True(t, ok)
+	require.Equal(t, http.StatusUnprocessableEntity, httpErr.Code)
+
+	// The resource still serves its previous content.
+	current, err := ts.GetResource(ctx, &store.FindResource{ID: &resource.ID})
+	require.NoError(t, err)
+	require.Equal(t, resource.Filename, current.Filename)
+	require.Equal(t, resource.Type, current.Type)
+	require.Equal(t, resource.Size, current.Size)
+	require.Equal(t, resource.Sha256, current.Sha256)
+	reader, err := ts.GetResourceContent(ctx, current)
+	require.NoError(t, err)
+	defer reader.Close()
+	blob, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, content, string(blob))
+}
@@ -1,18 +1,23 @@
 package v1
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 
 	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
 
+	"github.com/usememos/memos/internal/util"
 	getter "github.com/usememos/memos/plugin/http-getter"
 )
 
-func (*APIV1Service) registerGetterPublicRoutes(g *echo.Group) {
+func (s *APIV1Service) registerGetterPublicRoutes(g *echo.Group) {
 	// GET /get/image?url={url} - Get image.
-	g.GET("/get/image", GetImage)
+	g.GET("/get/image", s.GetImage)
 }
 
 // GetImage godoc
@@ -23,9 +28,9 @@ func (*APIV1Service) registerGetterPublicRoutes(g *echo.Group) {
 //	@Param		url	query		string	true	"Image url"
 //	@Success	200	{object}	nil		"Image"
 //	@Failure	400	{object}	nil		"Missing GetImage url | Wrong url | Failed to get GetImage url: %s"
-//	@Failure	500	{object}	nil		"Failed to write GetImage blob"
+//	@Failure	500	{object}	nil		"Failed to get external link blocklist | Failed to write GetImage blob"
 //	@Router		/o/get/GetImage [GET]
-func GetImage(c echo.Context) error {
+func (s *APIV1Service) GetImage(c echo.Context) error {
 	urlStr := c.QueryParam("url")
 	if urlStr == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "Missing image url")
@@ -34,7 +39,11 @@ func GetImage(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Wrong url").SetInternal(err)
 	}
 
-	image, err := getter.GetImage(urlStr)
+	blocklist, err := s.getExternalLinkBlocklist(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get external link blocklist").SetInternal(err)
+	}
+	image, err := getter.GetImage(urlStr, blocklist)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Failed to get image url: %s", urlStr)).SetInternal(err)
 	}
@@ -47,3 +56,16 @@ func GetImage(c echo.Context) error {
 	}
 	return nil
 }
+
+// getExternalLinkBlocklist returns the address ranges the server must not fetch URLs from.
+// The default blocklist is used unless the workspace overrides it, an empty list disables the check.
+func (s *APIV1Service) getExternalLinkBlocklist(ctx context.Context) ([]*net.IPNet, error) {
+	cidrs := util.DefaultExternalLinkBlocklist
+	blocklistSetting := s.Store.GetWorkspaceSettingWithDefaultValue(ctx, SystemSettingExternalLinkBlocklistName.String(), "")
+	if blocklistSetting != "" {
+		if err := json.Unmarshal([]byte(blocklistSetting), &cidrs); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal external link blocklist")
+		}
+	}
+	return util.ParseIPNets(cidrs)
+}
@@ -0,0 +1,92 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	// exifOrientationTag is the ID of the orientation tag in the EXIF IFD0.
+	exifOrientationTag = 0x0112
+	// exifShortType is the type ID of 16-bit unsigned EXIF values.
+	exifShortType = 3
+)
+
+// readJPEGOrientation returns the EXIF orientation of the JPEG image, from 1 to 8, or 0 when it has none.
+// Only the markers before the image data are read.
+func readJPEGOrientation(src []byte) int {
+	if len(src) < 2 || src[0] != 0xFF || src[1] != 0xD8 {
+		return 0
+	}
+	for i := 2; i+4 <= len(src); {
+		if src[i] != 0xFF {
+			return 0
+		}
+		marker := src[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte before the marker.
+			i++
+			continue
+		case marker == 0xD9 || marker == 0xDA:
+			// End of image or start of the image data.
+			return 0
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Markers without a segment.
+			i += 2
+			continue
+		}
+		size := int(binary.BigEndian.Uint16(src[i+2:]))
+		if size < 2 || i+2+size > len(src) {
+			return 0
+		}
+		segment := src[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return readExifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 0
+}
+
+// readExifOrientation returns the orientation tag of the EXIF TIFF structure, or 0 when it has none.
+func readExifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 0
+	}
+	offset := order.Uint32(tiff[4:])
+	if offset < 8 || uint64(offset)+2 > uint64(len(tiff)) {
+		return 0
+	}
+	entries := tiff[offset+2:]
+	for i := 0; i < int(order.Uint16(tiff[offset:])); i++ {
+		if len(entries) < (i+1)*12 {
+			return 0
+		}
+		entry := entries[i*12 : (i+1)*12]
+		if order.Uint16(entry) != exifOrientationTag {
+			continue
+		}
+		if order.Uint16(entry[2:]) != exifShortType {
+			return 0
+		}
+		orientation := int(order.Uint16(entry[8:]))
+		if orientation < 1 || orientation > 8 {
+			return 0
+		}
+		return orientation
+	}
+	return 0
+}
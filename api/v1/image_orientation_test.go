@@ -0,0 +1,113 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// withExifOrientation inserts an EXIF segment with the orientation tag after the SOI marker of the JPEG.
+func withExifOrientation(t *testing.T, src []byte, order binary.ByteOrder, orientation uint16) []byte {
+	tiff := &bytes.Buffer{}
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	for _, value := range []any{uint16(42), uint32(8), uint16(1), uint16(exifOrientationTag), uint16(exifShortType), uint32(1), orientation, uint16(0), uint32(0)} {
+		require.NoError(t, binary.Write(tiff, order, value))
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+
+	dst := append([]byte{}, src[:2]...)
+	dst = append(dst, app1...)
+	dst = append(dst, segment...)
+	return append(dst, src[2:]...)
+}
+
+func TestReadJPEGOrientation(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, jpeg.Encode(buf, image.NewRGBA(image.Rect(0, 0, 2, 1)), nil))
+	src := buf.Bytes()
+
+	require.Equal(t, 0, readJPEGOrientation(src))
+	require.Equal(t, 6, readJPEGOrientation(withExifOrientation(t, src, binary.BigEndian, 6)))
+	require.Equal(t, 8, readJPEGOrientation(withExifOrientation(t, src, binary.LittleEndian, 8)))
+	require.Equal(t, 0, readJPEGOrientation(withExifOrientation(t, src, binary.BigEndian, 42)))
+	require.Equal(t, 0, readJPEGOrientation([]byte("not a jpeg")))
+	// Truncated segments are ignored.
+	require.Equal(t, 0, readJPEGOrientation(withExifOrientation(t, src, binary.BigEndian, 6)[:12]))
+}
+
+func TestProcessImageBlob(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, jpeg.Encode(buf, image.NewRGBA(image.Rect(0, 0, 2, 1)), nil))
+	upright := buf.Bytes()
+	rotated := withExifOrientation(t, upright, binary.BigEndian, 6)
+	buf = &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 2, 1))))
+	pngImage := buf.Bytes()
+
+	tests := []struct {
+		src           []byte
+		mimeType      string
+		stripMetadata bool
+		wantUnchanged bool
+		wantBounds    image.Rectangle
+	}{
+		{src: upright, mimeType: "image/jpeg", wantUnchanged: true, wantBounds: image.Rect(0, 0, 2, 1)},
+		{src: rotated, mimeType: "image/jpeg", wantBounds: image.Rect(0, 0, 1, 2)},
+		{src: pngImage, mimeType: "image/png", wantUnchanged: true, wantBounds: image.Rect(0, 0, 2, 1)},
+		{src: upright, mimeType: "image/jpeg", stripMetadata: true, wantBounds: image.Rect(0, 0, 2, 1)},
+		{src: rotated, mimeType: "image/jpeg", stripMetadata: true, wantBounds: image.Rect(0, 0, 1, 2)},
+	}
+	for _, test := range tests {
+		blob, err := processImageBlob(bytes.NewReader(test.src), test.mimeType, 1*MebiByte, test.stripMetadata)
+		require.NoError(t, err)
+		if test.wantUnchanged {
+			require.Equal(t, test.src, blob)
+		} else {
+			require.NotEqual(t, test.src, blob)
+			// The orientation tag is dropped with the rotation applied.
+			require.Equal(t, 0, readJPEGOrientation(blob))
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(blob))
+		require.NoError(t, err)
+		require.Equal(t, test.wantBounds, image.Rect(0, 0, config.Width, config.Height))
+	}
+
+	_, err := processImageBlob(bytes.NewReader(rotated), "image/jpeg", int64(len(rotated)-1), false)
+	httpErr := convertUploadSizeError(err)
+	require.NotNil(t, httpErr)
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+
+	_, err = processImageBlob(bytes.NewReader(withPNGSize(t, pngImage, 20000, 10000)), "image/png", 1*MebiByte, true)
+	httpErr = convertImageError(err)
+	require.NotNil(t, httpErr)
+	require.Equal(t, http.StatusRequestEntityTooLarge, httpErr.Code)
+
+	_, err = processImageBlob(bytes.NewReader([]byte("not an image")), "image/png", 1*MebiByte, true)
+	httpErr = convertImageError(err)
+	require.NotNil(t, httpErr)
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+}
+
+// withPNGSize returns the PNG with the dimensions of its header replaced, without its pixels.
+func withPNGSize(t *testing.T, src []byte, width, height uint32) []byte {
+	// The IHDR chunk follows the 8 bytes signature: length, type, width, height, 5 more bytes and the CRC.
+	require.Equal(t, "IHDR", string(src[12:16]))
+	dst := append([]byte{}, src...)
+	binary.BigEndian.PutUint32(dst[16:20], width)
+	binary.BigEndian.PutUint32(dst[20:24], height)
+	binary.BigEndian.PutUint32(dst[29:33], crc32.ChecksumIEEE(dst[12:29]))
+	return dst
+}
@@ -0,0 +1,33 @@
+package v1
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+
+	"github.com/usememos/memos/store"
+)
+
+// isLocalStorageCompressed reports whether a blob of the MIME type is compressed when saved in the local storage.
+func isLocalStorageCompressed(ctx context.Context, s *store.Store, mimeType string) bool {
+	if s.GetWorkspaceSettingWithDefaultValue(ctx, SystemSettingLocalStorageCompressionName.String(), "false") != "true" {
+		return false
+	}
+	return store.IsCompressibleResourceType(mimeType)
+}
+
+// newCompressingReader returns the gzip compressed content of r, compressed while read.
+// Errors of r, like an exceeded size or an infected content, are returned as-is by Read.
+// It must be closed to release the compression when it's not read to the end.
+func newCompressingReader(r io.Reader) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		writer := gzip.NewWriter(pipeWriter)
+		_, err := io.Copy(writer, r)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+	return pipeReader
+}
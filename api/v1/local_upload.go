@@ -0,0 +1,205 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/usememos/memos/internal/log"
+	"github.com/usememos/memos/internal/util"
+	"github.com/usememos/memos/server/profile"
+	"github.com/usememos/memos/store"
+)
+
+const (
+	// defaultLocalUploadBufferSize is the default size of the buffer used to copy uploads to the local disk.
+	defaultLocalUploadBufferSize = 32 * 1024
+	// localUploadTempSuffixLength is the length of the random suffix of local upload temp files.
+	localUploadTempSuffixLength = 8
+)
+
+// localUploadTempPattern matches the temp files uploads are written to before being moved in place.
+var localUploadTempPattern = regexp.MustCompile(fmt.Sprintf(`\.tmp\.[0-9a-zA-Z]{%d}$`, localUploadTempSuffixLength))
+
+// localUploadLimiter bounds the amount of uploads written to the local disk at the same time.
+type localUploadLimiter struct {
+	// semaphore is nil when the amount of writes isn't limited.
+	semaphore  chan struct{}
+	bufferSize int
+	// inflight tracks the uploads being written.
+	inflight sync.WaitGroup
+	// mutex guards closed, so no upload is added to inflight once it's waited for.
+	mutex  sync.Mutex
+	closed bool
+	// preparedRoots are the storage roots known to be writable.
+	preparedRoots sync.Map
+}
+
+func newLocalUploadLimiter(concurrency int, bufferSize int) *localUploadLimiter {
+	if bufferSize <= 0 {
+		bufferSize = defaultLocalUploadBufferSize
+	}
+	limiter := &localUploadLimiter{
+		bufferSize: bufferSize,
+	}
+	if concurrency > 0 {
+		limiter.semaphore = make(chan struct{}, concurrency)
+	}
+	return limiter
+}
+
+// copy writes src to dst once a write slot is available, waiting until ctx is done otherwise.
+func (l *localUploadLimiter) copy(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	if l.semaphore != nil {
+		select {
+		case l.semaphore <- struct{}{}:
+		case <-ctx.Done():
+			return 0, errors.Wrap(ctx.Err(), "failed to wait for an available local upload slot")
+		}
+		defer func() {
+			<-l.semaphore
+		}()
+	}
+
+	// Hide ReadFrom and WriteTo so the configured buffer is actually used.
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, l.bufferSize))
+}
+
+// writeFile writes src to a temp file next to path and moves it in place once complete,
+// so an interrupted upload never leaves a truncated file at path.
+func (l *localUploadLimiter) writeFile(ctx context.Context, path string, src io.Reader) (int64, error) {
+	if err := l.begin(); err != nil {
+		return 0, err
+	}
+	defer l.inflight.Done()
+
+	suffix, err := util.RandomString(localUploadTempSuffixLength)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to generate temp file name")
+	}
+	tempPath := fmt.Sprintf("%s.tmp.%s", path, suffix)
+	dst, err := os.Create(tempPath)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create file")
+	}
+	size, err := l.copy(ctx, dst, src)
+	if closeErr := dst.Close(); err == nil && closeErr != nil {
+		err = errors.Wrap(closeErr, "failed to close file")
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return size, err
+	}
+	return size, nil
+}
+
+// begin adds an upload to the in-flight ones, it fails once the limiter is waited for.
+func (l *localUploadLimiter) begin() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return errors.New("local uploads are shut down")
+	}
+	l.inflight.Add(1)
+	return nil
+}
+
+// wait blocks until the in-flight uploads are written or ctx is done.
+// No upload is written afterwards.
+func (l *localUploadLimiter) wait(ctx context.Context) error {
+	l.mutex.Lock()
+	l.closed = true
+	l.mutex.Unlock()
+	done := make(chan struct{})
+	go func() {
+		l.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "failed to wait for in-flight local uploads")
+	}
+}
+
+// RemoveLocalUploadTempFiles removes the temp files left in the local storage by interrupted uploads.
+// It's meant to run on startup, before any upload is in flight.
+func RemoveLocalUploadTempFiles(ctx context.Context, s *store.Store) (int, error) {
+	localStoragePath := "assets/{timestamp}_{filename}"
+	localStoragePathSetting := s.GetWorkspaceSettingWithDefaultValue(ctx, SystemSettingLocalStoragePathName.String(), "")
+	if localStoragePathSetting != "" {
+		if err := json.Unmarshal([]byte(localStoragePathSetting), &localStoragePath); err != nil {
+			return 0, errors.Wrap(err, "failed to unmarshal local storage path")
+		}
+	}
+	root := localStorageRoot(s.Profile, localStoragePath)
+
+	removed := 0
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() || !localUploadTempPattern.MatchString(entry.Name()) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			log.Warn("Failed to remove local upload temp file", zap.String("path", path), zap.Error(err))
+			return nil
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, errors.Wrap(err, "failed to walk local storage")
+	}
+	return removed, nil
+}
+
+// localStorageRoot returns the directory all the files of the local storage path template are written in.
+func localStorageRoot(profile *profile.Profile, localStoragePath string) string {
+	root := filepath.FromSlash(PathTemplatePrefix(localStoragePath))
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(profile.Data, root)
+	}
+	return filepath.Clean(root)
+}
+
+// prepareRoot creates the storage root and checks that it's writable, once per root.
+// Failures are not remembered, so the next upload tries again once the root is fixed.
+func (l *localUploadLimiter) prepareRoot(root string) error {
+	if _, ok := l.preparedRoots.Load(root); ok {
+		return nil
+	}
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "local storage root %s can't be created", root)
+	}
+	probe, err := os.CreateTemp(root, ".memos-write-check-*")
+	if err != nil {
+		return errors.Wrapf(err, "local storage root %s isn't writable", root)
+	}
+	probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return errors.Wrapf(err, "local storage root %s isn't writable", root)
+	}
+	l.preparedRoots.Store(root, struct{}{})
+	return nil
+}
@@ -0,0 +1,157 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/usememos/memos/server/profile"
+	teststore "github.com/usememos/memos/test/store"
+)
+
+// slowWriter records the amount of concurrent writers.
+type slowWriter struct {
+	inFlight    *atomic.Int32
+	maxInFlight *atomic.Int32
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	current := w.inFlight.Add(1)
+	defer w.inFlight.Add(-1)
+	for {
+		peak := w.maxInFlight.Load()
+		if current <= peak || w.maxInFlight.CompareAndSwap(peak, current) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return len(p), nil
+}
+
+func TestLocalUploadLimiter(t *testing.T) {
+	limiter := newLocalUploadLimiter(2, 4)
+	inFlight, maxInFlight := &atomic.Int32{}, &atomic.Int32{}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			size, err := limiter.copy(context.Background(), &slowWriter{inFlight: inFlight, maxInFlight: maxInFlight}, bytes.NewReader([]byte("12345678")))
+			require.NoError(t, err)
+			require.Equal(t, int64(8), size)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(2), maxInFlight.Load())
+}
+
+func TestLocalUploadLimiterContext(t *testing.T) {
+	limiter := newLocalUploadLimiter(1, 0)
+	limiter.semaphore <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := limiter.copy(ctx, io.Discard, bytes.NewReader([]byte("blocked")))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLocalUploadWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.txt")
+	limiter := newLocalUploadLimiter(1, 0)
+	size, err := limiter.writeFile(context.Background(), path, strings.NewReader("hello"))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), size)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(content))
+
+	// A failed upload leaves neither the file nor its temp file behind.
+	failedPath := filepath.Join(filepath.Dir(path), "failed.txt")
+	_, err = limiter.writeFile(context.Background(), failedPath, iotest.ErrReader(io.ErrUnexpectedEOF))
+	require.Error(t, err)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestLocalUploadWriteFileAfterWait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.txt")
+	limiter := newLocalUploadLimiter(1, 0)
+	require.NoError(t, limiter.wait(context.Background()))
+
+	// Uploads started after the shutdown wait are refused rather than racing it.
+	_, err := limiter.writeFile(context.Background(), path, strings.NewReader("hello"))
+	require.Error(t, err)
+	require.NoFileExists(t, path)
+}
+
+func TestLocalUploadLimiterPerService(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	limitedProfile := *ts.Profile
+	limitedProfile.LocalUploadConcurrency = 1
+	shutdown := NewAPIV1Service("", ts.Profile, ts, nil)
+	limited := NewAPIV1Service("", &limitedProfile, ts, nil)
+	require.Nil(t, shutdown.localUploadLimiter.semaphore)
+	require.Equal(t, 1, cap(limited.localUploadLimiter.semaphore))
+
+	// The shutdown of a service doesn't refuse the uploads of another one.
+	require.NoError(t, shutdown.Shutdown(ctx))
+	path := filepath.Join(t.TempDir(), "upload.txt")
+	_, err := limited.localUploadLimiter.writeFile(ctx, path, strings.NewReader("hello"))
+	require.NoError(t, err)
+	require.FileExists(t, path)
+}
+
+func TestRemoveLocalUploadTempFiles(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	assetsDir := filepath.Join(ts.Profile.Data, "assets", "2024")
+	require.NoError(t, os.MkdirAll(assetsDir, os.ModePerm))
+	tempPath := filepath.Join(assetsDir, "photo.png.tmp.a1B2c3D4")
+	uploadPath := filepath.Join(assetsDir, "photo.png")
+	similarPath := filepath.Join(assetsDir, "report.tmp.txt")
+	for _, path := range []string{tempPath, uploadPath, similarPath} {
+		require.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+	}
+
+	removed, err := RemoveLocalUploadTempFiles(ctx, ts)
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	require.NoFileExists(t, tempPath)
+	require.FileExists(t, uploadPath)
+	require.FileExists(t, similarPath)
+}
+
+func TestLocalUploadPrepareRoot(t *testing.T) {
+	data := t.TempDir()
+	limiter := newLocalUploadLimiter(0, 0)
+	root := localStorageRoot(&profile.Profile{Data: data}, "assets/{year}/{filename}")
+	require.Equal(t, filepath.Join(data, "assets"), root)
+	require.NoError(t, limiter.prepareRoot(root))
+	require.DirExists(t, root)
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// A file in place of the root can't be used until it's fixed.
+	blocked := filepath.Join(data, "blocked")
+	require.NoError(t, os.WriteFile(blocked, []byte("file"), 0644))
+	err = limiter.prepareRoot(blocked)
+	require.ErrorContains(t, err, "local storage root "+blocked+" can't be created")
+	require.NoError(t, os.Remove(blocked))
+	require.NoError(t, limiter.prepareRoot(blocked))
+}
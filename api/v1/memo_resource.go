@@ -0,0 +1,132 @@
+package v1
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/usememos/memos/internal/log"
+	"github.com/usememos/memos/internal/util"
+	"github.com/usememos/memos/store"
+)
+
+func (s *APIV1Service) registerMemoResourceRoutes(g *echo.Group) {
+	g.GET("/memo/:memoId/resources.zip", s.DownloadMemoResources)
+}
+
+// DownloadMemoResources godoc
+//
+//	@Summary	Download all resources of a memo as a zip archive
+//	@Tags		memo-resource
+//	@Produce	application/zip
+//	@Param		memoId	path		int		true	"ID of memo to download resources"
+//	@Success	200		{file}		file	"Zip archive of memo resources"
+//	@Failure	400		{object}	nil		"ID is not a number: %s"
+//	@Failure	403		{object}	nil		"this memo is private only | this memo is protected, missing user in session"
+//	@Failure	404		{object}	nil		"Memo not found: %d"
+//	@Failure	500		{object}	nil		"Failed to find memo by ID: %v | Failed to list resources"
+//	@Router		/api/v1/memo/{memoId}/resources.zip [GET]
+func (s *APIV1Service) DownloadMemoResources(c echo.Context) error {
+	ctx := c.Request().Context()
+	memoID, err := util.ConvertStringToInt32(c.Param("memoId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ID is not a number: %s", c.Param("memoId"))).SetInternal(err)
+	}
+
+	memo, err := s.Store.GetMemo(ctx, &store.FindMemo{
+		ID: &memoID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to find memo by ID: %v", memoID)).SetInternal(err)
+	}
+	if memo == nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Memo not found: %d", memoID))
+	}
+
+	userID, ok := c.Get(userIDContextKey).(int32)
+	if memo.Visibility == store.Private {
+		if !ok || memo.CreatorID != userID {
+			return echo.NewHTTPError(http.StatusForbidden, "this memo is private only")
+		}
+	} else if memo.Visibility == store.Protected {
+		if !ok {
+			return echo.NewHTTPError(http.StatusForbidden, "this memo is protected, missing user in session")
+		}
+	}
+
+	now := time.Now().Unix()
+	resources, err := s.Store.ListResources(ctx, &store.FindResource{
+		MemoID:       &memoID,
+		NotExpiredAt: &now,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to list resources").SetInternal(err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="memo-%d-resources.zip"`, memoID))
+	c.Response().WriteHeader(http.StatusOK)
+
+	// The archive is written straight to the response, one resource at a time,
+	// so only a single resource content is opened at any moment.
+	zipWriter := zip.NewWriter(c.Response())
+	usedNames := map[string]bool{}
+	for _, resource := range resources {
+		err := writeResourceToZip(c, s.Store, zipWriter, resource, uniqueZipEntryName(usedNames, resource))
+		if errors.Is(err, store.ErrResourceContentExternal) {
+			log.Warn("skip external resource in memo archive", zap.Int32("resource", resource.ID))
+			continue
+		}
+		if err != nil {
+			// The response status is already sent, so the archive is left truncated.
+			_ = zipWriter.Close()
+			return errors.Wrapf(err, "failed to write resource %d to archive", resource.ID)
+		}
+	}
+	return zipWriter.Close()
+}
+
+func writeResourceToZip(c echo.Context, s *store.Store, zipWriter *zip.Writer, resource *store.Resource, name string) error {
+	content, err := s.GetResourceContent(c.Request().Context(), resource)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	entry, err := zipWriter.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Unix(resource.LastModifiedTs(), 0),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, content)
+	return err
+}
+
+// uniqueZipEntryName returns a sanitized file name for the resource which is not yet used in the archive.
+func uniqueZipEntryName(usedNames map[string]bool, resource *store.Resource) string {
+	name := strings.NewReplacer("/", "_", "\\", "_", "\x00", "").Replace(resource.Filename)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		name = fmt.Sprintf("resource-%d", resource.ID)
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; usedNames[candidate]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	usedNames[candidate] = true
+	return candidate
+}
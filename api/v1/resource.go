@@ -1,27 +1,37 @@
 package v1
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"image"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/disintegration/imaging"
 	"github.com/labstack/echo/v4"
 	"github.com/lithammer/shortuuid/v4"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
+	apiresource "github.com/usememos/memos/api/resource"
 	"github.com/usememos/memos/internal/log"
 	"github.com/usememos/memos/internal/util"
-	"github.com/usememos/memos/plugin/storage/s3"
+	"github.com/usememos/memos/server/profile"
 	"github.com/usememos/memos/server/service/metric"
 	"github.com/usememos/memos/store"
 )
@@ -42,12 +52,46 @@ type Resource struct {
 	ExternalLink string `json:"externalLink"`
 	Type         string `json:"type"`
 	Size         int64  `json:"size"`
+	// ExpiresTs is the time after which the resource is deleted, 0 means it never expires.
+	ExpiresTs int64 `json:"expiresTs"`
+	// CacheControl overrides the Cache-Control header of the resource content.
+	CacheControl *string `json:"cacheControl"`
+	// OriginalTs is the modification time of the file before it was uploaded, served as the content's Last-Modified.
+	OriginalTs *int64 `json:"originalTs"`
+	// Sha256 is the hex encoded SHA-256 of the content, empty for external links.
+	Sha256 string `json:"sha256"`
+}
+
+// AdminResource is a resource as listed to admins, with where its content is stored.
+type AdminResource struct {
+	*Resource
+	// Storage is where the content is kept: database, local or external.
+	Storage string `json:"storage"`
+	MemoID  *int32 `json:"memoId"`
+}
+
+// ResourceUsage is the amount and total size in bytes of a set of resources.
+type ResourceUsage struct {
+	Count int64 `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+// ResourceStats is the storage usage of all resources.
+type ResourceStats struct {
+	ResourceUsage
+	// ByStorage is keyed by the storage of the content: database, local or external.
+	ByStorage map[string]ResourceUsage `json:"byStorage"`
+	// ByType is keyed by the MIME type category such as image or video.
+	ByType map[string]ResourceUsage `json:"byType"`
 }
 
 type CreateResourceRequest struct {
 	Filename     string `json:"filename"`
 	ExternalLink string `json:"externalLink"`
 	Type         string `json:"type"`
+	ExpiresTs    int64  `json:"expiresTs"`
+	// OriginalTs preserves the modification time of the file, such as when imported from another system.
+	OriginalTs *int64 `json:"originalTs"`
 }
 
 type FindResourceRequest struct {
@@ -56,26 +100,63 @@ type FindResourceRequest struct {
 	Filename  *string `json:"filename"`
 }
 
+type MoveResourceRequest struct {
+	// StorageID is the target storage: -1 for local, 0 for database or the ID of a storage.
+	StorageID int32 `json:"storageId"`
+}
+
 type UpdateResourceRequest struct {
 	Filename *string `json:"filename"`
+	// Type corrects the MIME type of the resource, it's served as the content type.
+	Type *string `json:"type"`
+	// CacheControl sets the Cache-Control header of the resource content, an empty value restores the default.
+	CacheControl *string `json:"cacheControl"`
 }
 
 const (
-	// The upload memory buffer is 32 MiB.
+	// The upload memory buffer is 32 MiB by default, it can be changed with the profile.
+	// Larger parts of an upload are spilled to temp files, so a lower value saves RAM at the cost of disk I/O.
 	// It should be kept low, so RAM usage doesn't get out of control.
 	// This is unrelated to maximum upload size limit, which is now set through system setting.
-	maxUploadBufferSizeBytes = 32 << 20
-	MebiByte                 = 1024 * 1024
+	defaultUploadBufferSizeBytes = 32 << 20
+	minUploadBufferSizeBytes     = 1 << 20
+	maxUploadBufferSizeBytes     = 1 << 30
+	MebiByte                     = 1024 * 1024
+	// maxMimeTypeLength is the maximum length of a resource type set by users.
+	maxMimeTypeLength = 255
 )
 
-var fileKeyPattern = regexp.MustCompile(`\{[a-z]{1,9}\}`)
+var fileKeyPattern = regexp.MustCompile(`\{[a-zA-Z]{1,9}(:\d+)?\}`)
 
+// pathTemplateTokenPattern matches anything that looks like a token to detect unknown ones.
+var pathTemplateTokenPattern = regexp.MustCompile(`\{[^{}/]*\}`)
+
+// pathTemplateTokens are the tokens supported by replacePathTemplate.
+var pathTemplateTokens = []string{"{filename}", "{timestamp}", "{year}", "{month}", "{day}", "{hour}", "{minute}", "{second}", "{uuid}", "{hash}", "{creatorId}", "{username}"}
+
+const (
+	// defaultRandomTokenLength is used for `{random}` without an explicit length.
+	defaultRandomTokenLength = 8
+	// maxRandomTokenLength limits `{random:N}`.
+	maxRandomTokenLength = 64
+)
+
+// registerResourceRoutes registers the resource routes, their errors are rendered as a ResourceError.
 func (s *APIV1Service) registerResourceRoutes(g *echo.Group) {
-	g.GET("/resource", s.GetResourceList)
-	g.POST("/resource", s.CreateResource)
-	g.POST("/resource/blob", s.UploadResource)
-	g.PATCH("/resource/:resourceId", s.UpdateResource)
-	g.DELETE("/resource/:resourceId", s.DeleteResource)
+	g.GET("/resource", s.GetResourceList, renderResourceError)
+	g.GET("/admin/resource", s.GetAdminResourceList, renderResourceError)
+	g.GET("/admin/resource/stats", s.GetAdminResourceStats, renderResourceError)
+	g.POST("/resource", s.CreateResource, renderResourceError)
+	g.POST("/resource/blob", s.UploadResource, renderResourceError)
+	g.POST("/resource/verify", s.VerifyResources, renderResourceError)
+	g.GET("/resource/:resourceId", s.GetResource, renderResourceError)
+	g.GET("/resource/:resourceId/blob", s.GetResourceBlob, renderResourceError)
+	g.PATCH("/resource/:resourceId", s.UpdateResource, renderResourceError)
+	g.PUT("/resource/:resourceId/blob", s.ReplaceResourceBlob, renderResourceError)
+	g.POST("/resource/:resourceId/move", s.MoveResource, renderResourceError)
+	g.POST("/resource/:resourceId/unlink", s.UnlinkResource, renderResourceError)
+	g.POST("/resource/:resourceId/thumbnail/regenerate", s.RegenerateResourceThumbnail, renderResourceError)
+	g.DELETE("/resource/:resourceId", s.DeleteResource, renderResourceError)
 }
 
 // GetResourceList godoc
@@ -84,10 +165,17 @@ func (s *APIV1Service) registerResourceRoutes(g *echo.Group) {
 //	@Tags		resource
 //	@Produce	json
 //	@Param		limit	query		int					false	"Limit"
-//	@Param		offset	query		int					false	"Offset"
+//	@Param		offset	query		int					false	"Offset, prefer cursor which stays stable while resources are added"
+//	@Param		cursor	query		string				false	"X-Next-Cursor of the previous page, listed by created_ts descending"
+//	@Param		search	query		string				false	"Case-insensitive filename substring"
+//	@Param		orderBy	query		string				false	"Order by field"	Enums(id, created_ts, updated_ts, size, filename)
+//	@Param		order	query		string				false	"Order direction, case-insensitive, of updated_ts without orderBy"	Enums(asc, desc)
 //	@Success	200		{object}	[]store.Resource	"Resource list"
+//	@Header		200		{integer}	X-Total-Count		"Total number of resources"
+//	@Header		200		{string}	X-Next-Cursor		"Cursor of the next page, set for full pages ordered by created_ts descending"
+//	@Failure	400		{object}	nil					"Invalid orderBy: %s | Invalid order: %s | Invalid cursor: %s | Cursor can't be combined with offset or another order"
 //	@Failure	401		{object}	nil					"Missing user in session"
-//	@Failure	500		{object}	nil					"Failed to fetch resource list"
+//	@Failure	500		{object}	nil					"Failed to fetch resource list | Failed to count resources"
 //	@Router		/api/v1/resource [GET]
 func (s *APIV1Service) GetResourceList(c echo.Context) error {
 	ctx := c.Request().Context()
@@ -95,20 +183,26 @@ func (s *APIV1Service) GetResourceList(c echo.Context) error {
 	if !ok {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
 	}
+	now := time.Now().Unix()
 	find := &store.FindResource{
-		CreatorID: &userID,
+		CreatorID:    &userID,
+		NotExpiredAt: &now,
 	}
-	if limit, err := strconv.Atoi(c.QueryParam("limit")); err == nil {
-		find.Limit = &limit
-	}
-	if offset, err := strconv.Atoi(c.QueryParam("offset")); err == nil {
-		find.Offset = &offset
+	if err := applyResourceListQuery(c, find); err != nil {
+		return err
 	}
 
 	list, err := s.Store.ListResources(ctx, find)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch resource list").SetInternal(err)
 	}
+	total, err := s.Store.CountResources(ctx, find)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to count resources").SetInternal(err)
+	}
+	c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	setResourceListNextCursor(c, find, list)
+
 	resourceMessageList := []*Resource{}
 	for _, resource := range list {
 		resourceMessageList = append(resourceMessageList, convertResourceFromStore(resource))
@@ -116,6 +210,223 @@ func (s *APIV1Service) GetResourceList(c echo.Context) error {
 	return c.JSON(http.StatusOK, resourceMessageList)
 }
 
+// GetAdminResourceList godoc
+//
+//	@Summary	Get a list of the resources of all users (host and admin only)
+//	@Tags		resource
+//	@Produce	json
+//	@Param		creatorId	query		int					false	"Only list the resources of this user"
+//	@Param		storage		query		string				false	"Only list the resources kept in this storage"	Enums(database, local, external)
+//	@Param		limit		query		int					false	"Limit"
+//	@Param		offset		query		int					false	"Offset, prefer cursor which stays stable while resources are added"
+//	@Param		cursor		query		string				false	"X-Next-Cursor of the previous page, listed by created_ts descending"
+//	@Param		search		query		string				false	"Case-insensitive filename substring"
+//	@Param		orderBy		query		string				false	"Order by field"	Enums(id, created_ts, updated_ts, size, filename)
+//	@Param		order		query		string				false	"Order direction, case-insensitive, of updated_ts without orderBy"	Enums(asc, desc)
+//	@Success	200			{object}	[]AdminResource		"Resource list"
+//	@Header		200			{integer}	X-Total-Count		"Total number of resources"
+//	@Header		200			{string}	X-Next-Cursor		"Cursor of the next page, set for full pages ordered by created_ts descending"
+//	@Failure	400			{object}	nil					"ID is not a number: %s | Invalid storage: %s | Invalid orderBy: %s | Invalid order: %s | Invalid cursor: %s | Cursor can't be combined with offset or another order"
+//	@Failure	401			{object}	nil					"Missing user in session | Unauthorized"
+//	@Failure	500			{object}	nil					"Failed to find user | Failed to fetch resource list | Failed to count resources"
+//	@Router		/api/v1/admin/resource [GET]
+func (s *APIV1Service) GetAdminResourceList(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get(userIDContextKey).(int32)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
+	}
+
+	user, err := s.Store.GetUser(ctx, &store.FindUser{
+		ID: &userID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find user").SetInternal(err)
+	}
+	if user == nil || (user.Role != store.RoleHost && user.Role != store.RoleAdmin) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	find := &store.FindResource{}
+	if creatorID := c.QueryParam("creatorId"); creatorID != "" {
+		id, err := util.ConvertStringToInt32(creatorID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ID is not a number: %s", creatorID)).SetInternal(err)
+		}
+		find.CreatorID = &id
+	}
+	if storage := c.QueryParam("storage"); storage != "" {
+		if !slices.Contains([]string{store.ResourceStorageDatabase, store.ResourceStorageLocal, store.ResourceStorageExternal}, storage) {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid storage: %s", storage))
+		}
+		find.Storage = &storage
+	}
+	if err := applyResourceListQuery(c, find); err != nil {
+		return err
+	}
+
+	list, err := s.Store.ListResources(ctx, find)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch resource list").SetInternal(err)
+	}
+	total, err := s.Store.CountResources(ctx, find)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to count resources").SetInternal(err)
+	}
+	c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	setResourceListNextCursor(c, find, list)
+
+	resourceMessageList := []*AdminResource{}
+	for _, resource := range list {
+		resourceMessageList = append(resourceMessageList, &AdminResource{
+			Resource: convertResourceFromStore(resource),
+			Storage:  resource.Storage(),
+			MemoID:   resource.MemoID,
+		})
+	}
+	return c.JSON(http.StatusOK, resourceMessageList)
+}
+
+// GetAdminResourceStats godoc
+//
+//	@Summary	Get the storage usage of the resources of all users (host and admin only)
+//	@Tags		resource
+//	@Produce	json
+//	@Success	200	{object}	ResourceStats	"Resource stats"
+//	@Failure	401	{object}	nil				"Missing user in session | Unauthorized"
+//	@Failure	500	{object}	nil				"Failed to find user | Failed to sum resource sizes"
+//	@Router		/api/v1/admin/resource/stats [GET]
+func (s *APIV1Service) GetAdminResourceStats(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get(userIDContextKey).(int32)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
+	}
+
+	user, err := s.Store.GetUser(ctx, &store.FindUser{
+		ID: &userID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find user").SetInternal(err)
+	}
+	if user == nil || (user.Role != store.RoleHost && user.Role != store.RoleAdmin) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	find := &store.FindResource{}
+	total, err := s.Store.SumResourceSize(ctx, find)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to sum resource sizes").SetInternal(err)
+	}
+	byStorage, err := s.Store.GroupResourceSizeByStorage(ctx, find)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to sum resource sizes").SetInternal(err)
+	}
+	byType, err := s.Store.GroupResourceSizeByType(ctx, find)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to sum resource sizes").SetInternal(err)
+	}
+
+	stats := &ResourceStats{
+		ResourceUsage: ResourceUsage{Count: total.Count, Size: total.Size},
+		ByStorage:     map[string]ResourceUsage{},
+		ByType:        map[string]ResourceUsage{},
+	}
+	for _, sum := range byStorage {
+		stats.ByStorage[sum.Key] = ResourceUsage{Count: sum.Count, Size: sum.Size}
+	}
+	for _, sum := range byType {
+		key := strings.ToLower(strings.TrimSpace(sum.Key))
+		if key == "" {
+			key = "unknown"
+		}
+		// Categories only differing by case are merged.
+		usage := stats.ByType[key]
+		stats.ByType[key] = ResourceUsage{Count: usage.Count + sum.Count, Size: usage.Size + sum.Size}
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// applyResourceListQuery sets the pagination, search and order of the resource list query params on find.
+func applyResourceListQuery(c echo.Context, find *store.FindResource) error {
+	if limit, err := strconv.Atoi(c.QueryParam("limit")); err == nil {
+		find.Limit = &limit
+	}
+	if offset, err := strconv.Atoi(c.QueryParam("offset")); err == nil {
+		find.Offset = &offset
+	}
+	if search := c.QueryParam("search"); search != "" {
+		find.FilenameSearch = &search
+	}
+	order := strings.ToLower(c.QueryParam("order"))
+	if order != "" && order != "asc" && order != "desc" {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid order: %s", c.QueryParam("order")))
+	}
+	orderBy := c.QueryParam("orderBy")
+	if orderBy == "" && order != "" {
+		// The direction applies to the default order, by update time.
+		orderBy = string(store.ResourceOrderByUpdatedTs)
+	}
+	if orderBy != "" {
+		find.OrderBy = store.ResourceOrderBy(orderBy)
+		if !find.OrderBy.IsValid() {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid orderBy: %s", orderBy))
+		}
+		find.OrderDesc = order != "asc"
+	}
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		if find.Offset != nil || (find.OrderBy != "" && !isResourceCursorOrder(find)) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Cursor can't be combined with offset or another order")
+		}
+		createdTs, id, err := decodeResourceCursor(cursor)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid cursor: %s", cursor)).SetInternal(err)
+		}
+		find.CreatedTsBefore, find.IDBefore = &createdTs, &id
+		find.OrderBy, find.OrderDesc = store.ResourceOrderByCreatedTs, true
+	}
+	return nil
+}
+
+// isResourceCursorOrder reports whether the resources are listed in the order cursors page through.
+func isResourceCursorOrder(find *store.FindResource) bool {
+	return find.OrderBy == store.ResourceOrderByCreatedTs && find.OrderDesc
+}
+
+// setResourceListNextCursor sets the X-Next-Cursor header when a full page of a cursor-ordered list was returned.
+func setResourceListNextCursor(c echo.Context, find *store.FindResource, list []*store.Resource) {
+	if find.Limit == nil || find.Offset != nil || !isResourceCursorOrder(find) || len(list) == 0 || len(list) < *find.Limit {
+		return
+	}
+	last := list[len(list)-1]
+	c.Response().Header().Set("X-Next-Cursor", encodeResourceCursor(last.CreatedTs, last.ID))
+}
+
+// encodeResourceCursor returns the opaque cursor of the resources listed after the given one.
+func encodeResourceCursor(createdTs int64, id int32) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", createdTs, id)))
+}
+
+func decodeResourceCursor(cursor string) (int64, int32, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+	createdTs, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, 0, errors.New("missing cursor separator")
+	}
+	ts, err := strconv.ParseInt(createdTs, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	resourceID, err := util.ConvertStringToInt32(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ts, resourceID, nil
+}
+
 // CreateResource godoc
 //
 //	@Summary	Create resource
@@ -124,9 +435,11 @@ func (s *APIV1Service) GetResourceList(c echo.Context) error {
 //	@Produce	json
 //	@Param		body	body		CreateResourceRequest	true	"Request object."
 //	@Success	200		{object}	store.Resource			"Created resource"
-//	@Failure	400		{object}	nil						"Malformatted post resource request | Invalid external link | Invalid external link scheme | Failed to request %s | Failed to read %s | Failed to read mime from %s"
+//	@Failure	400		{object}	nil						"Malformatted post resource request | Expiry must be in the future | Original time must be positive | Invalid external link | Invalid external link scheme | Failed to request %s | Failed to read %s | Failed to read mime from %s"
 //	@Failure	401		{object}	nil						"Missing user in session"
-//	@Failure	500		{object}	nil						"Failed to save resource | Failed to create resource | Failed to create activity"
+//	@Failure	415		{object}	nil						"File type %s is not allowed"
+//	@Failure	429		{object}	nil						"Too many uploads, please retry later"
+//	@Failure	500		{object}	nil						"Failed to get upload type settings | Failed to save resource | Failed to create resource | Failed to create activity"
 //	@Router		/api/v1/resource [POST]
 func (s *APIV1Service) CreateResource(c echo.Context) error {
 	ctx := c.Request().Context()
@@ -134,18 +447,36 @@ func (s *APIV1Service) CreateResource(c echo.Context) error {
 	if !ok {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
 	}
+	if err := s.checkUploadRateLimit(c); err != nil {
+		return err
+	}
 
 	request := &CreateResourceRequest{}
 	if err := json.NewDecoder(c.Request().Body).Decode(request); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Malformatted post resource request").SetInternal(err)
 	}
 
+	if request.ExpiresTs != 0 && request.ExpiresTs <= time.Now().Unix() {
+		return echo.NewHTTPError(http.StatusBadRequest, "Expiry must be in the future")
+	}
+	if request.OriginalTs != nil && *request.OriginalTs <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Original time must be positive")
+	}
+
 	create := &store.Resource{
 		ResourceName: shortuuid.New(),
 		CreatorID:    userID,
 		Filename:     request.Filename,
 		ExternalLink: request.ExternalLink,
 		Type:         request.Type,
+		ExpiresTs:    request.ExpiresTs,
+		OriginalTs:   request.OriginalTs,
+	}
+	if create.Filename == "" {
+		create.Filename = util.DefaultLinkFilename(request.ExternalLink, request.Type)
+	}
+	if err := s.checkUploadType(ctx, create.Filename, request.Type); err != nil {
+		return err
 	}
 	if request.ExternalLink != "" {
 		// Only allow those external links scheme with http/https
@@ -172,50 +503,102 @@ func (s *APIV1Service) CreateResource(c echo.Context) error {
 //	@Tags		resource
 //	@Accept		multipart/form-data
 //	@Produce	json
-//	@Param		file	formData	file			true	"File to upload"
-//	@Success	200		{object}	store.Resource	"Created resource"
-//	@Failure	400		{object}	nil				"Upload file not found | File size exceeds allowed limit of %d MiB | Failed to parse upload data"
-//	@Failure	401		{object}	nil				"Missing user in session"
-//	@Failure	500		{object}	nil				"Failed to get uploading file | Failed to open file | Failed to save resource | Failed to create resource | Failed to create activity"
+//	@Param		file		formData	file			true	"File to upload"
+//	@Param		memoId		formData	int				false	"ID of memo to attach the resource to"
+//	@Param		replace		formData	bool			false	"Replace the content of the memo resource with the same filename instead of creating a new one"
+//	@Param		expiresTs	formData	int				false	"Unix time after which the resource is deleted"
+//	@Param		originalTs	formData	int				false	"Unix time the file was last modified, defaults to the Last-Modified header of the file part"
+//	@Success	200			{object}	store.Resource	"Created resource"
+//	@Failure	400			{object}	nil				"Upload file not found | File size exceeds allowed limit of %d MiB | Failed to parse upload data | ID is not a number: %s | memoId is required to replace a resource | Expiry is not a number: %s | Expiry must be in the future | Original time is not a number: %s | Original time must be positive | Invalid image"
+//	@Failure	401			{object}	nil				"Missing user in session | Unauthorized"
+//	@Failure	404			{object}	nil				"Memo not found: %d"
+//	@Failure	408			{object}	nil				"Upload timed out"
+//	@Failure	413			{object}	nil				"Image dimensions %dx%d exceed the limit of %d megapixels"
+//	@Failure	415			{object}	nil				"File type %s is not allowed"
+//	@Failure	422			{object}	nil				"File is infected: %s"
+//	@Failure	429			{object}	nil				"Too many uploads, please retry later"
+//	@Failure	500			{object}	nil				"Failed to get uploading file | Failed to open file | Failed to read file | Failed to get upload type settings | Failed to find memo | Failed to find resource | Failed to save resource | Failed to create resource | Failed to patch resource | Failed to create activity"
+//	@Failure	503			{object}	nil				"Failed to scan file"
 //	@Router		/api/v1/resource/blob [POST]
-func (s *APIV1Service) UploadResource(c echo.Context) error {
-	ctx := c.Request().Context()
+func (s *APIV1Service) UploadResource(c echo.Context) (err error) {
+	ctx, cancel := s.withUploadTimeout(c)
+	defer cancel()
+	defer func() {
+		if httpErr := convertUploadTimeoutError(ctx, err); err != nil && httpErr != nil {
+			err = httpErr
+		}
+	}()
 	userID, ok := c.Get(userIDContextKey).(int32)
 	if !ok {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
 	}
-
-	// This is the backend default max upload size limit.
-	maxUploadSetting := s.Store.GetWorkspaceSettingWithDefaultValue(ctx, SystemSettingMaxUploadSizeMiBName.String(), "32")
-	var settingMaxUploadSizeBytes int
-	if settingMaxUploadSizeMiB, err := strconv.Atoi(maxUploadSetting); err == nil {
-		settingMaxUploadSizeBytes = settingMaxUploadSizeMiB * MebiByte
-	} else {
-		log.Warn("Failed to parse max upload size", zap.Error(err))
-		settingMaxUploadSizeBytes = 0
+	if err := s.checkUploadRateLimit(c); err != nil {
+		return err
 	}
 
-	file, err := c.FormFile("file")
+	file, sourceFile, err := s.openUploadFile(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get uploading file").SetInternal(err)
+		return err
 	}
-	if file == nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Upload file not found").SetInternal(err)
+	defer sourceFile.Close()
+
+	var memoID *int32
+	if value := c.FormValue("memoId"); value != "" {
+		id, err := util.ConvertStringToInt32(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ID is not a number: %s", value)).SetInternal(err)
+		}
+		memo, err := s.Store.GetMemo(ctx, &store.FindMemo{
+			ID: &id,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find memo").SetInternal(err)
+		}
+		if memo == nil {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Memo not found: %d", id))
+		}
+		if memo.CreatorID != userID {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+		}
+		memoID = &id
 	}
 
-	if file.Size > int64(settingMaxUploadSizeBytes) {
-		message := fmt.Sprintf("File size exceeds allowed limit of %d MiB", settingMaxUploadSizeBytes/MebiByte)
-		return echo.NewHTTPError(http.StatusBadRequest, message).SetInternal(err)
+	var expiresTs int64
+	if value := c.FormValue("expiresTs"); value != "" {
+		expiresTs, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expiry is not a number: %s", value)).SetInternal(err)
+		}
+		if expiresTs <= time.Now().Unix() {
+			return echo.NewHTTPError(http.StatusBadRequest, "Expiry must be in the future")
+		}
 	}
-	if err := c.Request().ParseMultipartForm(maxUploadBufferSizeBytes); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Failed to parse upload data").SetInternal(err)
+	originalTs, err := getUploadOriginalTs(c, file)
+	if err != nil {
+		return err
 	}
 
-	sourceFile, err := file.Open()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to open file").SetInternal(err)
+	// Replacing makes retried uploads of the same memo file idempotent.
+	if replace, _ := strconv.ParseBool(c.FormValue("replace")); replace {
+		if memoID == nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "memoId is required to replace a resource")
+		}
+		existingResource, err := s.Store.GetResource(ctx, &store.FindResource{
+			CreatorID: &userID,
+			MemoID:    memoID,
+			Filename:  &file.Filename,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find resource").SetInternal(err)
+		}
+		if existingResource != nil {
+			resource, err := s.replaceResourceBlob(ctx, existingResource, file, sourceFile, originalTs)
+			if err != nil {
+				return err
+			}
+			return c.JSON(http.StatusOK, convertResourceFromStore(resource))
+		}
 	}
-	defer sourceFile.Close()
 
 	create := &store.Resource{
 		ResourceName: shortuuid.New(),
@@ -223,33 +606,603 @@ func (s *APIV1Service) UploadResource(c echo.Context) error {
 		Filename:     file.Filename,
 		Type:         file.Header.Get("Content-Type"),
 		Size:         file.Size,
+		MemoID:       memoID,
+		ExpiresTs:    expiresTs,
+		OriginalTs:   originalTs,
+	}
+	err = s.SaveResourceBlob(ctx, create, sourceFile)
+	if err != nil {
+		if httpErr := convertScanError(err); httpErr != nil {
+			return httpErr
+		}
+		if httpErr := convertUploadSizeError(err); httpErr != nil {
+			return httpErr
+		}
+		if httpErr := convertUploadTypeError(err); httpErr != nil {
+			return httpErr
+		}
+		if httpErr := convertImageError(err); httpErr != nil {
+			return httpErr
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save resource").SetInternal(err)
+	}
+
+	// Blobs stored in the database are only read, and scanned, when the resource is created.
+	resource, err := s.Store.CreateResource(ctx, create)
+	if err != nil {
+		if httpErr := convertScanError(err); httpErr != nil {
+			return httpErr
+		}
+		if httpErr := convertUploadSizeError(err); httpErr != nil {
+			return httpErr
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create resource").SetInternal(err)
+	}
+	metric.Enqueue("resource create")
+	return c.JSON(http.StatusOK, convertResourceFromStore(resource))
+}
+
+// ReplaceResourceBlob godoc
+//
+//	@Summary	Replace the content of a resource
+//	@Tags		resource
+//	@Accept		multipart/form-data
+//	@Produce	json
+//	@Param		resourceId	path		int				true	"Resource ID"
+//	@Param		file		formData	file			true	"File to upload"
+//	@Param		originalTs	formData	int				false	"Unix time the file was last modified, defaults to the Last-Modified header of the file part"
+//	@Success	200			{object}	store.Resource	"Updated resource"
+//	@Failure	400			{object}	nil				"ID is not a number: %s | Upload file not found | File size exceeds allowed limit of %d MiB | Failed to parse upload data | Original time is not a number: %s | Original time must be positive | Invalid image"
+//	@Failure	401			{object}	nil				"Missing user in session | Unauthorized"
+//	@Failure	404			{object}	nil				"Resource not found: %d"
+//	@Failure	408			{object}	nil				"Upload timed out"
+//	@Failure	413			{object}	nil				"Image dimensions %dx%d exceed the limit of %d megapixels"
+//	@Failure	415			{object}	nil				"File type %s is not allowed"
+//	@Failure	422			{object}	nil				"File is infected: %s"
+//	@Failure	500			{object}	nil				"Failed to find resource | Failed to get uploading file | Failed to open file | Failed to read file | Failed to save resource | Failed to patch resource"
+//	@Failure	503			{object}	nil				"Failed to scan file"
+//	@Router		/api/v1/resource/{resourceId}/blob [PUT]
+func (s *APIV1Service) ReplaceResourceBlob(c echo.Context) (err error) {
+	ctx, cancel := s.withUploadTimeout(c)
+	defer cancel()
+	defer func() {
+		if httpErr := convertUploadTimeoutError(ctx, err); err != nil && httpErr != nil {
+			err = httpErr
+		}
+	}()
+	userID, ok := c.Get(userIDContextKey).(int32)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
+	}
+
+	resourceID, err := util.ConvertStringToInt32(c.Param("resourceId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ID is not a number: %s", c.Param("resourceId"))).SetInternal(err)
+	}
+
+	resource, err := s.Store.GetResource(ctx, &store.FindResource{
+		ID: &resourceID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find resource").SetInternal(err)
+	}
+	if resource == nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Resource not found: %d", resourceID))
+	}
+	if resource.CreatorID != userID {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	file, sourceFile, err := s.openUploadFile(c)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	originalTs, err := getUploadOriginalTs(c, file)
+	if err != nil {
+		return err
+	}
+
+	updatedResource, err := s.replaceResourceBlob(ctx, resource, file, sourceFile, originalTs)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, convertResourceFromStore(updatedResource))
+}
+
+// replaceResourceBlob saves the uploaded file as the new content of the resource, keeping its ID and name.
+// The original time of the previous content is dropped unless originalTs is set.
+// The returned error is an echo.HTTPError ready to be returned by the handler.
+func (s *APIV1Service) replaceResourceBlob(ctx context.Context, resource *store.Resource, file *multipart.FileHeader, sourceFile multipart.File, originalTs *int64) (*store.Resource, error) {
+	replacement := &store.Resource{
+		ResourceName: resource.ResourceName,
+		CreatorID:    resource.CreatorID,
+		Filename:     file.Filename,
+		Type:         file.Header.Get("Content-Type"),
+		Size:         file.Size,
+	}
+	if err := s.SaveResourceBlob(ctx, replacement, sourceFile); err != nil {
+		if httpErr := convertScanError(err); httpErr != nil {
+			return nil, httpErr
+		}
+		if httpErr := convertUploadSizeError(err); httpErr != nil {
+			return nil, httpErr
+		}
+		if httpErr := convertUploadTypeError(err); httpErr != nil {
+			return nil, httpErr
+		}
+		if httpErr := convertImageError(err); httpErr != nil {
+			return nil, httpErr
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to save resource").SetInternal(err)
+	}
+
+	currentTs := time.Now().Unix()
+	blob := replacement.Blob
+	if blob == nil {
+		// Clear the previous content stored in the database.
+		blob = []byte{}
+	}
+	if originalTs == nil {
+		// Resets the original time, which doesn't apply to the new content.
+		originalTs = new(int64)
+	}
+	updatedResource, err := s.Store.UpdateResource(ctx, &store.UpdateResource{
+		ID:           resource.ID,
+		UpdatedTs:    &currentTs,
+		Filename:     &replacement.Filename,
+		Type:         &replacement.Type,
+		Size:         &replacement.Size,
+		InternalPath: &replacement.InternalPath,
+		ExternalLink: &replacement.ExternalLink,
+		Blob:         blob,
+		BlobReader:   replacement.BlobReader,
+		OriginalTs:   originalTs,
+		Sha256:       &replacement.Sha256,
+		Compression:  &replacement.Compression,
+	})
+	if err != nil {
+		// The resource keeps its previous content, the new local file is not referenced.
+		if replacement.InternalPath != "" && replacement.InternalPath != resource.InternalPath {
+			s.removeLocalResourceFile(replacement.InternalPath)
+		}
+		if httpErr := convertScanError(err); httpErr != nil {
+			return nil, httpErr
+		}
+		if httpErr := convertUploadSizeError(err); httpErr != nil {
+			return nil, httpErr
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to patch resource").SetInternal(err)
+	}
+
+	// Delete the previous local file, objects in external storages are kept like on resource deletion.
+	if resource.InternalPath != "" && resource.InternalPath != replacement.InternalPath {
+		s.removeLocalResourceFile(resource.InternalPath)
+	}
+	return updatedResource, nil
+}
+
+// MoveResource godoc
+//
+//	@Summary	Move the content of a resource to another storage
+//	@Tags		resource
+//	@Accept		json
+//	@Produce	json
+//	@Param		resourceId	path		int					true	"Resource ID"
+//	@Param		body		body		MoveResourceRequest	true	"Target storage"
+//	@Success	200			{object}	store.Resource		"Moved resource"
+//	@Failure	400			{object}	nil					"ID is not a number: %s | Malformatted move resource request | Resource content is stored externally | Resource is already in the storage"
+//	@Failure	401			{object}	nil					"Missing user in session | Unauthorized"
+//	@Failure	404			{object}	nil					"Resource not found: %d | Storage not found: %d"
+//	@Failure	500			{object}	nil					"Failed to find user | Failed to find resource | Failed to find storage | Failed to read resource | Failed to save resource | Failed to patch resource"
+//	@Router		/api/v1/resource/{resourceId}/move [POST]
+func (s *APIV1Service) MoveResource(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get(userIDContextKey).(int32)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
+	}
+
+	resourceID, err := util.ConvertStringToInt32(c.Param("resourceId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ID is not a number: %s", c.Param("resourceId"))).SetInternal(err)
+	}
+
+	resource, err := s.Store.GetResource(ctx, &store.FindResource{
+		ID: &resourceID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find resource").SetInternal(err)
+	}
+	if resource == nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Resource not found: %d", resourceID))
+	}
+	if resource.CreatorID != userID {
+		user, err := s.Store.GetUser(ctx, &store.FindUser{
+			ID: &userID,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find user").SetInternal(err)
+		}
+		if user == nil || (user.Role != store.RoleHost && user.Role != store.RoleAdmin) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+		}
+	}
+
+	request := &MoveResourceRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Malformatted move resource request").SetInternal(err)
+	}
+	// The server never reads the content of external resources, so it can't copy them.
+	if resource.ExternalLink != "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Resource content is stored externally")
+	}
+	if (request.StorageID == DatabaseStorage && resource.InternalPath == "") || (request.StorageID == LocalStorage && resource.InternalPath != "") {
+		return echo.NewHTTPError(http.StatusBadRequest, "Resource is already in the storage")
+	}
+	if request.StorageID > 0 {
+		storage, err := s.Store.GetStorage(ctx, &store.FindStorage{ID: &request.StorageID})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find storage").SetInternal(err)
+		}
+		if storage == nil {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Storage not found: %d", request.StorageID))
+		}
+	}
+
+	movedResource, err := s.moveResource(ctx, resource, request.StorageID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, convertResourceFromStore(movedResource))
+}
+
+// moveResource copies the content of the resource to the storage, points the resource to it and deletes the previous content.
+// The resource keeps pointing to the previous content until the copy is complete.
+// The returned error is an echo.HTTPError ready to be returned by the handler.
+func (s *APIV1Service) moveResource(ctx context.Context, resource *store.Resource, storageID int32) (*store.Resource, error) {
+	reader, err := s.Store.GetResourceContent(ctx, resource)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to read resource").SetInternal(err)
+	}
+	defer reader.Close()
+
+	moved := &store.Resource{
+		ResourceName: resource.ResourceName,
+		CreatorID:    resource.CreatorID,
+		Filename:     resource.Filename,
+		Type:         resource.Type,
+		Size:         resource.Size,
+		CreatedTs:    resource.CreatedTs,
+	}
+	if err := saveResourceBlobToStorage(ctx, s.Store, s.localUploadLimiter, moved, reader, storageID); err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to save resource").SetInternal(err)
+	}
+
+	updatedResource, err := s.Store.UpdateResource(ctx, &store.UpdateResource{
+		ID:           resource.ID,
+		InternalPath: &moved.InternalPath,
+		ExternalLink: &moved.ExternalLink,
+		// Clear the previous content stored in the database.
+		Blob:       []byte{},
+		BlobReader: moved.BlobReader,
+		// The content is unchanged, so is its hash.
+		Sha256:      &resource.Sha256,
+		Compression: &moved.Compression,
+	})
+	if err != nil {
+		if moved.BlobReader != nil {
+			// The store keeps the resource on its previous content when the new blob can't be written.
+		} else if current, findErr := s.Store.GetResource(ctx, &store.FindResource{ID: &resource.ID}); findErr == nil && current != nil && current.InternalPath == moved.InternalPath && current.ExternalLink == moved.ExternalLink {
+			// Only deleting the previous database content failed, the resource points to the complete copy.
+			log.Warn("failed to delete moved resource content", zap.Int32("resource", resource.ID), zap.Error(err))
+			return current, nil
+		} else if moved.InternalPath != "" {
+			s.removeLocalResourceFile(moved.InternalPath)
+		} else {
+			log.Warn("moved resource content is left in the storage", zap.Int32("resource", resource.ID), zap.String("link", moved.ExternalLink))
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to patch resource").SetInternal(err)
+	}
+
+	// Database content was replaced by the update, only a previous local file is left.
+	if resource.InternalPath != "" {
+		s.removeLocalResourceFile(resource.InternalPath)
+	}
+	return updatedResource, nil
+}
+
+// removeLocalResourceFile deletes the local file at the internal path of a resource, logging failures.
+func (s *APIV1Service) removeLocalResourceFile(internalPath string) {
+	resourcePath := filepath.FromSlash(internalPath)
+	if !filepath.IsAbs(resourcePath) {
+		resourcePath = filepath.Join(s.Profile.Data, resourcePath)
+	}
+	if err := os.Remove(resourcePath); err != nil && !os.IsNotExist(err) {
+		log.Warn("failed to delete local resource", zap.String("path", resourcePath), zap.Error(err))
+	}
+	_ = os.Remove(resourcePath + store.ResourceSidecarSuffix)
+}
+
+// RegenerateResourceThumbnail godoc
+//
+//	@Summary	Delete the cached thumbnails of a resource and generate its thumbnail again
+//	@Tags		resource
+//	@Produce	json
+//	@Param		resourceId	path		int		true	"Resource ID"
+//	@Success	200			{boolean}	true	"Thumbnail regenerated"
+//	@Failure	400			{object}	nil		"ID is not a number: %s"
+//	@Failure	401			{object}	nil		"Missing user in session | Unauthorized"
+//	@Failure	404			{object}	nil		"Resource not found: %d"
+//	@Failure	415			{object}	nil		"Thumbnails are not supported for the resource type: %s"
+//	@Failure	500			{object}	nil		"Failed to find user | Failed to find resource | Failed to regenerate thumbnail"
+//	@Router		/api/v1/resource/{resourceId}/thumbnail/regenerate [POST]
+func (s *APIV1Service) RegenerateResourceThumbnail(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get(userIDContextKey).(int32)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
+	}
+
+	resourceID, err := util.ConvertStringToInt32(c.Param("resourceId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ID is not a number: %s", c.Param("resourceId"))).SetInternal(err)
+	}
+
+	resource, err := s.Store.GetResource(ctx, &store.FindResource{
+		ID: &resourceID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find resource").SetInternal(err)
+	}
+	if resource == nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Resource not found: %d", resourceID))
+	}
+	if resource.CreatorID != userID {
+		user, err := s.Store.GetUser(ctx, &store.FindUser{
+			ID: &userID,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find user").SetInternal(err)
+		}
+		if user == nil || (user.Role != store.RoleHost && user.Role != store.RoleAdmin) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+		}
+	}
+
+	if err := s.resourceService.RegenerateThumbnail(ctx, resource); err != nil {
+		if errors.Is(err, apiresource.ErrThumbnailUnsupported) {
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("Thumbnails are not supported for the resource type: %s", resource.Type))
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to regenerate thumbnail").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, true)
+}
+
+// UnlinkResource godoc
+//
+//	@Summary	Unlink a resource from its memo, keeping its content
+//	@Tags		resource
+//	@Produce	json
+//	@Param		resourceId	path		int				true	"Resource ID"
+//	@Success	200			{object}	store.Resource	"Unlinked resource"
+//	@Failure	400			{object}	nil				"ID is not a number: %s"
+//	@Failure	401			{object}	nil				"Missing user in session | Unauthorized"
+//	@Failure	404			{object}	nil				"Resource not found: %d"
+//	@Failure	500			{object}	nil				"Failed to find resource | Failed to patch resource"
+//	@Router		/api/v1/resource/{resourceId}/unlink [POST]
+func (s *APIV1Service) UnlinkResource(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get(userIDContextKey).(int32)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
+	}
+
+	resourceID, err := util.ConvertStringToInt32(c.Param("resourceId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ID is not a number: %s", c.Param("resourceId"))).SetInternal(err)
+	}
+
+	resource, err := s.Store.GetResource(ctx, &store.FindResource{
+		ID: &resourceID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find resource").SetInternal(err)
+	}
+	if resource == nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Resource not found: %d", resourceID))
+	}
+	if resource.CreatorID != userID {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+	if resource.MemoID == nil {
+		return c.JSON(http.StatusOK, convertResourceFromStore(resource))
+	}
+
+	currentTs := time.Now().Unix()
+	unlinked := int32(0)
+	resource, err = s.Store.UpdateResource(ctx, &store.UpdateResource{
+		ID:        resourceID,
+		UpdatedTs: &currentTs,
+		MemoID:    &unlinked,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to patch resource").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, convertResourceFromStore(resource))
+}
+
+// DeleteResource godoc
+//
+//	@Summary	Delete a resource
+//	@Tags		resource
+//	@Produce	json
+//	@Param		resourceId	path		int		true	"Resource ID"
+//	@Success	200			{boolean}	true	"Resource deleted"
+//	@Failure	400			{object}	nil		"ID is not a number: %s"
+//	@Failure	401			{object}	nil		"Missing user in session"
+//	@Failure	404			{object}	nil		"Resource not found: %d"
+//	@Failure	500			{object}	nil		"Failed to find resource | Failed to delete resource"
+//	@Router		/api/v1/resource/{resourceId} [DELETE]
+func (s *APIV1Service) DeleteResource(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get(userIDContextKey).(int32)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
+	}
+
+	resourceID, err := util.ConvertStringToInt32(c.Param("resourceId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ID is not a number: %s", c.Param("resourceId"))).SetInternal(err)
+	}
+
+	resource, err := s.Store.GetResource(ctx, &store.FindResource{
+		ID:        &resourceID,
+		CreatorID: &userID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find resource").SetInternal(err)
+	}
+	if resource == nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Resource not found: %d", resourceID))
+	}
+
+	if err := s.Store.DeleteResource(ctx, &store.DeleteResource{
+		ID: resourceID,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete resource").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, true)
+}
+
+// VerifyResources godoc
+//
+//	@Summary	Verify that the content of resources can be read back (host only)
+//	@Tags		resource
+//	@Produce	json
+//	@Param		afterId		query		int								false	"Resume after the resource with this ID"
+//	@Param		limit		query		int								false	"Maximum amount of resources to verify"
+//	@Param		concurrency	query		int								false	"Amount of resources read at the same time"
+//	@Success	200			{object}	store.ResourceIntegrityReport	"Verification report"
+//	@Failure	400			{object}	nil								"ID is not a number: %s | Limit is not a number: %s | Concurrency is not a number: %s"
+//	@Failure	401			{object}	nil								"Missing user in session | Unauthorized"
+//	@Failure	500			{object}	nil								"Failed to find user | Failed to verify resources"
+//	@Router		/api/v1/resource/verify [POST]
+func (s *APIV1Service) VerifyResources(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get(userIDContextKey).(int32)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
+	}
+
+	user, err := s.Store.GetUser(ctx, &store.FindUser{
+		ID: &userID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find user").SetInternal(err)
+	}
+	if user == nil || user.Role != store.RoleHost {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	verify := &store.VerifyResources{
+		Progress: func(report *store.ResourceIntegrityReport) {
+			log.Info("verifying resources", zap.Int("checked", report.Checked), zap.Int("failures", len(report.Failures)), zap.Int32("lastId", report.LastID))
+		},
+	}
+	if afterID := c.QueryParam("afterId"); afterID != "" {
+		id, err := util.ConvertStringToInt32(afterID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ID is not a number: %s", afterID)).SetInternal(err)
+		}
+		verify.AfterID = id
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		verify.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Limit is not a number: %s", limit)).SetInternal(err)
+		}
+	}
+	if concurrency := c.QueryParam("concurrency"); concurrency != "" {
+		verify.Concurrency, err = strconv.Atoi(concurrency)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Concurrency is not a number: %s", concurrency)).SetInternal(err)
+		}
+	}
+
+	report, err := s.Store.VerifyResources(ctx, verify)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to verify resources").SetInternal(err)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// GetResource godoc
+//
+//	@Summary	Get a resource by ID
+//	@Tags		resource
+//	@Produce	json
+//	@Param		resourceId	path		int				true	"Resource ID"
+//	@Success	200			{object}	store.Resource	"Resource"
+//	@Failure	400			{object}	nil				"ID is not a number: %s"
+//	@Failure	401			{object}	nil				"Missing user in session | Unauthorized"
+//	@Failure	404			{object}	nil				"Resource not found: %d"
+//	@Failure	500			{object}	nil				"Failed to find resource | Failed to find memo by ID: %v"
+//	@Router		/api/v1/resource/{resourceId} [GET]
+func (s *APIV1Service) GetResource(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get(userIDContextKey).(int32)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
 	}
-	err = SaveResourceBlob(ctx, s.Store, create, sourceFile)
+
+	resourceID, err := util.ConvertStringToInt32(c.Param("resourceId"))
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save resource").SetInternal(err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ID is not a number: %s", c.Param("resourceId"))).SetInternal(err)
 	}
 
-	resource, err := s.Store.CreateResource(ctx, create)
+	resource, err := s.Store.GetResource(ctx, &store.FindResource{
+		ID: &resourceID,
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create resource").SetInternal(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find resource").SetInternal(err)
+	}
+	if resource == nil || resource.IsExpired(time.Now().Unix()) {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Resource not found: %d", resourceID))
+	}
+	if resource.CreatorID != userID {
+		// Resources of other users are only visible through a public or protected memo.
+		if resource.MemoID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+		}
+		memo, err := s.Store.GetMemo(ctx, &store.FindMemo{
+			ID: resource.MemoID,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to find memo by ID: %v", *resource.MemoID)).SetInternal(err)
+		}
+		if memo == nil || memo.Visibility == store.Private {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+		}
 	}
-	metric.Enqueue("resource create")
 	return c.JSON(http.StatusOK, convertResourceFromStore(resource))
 }
 
-// DeleteResource godoc
+// GetResourceBlob godoc
 //
-//	@Summary	Delete a resource
+//	@Summary	Get the content of a resource
 //	@Tags		resource
-//	@Produce	json
+//	@Produce	application/octet-stream
 //	@Param		resourceId	path		int		true	"Resource ID"
-//	@Success	200			{boolean}	true	"Resource deleted"
-//	@Failure	400			{object}	nil		"ID is not a number: %s"
-//	@Failure	401			{object}	nil		"Missing user in session"
+//	@Param		download	query		bool	false	"Serve the content as an attachment"
+//	@Success	200			{file}		file	"Resource content"
+//	@Success	304			{object}	nil		"Not modified since If-Modified-Since"
+//	@Failure	400			{object}	nil		"ID is not a number: %s | Resource content is stored externally"
+//	@Failure	401			{object}	nil		"Missing user in session | Unauthorized"
 //	@Failure	404			{object}	nil		"Resource not found: %d"
-//	@Failure	500			{object}	nil		"Failed to find resource | Failed to delete resource"
-//	@Router		/api/v1/resource/{resourceId} [DELETE]
-func (s *APIV1Service) DeleteResource(c echo.Context) error {
+//	@Failure	500			{object}	nil		"Failed to find resource | Failed to open the resource content | Failed to read the resource content"
+//	@Router		/api/v1/resource/{resourceId}/blob [GET]
+func (s *APIV1Service) GetResourceBlob(c echo.Context) error {
 	ctx := c.Request().Context()
 	userID, ok := c.Get(userIDContextKey).(int32)
 	if !ok {
@@ -262,22 +1215,24 @@ func (s *APIV1Service) DeleteResource(c echo.Context) error {
 	}
 
 	resource, err := s.Store.GetResource(ctx, &store.FindResource{
-		ID:        &resourceID,
-		CreatorID: &userID,
+		ID:      &resourceID,
+		GetBlob: true,
 	})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find resource").SetInternal(err)
 	}
-	if resource == nil {
+	if resource == nil || resource.IsExpired(time.Now().Unix()) {
 		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Resource not found: %d", resourceID))
 	}
-
-	if err := s.Store.DeleteResource(ctx, &store.DeleteResource{
-		ID: resourceID,
-	}); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete resource").SetInternal(err)
+	if resource.CreatorID != userID {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
 	}
-	return c.JSON(http.StatusOK, true)
+	if resource.InternalPath == "" && resource.ExternalLink != "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Resource content is stored externally")
+	}
+
+	// Only the owner gets the content, so it's never kept in shared caches.
+	return s.resourceService.StreamResourceContent(c, resource, true)
 }
 
 // UpdateResource godoc
@@ -288,10 +1243,11 @@ func (s *APIV1Service) DeleteResource(c echo.Context) error {
 //	@Param		resourceId	path		int						true	"Resource ID"
 //	@Param		patch		body		UpdateResourceRequest	true	"Patch resource request"
 //	@Success	200			{object}	store.Resource			"Updated resource"
-//	@Failure	400			{object}	nil						"ID is not a number: %s | Malformatted patch resource request"
+//	@Failure	400			{object}	nil						"ID is not a number: %s | Malformatted patch resource request | Invalid type: %s | Invalid cache control"
 //	@Failure	401			{object}	nil						"Missing user in session | Unauthorized"
 //	@Failure	404			{object}	nil						"Resource not found: %d"
-//	@Failure	500			{object}	nil						"Failed to find resource | Failed to patch resource"
+//	@Failure	415			{object}	nil						"File type %s is not allowed"
+//	@Failure	500			{object}	nil						"Failed to find resource | Failed to get upload type settings | Failed to patch resource"
 //	@Router		/api/v1/resource/{resourceId} [PATCH]
 func (s *APIV1Service) UpdateResource(c echo.Context) error {
 	ctx := c.Request().Context()
@@ -331,6 +1287,26 @@ func (s *APIV1Service) UpdateResource(c echo.Context) error {
 	if request.Filename != nil && *request.Filename != "" {
 		update.Filename = request.Filename
 	}
+	if request.Type != nil {
+		if !isValidMimeType(*request.Type) {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid type: %s", *request.Type))
+		}
+		// The corrected type mustn't bypass the upload type settings.
+		filename := resource.Filename
+		if update.Filename != nil {
+			filename = *update.Filename
+		}
+		if err := s.checkUploadType(ctx, filename, *request.Type); err != nil {
+			return err
+		}
+		update.Type = request.Type
+	}
+	if request.CacheControl != nil {
+		if *request.CacheControl != "" && !util.ValidateHeaderValue(*request.CacheControl, store.MaxCacheControlLength) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid cache control")
+		}
+		update.CacheControl = request.CacheControl
+	}
 
 	resource, err = s.Store.UpdateResource(ctx, update)
 	if err != nil {
@@ -339,12 +1315,303 @@ func (s *APIV1Service) UpdateResource(c echo.Context) error {
 	return c.JSON(http.StatusOK, convertResourceFromStore(resource))
 }
 
-func replacePathTemplate(path, filename string) string {
+// getUploadBufferSizeBytes returns the amount of upload data kept in memory, bounded to sane values.
+func getUploadBufferSizeBytes(profile *profile.Profile) int64 {
+	size := profile.UploadBufferSize
+	if size <= 0 {
+		return defaultUploadBufferSizeBytes
+	}
+	return min(max(size, minUploadBufferSizeBytes), maxUploadBufferSizeBytes)
+}
+
+// openUploadFile opens the "file" form field after checking its size against the workspace settings.
+// The returned error is an echo.HTTPError ready to be returned by the handler.
+func (s *APIV1Service) openUploadFile(c echo.Context) (*multipart.FileHeader, multipart.File, error) {
+	ctx := c.Request().Context()
+	settingMaxUploadSizeBytes := getMaxUploadSizeBytes(ctx, s.Store)
+
+	// Parsed first, FormFile would parse the form with the default memory limit of net/http otherwise.
+	if err := c.Request().ParseMultipartForm(getUploadBufferSizeBytes(s.Profile)); err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to parse upload data").SetInternal(err)
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get uploading file").SetInternal(err)
+	}
+	if file == nil {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Upload file not found").SetInternal(err)
+	}
+
+	if file.Size > settingMaxUploadSizeBytes {
+		message := fmt.Sprintf("File size exceeds allowed limit of %d MiB", settingMaxUploadSizeBytes/MebiByte)
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, message).SetInternal(&uploadSizeExceededError{limit: settingMaxUploadSizeBytes})
+	}
+
+	sourceFile, err := file.Open()
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to open file").SetInternal(err)
+	}
+	return file, sourceFile, nil
+}
+
+// getUploadOriginalTs returns the original modification time of the uploaded file, from the "originalTs" form field
+// or else the Last-Modified header of the file part. It returns nil when neither is set.
+// The returned error is an echo.HTTPError ready to be returned by the handler.
+func getUploadOriginalTs(c echo.Context, file *multipart.FileHeader) (*int64, error) {
+	if value := c.FormValue("originalTs"); value != "" {
+		originalTs, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Original time is not a number: %s", value)).SetInternal(err)
+		}
+		if originalTs <= 0 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Original time must be positive")
+		}
+		return &originalTs, nil
+	}
+	if value := file.Header.Get(echo.HeaderLastModified); value != "" {
+		// Clients can't always control the part headers, an invalid one is ignored.
+		if lastModified, err := http.ParseTime(value); err == nil && lastModified.Unix() > 0 {
+			originalTs := lastModified.Unix()
+			return &originalTs, nil
+		}
+	}
+	return nil, nil
+}
+
+// isValidMimeType reports whether value is a MIME type with a type and a subtype, parameters are allowed.
+func isValidMimeType(value string) bool {
+	if !util.ValidateHeaderValue(value, maxMimeTypeLength) {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return false
+	}
+	mainType, subType, ok := strings.Cut(mediaType, "/")
+	return ok && mainType != "" && subType != ""
+}
+
+// genericMimeTypes are the sniffed types which don't tell anything about the actual content.
+var genericMimeTypes = []string{"application/octet-stream", "text/plain"}
+
+// UploadTypeNotAllowedError is returned when the extension or mime type of an upload is not permitted by the workspace.
+type UploadTypeNotAllowedError struct {
+	Type string
+}
+
+func (e *UploadTypeNotAllowedError) Error() string {
+	return fmt.Sprintf("file type %s is not allowed", e.Type)
+}
+
+// CheckUploadType rejects files whose extension or any of the given mime types is denied, or not allowed
+// when the workspace restricts the upload types, with an UploadTypeNotAllowedError.
+// Generic sniffed types are not matched against the allowlist.
+func CheckUploadType(ctx context.Context, s *store.Store, filename string, mimeTypes ...string) error {
+	var allowlist, denylist []string
+	for name, list := range map[SystemSettingName]*[]string{
+		SystemSettingAllowedUploadTypesName: &allowlist,
+		SystemSettingDeniedUploadTypesName:  &denylist,
+	} {
+		value := s.GetWorkspaceSettingWithDefaultValue(ctx, name.String(), "[]")
+		if err := json.Unmarshal([]byte(value), list); err != nil {
+			return errors.Wrap(err, "failed to unmarshal upload type settings")
+		}
+	}
+
+	if uploadType, ok := findDisallowedUploadType(filename, mimeTypes, allowlist, denylist); !ok {
+		return &UploadTypeNotAllowedError{Type: uploadType}
+	}
+	return nil
+}
+
+// checkUploadType is CheckUploadType returning the echo.HTTPError of the handlers.
+func (s *APIV1Service) checkUploadType(ctx context.Context, filename string, mimeTypes ...string) error {
+	if err := CheckUploadType(ctx, s.Store, filename, mimeTypes...); err != nil {
+		if httpErr := convertUploadTypeError(err); httpErr != nil {
+			return httpErr
+		}
+		if httpErr := convertImageError(err); httpErr != nil {
+			return httpErr
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get upload type settings").SetInternal(err)
+	}
+	return nil
+}
+
+// convertUploadTypeError returns an echo.HTTPError with status 415 when the upload type is not allowed, nil for other errors.
+func convertUploadTypeError(err error) *echo.HTTPError {
+	typeErr := &UploadTypeNotAllowedError{}
+	if errors.As(err, &typeErr) {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("File type %s is not allowed", typeErr.Type)).SetInternal(err)
+	}
+	return nil
+}
+
+// findDisallowedUploadType returns the offending extension or mime type and false if the upload is not permitted.
+func findDisallowedUploadType(filename string, mimeTypes, allowlist, denylist []string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	types := []string{}
+	for _, mimeType := range mimeTypes {
+		if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+			types = append(types, strings.ToLower(mediaType))
+		}
+	}
+
+	if ext != "" && matchUploadTypeRules(denylist, ext) {
+		return ext, false
+	}
+	for _, mimeType := range types {
+		if matchUploadTypeRules(denylist, mimeType) {
+			return mimeType, false
+		}
+	}
+
+	if len(allowlist) == 0 {
+		return "", true
+	}
+	if ext != "" && matchUploadTypeRules(allowlist, ext) {
+		return "", true
+	}
+	allowed := false
+	for i, mimeType := range types {
+		// The first type is the declared one, the rest are sniffed.
+		if i > 0 && slices.Contains(genericMimeTypes, mimeType) {
+			continue
+		}
+		if !matchUploadTypeRules(allowlist, mimeType) {
+			return mimeType, false
+		}
+		allowed = true
+	}
+	if !allowed {
+		if ext != "" {
+			return ext, false
+		}
+		return "unknown", false
+	}
+	return "", true
+}
+
+// matchUploadTypeRules reports whether the extension (".png") or mime type matches any rule.
+// Rules are extensions, exact mime types or wildcards such as "image/*".
+func matchUploadTypeRules(rules []string, uploadType string) bool {
+	for _, rule := range rules {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if strings.HasSuffix(rule, "/*") {
+			if strings.HasPrefix(uploadType, strings.TrimSuffix(rule, "*")) {
+				return true
+			}
+		} else if rule == uploadType {
+			return true
+		}
+	}
+	return false
+}
+
+// getMaxUploadSizeBytes returns the max upload size limit in bytes from the system setting.
+func getMaxUploadSizeBytes(ctx context.Context, s *store.Store) int64 {
+	// This is the backend default max upload size limit.
+	maxUploadSetting := s.GetWorkspaceSettingWithDefaultValue(ctx, SystemSettingMaxUploadSizeMiBName.String(), "32")
+	settingMaxUploadSizeMiB, err := strconv.Atoi(maxUploadSetting)
+	if err != nil {
+		log.Warn("Failed to parse max upload size", zap.Error(err))
+		return 0
+	}
+	return int64(settingMaxUploadSizeMiB) * MebiByte
+}
+
+// PathTemplatePrefix returns the static directory prefix of the storage path template, under which all keys are created.
+// It is empty when the template starts with a token.
+func PathTemplatePrefix(path string) string {
+	if index := strings.Index(path, "{"); index >= 0 {
+		path = path[:index]
+	}
+	if index := strings.LastIndex(path, "/"); index >= 0 {
+		return path[:index+1]
+	}
+	return ""
+}
+
+// validatePathTemplate checks that the storage path template only uses known tokens and can't escape the storage root.
+func validatePathTemplate(path string) error {
+	unknownTokens := []string{}
+	for _, token := range pathTemplateTokenPattern.FindAllString(path, -1) {
+		if _, ok := parseRandomToken(token); ok {
+			continue
+		}
+		if !slices.Contains(pathTemplateTokens, token) && !slices.Contains(unknownTokens, token) {
+			unknownTokens = append(unknownTokens, token)
+		}
+	}
+	if len(unknownTokens) > 0 {
+		return errors.Errorf("unknown path template tokens: %s", strings.Join(unknownTokens, ", "))
+	}
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return errors.New("path template must not contain `..`")
+		}
+	}
+	return nil
+}
+
+// parseRandomToken returns the length of a `{random}` or `{random:N}` token.
+// It reports false if the token isn't a random token or its length is out of range.
+func parseRandomToken(token string) (int, bool) {
+	if token == "{random}" {
+		return defaultRandomTokenLength, true
+	}
+	if !strings.HasPrefix(token, "{random:") || !strings.HasSuffix(token, "}") {
+		return 0, false
+	}
+	length, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(token, "{random:"), "}"))
+	if err != nil || length < 1 || length > maxRandomTokenLength {
+		return 0, false
+	}
+	return length, true
+}
+
+// pathTemplateValues holds the upload specific values of the storage path template tokens.
+type pathTemplateValues struct {
+	Filename string
+	// ContentHash is the hex SHA-256 of the content, empty when the upload was not hashed.
+	ContentHash string
+	CreatorID   int32
+	Username    string
+}
+
+// getPathTemplateValues returns the template values of the resource, the creator is only
+// looked up when the template references {username}.
+func getPathTemplateValues(ctx context.Context, s *store.Store, path string, create *store.Resource) (*pathTemplateValues, error) {
+	values := &pathTemplateValues{
+		Filename:  create.Filename,
+		CreatorID: create.CreatorID,
+	}
+	if strings.Contains(path, "{username}") {
+		user, err := s.GetUser(ctx, &store.FindUser{ID: &create.CreatorID})
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to find creator")
+		}
+		if user == nil {
+			return nil, errors.Errorf("Creator %d not found", create.CreatorID)
+		}
+		values.Username = user.Username
+	}
+	return values, nil
+}
+
+// replacePathTemplate expands the tokens of the storage path template.
+// {hash} is only known when the upload was hashed and falls back to a random uuid otherwise.
+func replacePathTemplate(path string, values *pathTemplateValues) string {
 	t := time.Now()
 	path = fileKeyPattern.ReplaceAllStringFunc(path, func(s string) string {
 		switch s {
 		case "{filename}":
-			return filename
+			return values.Filename
+		case "{creatorId}":
+			return strconv.Itoa(int(values.CreatorID))
+		case "{username}":
+			// Keep the username a single path segment.
+			return strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(values.Username)
 		case "{timestamp}":
 			return fmt.Sprintf("%d", t.Unix())
 		case "{year}":
@@ -361,12 +1628,48 @@ func replacePathTemplate(path, filename string) string {
 			return fmt.Sprintf("%02d", t.Second())
 		case "{uuid}":
 			return util.GenUUID()
+		case "{hash}":
+			if values.ContentHash == "" {
+				return util.GenUUID()
+			}
+			return values.ContentHash
+		}
+		if length, ok := parseRandomToken(s); ok {
+			random, err := util.RandomString(length)
+			if err != nil {
+				return util.GenUUID()
+			}
+			return random
 		}
 		return s
 	})
 	return path
 }
 
+// spoolAndHash copies the content to a temporary file while computing its SHA-256.
+// The returned file is positioned at the start and must be released with removeSpooledFile.
+func spoolAndHash(r io.Reader) (*os.File, string, error) {
+	spooled, err := os.CreateTemp("", "memos-upload-*")
+	if err != nil {
+		return nil, "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(spooled, hash), r); err != nil {
+		removeSpooledFile(spooled)
+		return nil, "", err
+	}
+	if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+		removeSpooledFile(spooled)
+		return nil, "", err
+	}
+	return spooled, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func removeSpooledFile(spooled *os.File) {
+	spooled.Close()
+	os.Remove(spooled.Name())
+}
+
 func convertResourceFromStore(resource *store.Resource) *Resource {
 	return &Resource{
 		ID:           resource.ID,
@@ -380,16 +1683,64 @@ func convertResourceFromStore(resource *store.Resource) *Resource {
 		ExternalLink: resource.ExternalLink,
 		Type:         resource.Type,
 		Size:         resource.Size,
+		ExpiresTs:    resource.ExpiresTs,
+		CacheControl: resource.CacheControl,
+		OriginalTs:   resource.OriginalTs,
+		Sha256:       resource.Sha256,
 	}
 }
 
 // SaveResourceBlob save the blob of resource based on the storage config
 //
+// The first matching storage routing rule overrides the default storage service.
+//
 // Depend on the storage config, some fields of *store.ResourceCreate will be changed:
-// 1. *DatabaseStorage*: `create.Blob`.
-// 2. *LocalStorage*: `create.InternalPath`.
+// 1. *DatabaseStorage*: `create.BlobReader`, it must be saved before the reader is closed.
+// 2. *LocalStorage*: `create.InternalPath`, and `create.Compression` when the blob is compressed.
 // 3. Others( external service): `create.ExternalLink`.
-func SaveResourceBlob(ctx context.Context, s *store.Store, create *store.Resource, r io.Reader) error {
+//
+// When the workspace antivirus is set, the blob is scanned while it's stored and the storing fails
+// with a resourceInfectedError if it's infected, see convertScanError.
+//
+// The blob is limited to the max upload size in every storage, storing a larger one fails
+// with an uploadSizeExceededError, see convertUploadSizeError.
+//
+// The declared and sniffed types of the blob are checked against the upload type settings first,
+// a type which isn't allowed fails with an UploadTypeNotAllowedError, see convertUploadTypeError.
+//
+// Local files are written through the upload limiter of the service, which its Shutdown waits for.
+func (s *APIV1Service) SaveResourceBlob(ctx context.Context, create *store.Resource, r io.Reader) error {
+	return saveResourceBlob(ctx, s.Store, s.localUploadLimiter, create, r)
+}
+
+// saveResourceBlob saves the blob with the store, writing local files through the limiter, see SaveResourceBlob.
+func saveResourceBlob(ctx context.Context, s *store.Store, limiter *localUploadLimiter, create *store.Resource, r io.Reader) error {
+	maxUploadSizeBytes := getMaxUploadSizeBytes(ctx, s)
+	r = newSizeLimitReader(&contextReader{ctx: ctx, r: r}, maxUploadSizeBytes)
+
+	// Sniff the content so that a disguised file can't bypass the upload type settings.
+	head := make([]byte, 512)
+	headSize, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return errors.Wrap(err, "Failed to read blob")
+	}
+	if err := CheckUploadType(ctx, s, create.Filename, create.Type, http.DetectContentType(head[:headSize])); err != nil {
+		return err
+	}
+	r = io.MultiReader(bytes.NewReader(head[:headSize]), r)
+	if util.HasPrefixes(create.Type, "image/png", "image/jpeg") {
+		stripImageMetadata := s.GetWorkspaceSettingWithDefaultValue(ctx, SystemSettingStripImageMetadataName.String(), "false") == "true"
+		autoOrientImages := s.GetWorkspaceSettingWithDefaultValue(ctx, SystemSettingAutoOrientImagesName.String(), "false") == "true"
+		if stripImageMetadata || autoOrientImages {
+			blob, err := processImageBlob(r, create.Type, maxUploadSizeBytes, stripImageMetadata)
+			if err != nil {
+				return errors.Wrap(err, "Failed to process image")
+			}
+			r = bytes.NewReader(blob)
+			create.Size = int64(len(blob))
+		}
+	}
+
 	systemSettingStorageServiceID, err := s.GetWorkspaceSetting(ctx, &store.FindWorkspaceSetting{Name: SystemSettingStorageServiceIDName.String()})
 	if err != nil {
 		return errors.Wrap(err, "Failed to find SystemSettingStorageServiceIDName")
@@ -402,14 +1753,42 @@ func SaveResourceBlob(ctx context.Context, s *store.Store, create *store.Resourc
 			return errors.Wrap(err, "Failed to unmarshal storage service id")
 		}
 	}
+	storageRoutingRules := s.GetWorkspaceSettingWithDefaultValue(ctx, SystemSettingStorageRoutingRulesName.String(), "")
+	if storageRoutingRules != "" {
+		rules := []StorageRoutingRule{}
+		if err := json.Unmarshal([]byte(storageRoutingRules), &rules); err != nil {
+			return errors.Wrap(err, "Failed to unmarshal storage routing rules")
+		}
+		if rule := findStorageRoutingRule(rules, create.Type, create.Size); rule != nil {
+			storageServiceID = rule.StorageID
+		}
+	}
+
+	antivirus, err := getAntivirus(ctx, s)
+	if err != nil {
+		return err
+	}
+	if antivirus != nil {
+		r = newScanningReader(ctx, antivirus, r)
+	}
+	// Hashed while streamed to the storage, without another pass over the content.
+	hasher := sha256.New()
+	if err := saveResourceBlobToStorage(ctx, s, limiter, create, io.TeeReader(r, hasher), storageServiceID); err != nil {
+		return err
+	}
+	// Blobs kept in the database are only read, and hashed by the store, when the resource is created.
+	if create.BlobReader == nil {
+		create.Sha256 = hex.EncodeToString(hasher.Sum(nil))
+	}
+	return nil
+}
 
+// saveResourceBlobToStorage saves the content of r in the given storage and sets where it is on create.
+func saveResourceBlobToStorage(ctx context.Context, s *store.Store, limiter *localUploadLimiter, create *store.Resource, r io.Reader, storageServiceID int32) error {
 	// `DatabaseStorage` means store blob into database
 	if storageServiceID == DatabaseStorage {
-		fileBytes, err := io.ReadAll(r)
-		if err != nil {
-			return errors.Wrap(err, "Failed to read file")
-		}
-		create.Blob = fileBytes
+		// The blob is streamed into the database in chunks when the resource is saved.
+		create.BlobReader = r
 		return nil
 	} else if storageServiceID == LocalStorage {
 		// `LocalStorage` means save blob into local disk
@@ -425,12 +1804,36 @@ func SaveResourceBlob(ctx context.Context, s *store.Store, create *store.Resourc
 			}
 		}
 
+		// The root is only prepared once, so a missing or read-only mount fails clearly on the first upload.
+		if err := limiter.prepareRoot(localStorageRoot(s.Profile, localStoragePath)); err != nil {
+			return err
+		}
 		internalPath := localStoragePath
 		if !strings.Contains(internalPath, "{filename}") {
 			internalPath = filepath.Join(internalPath, "{filename}")
 		}
-		internalPath = replacePathTemplate(internalPath, create.Filename)
+		values, err := getPathTemplateValues(ctx, s, internalPath, create)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(internalPath, "{hash}") {
+			spooled, hash, err := spoolAndHash(r)
+			if err != nil {
+				return errors.Wrap(err, "Failed to hash file")
+			}
+			defer removeSpooledFile(spooled)
+			r, values.ContentHash = spooled, hash
+		}
+		internalPath = replacePathTemplate(internalPath, values)
 		internalPath = filepath.ToSlash(internalPath)
+		compressed := isLocalStorageCompressed(ctx, s, create.Type)
+		if compressed {
+			create.Compression = store.ResourceCompressionGzip
+			internalPath += store.ResourceCompressedSuffix
+			compressing := newCompressingReader(r)
+			defer compressing.Close()
+			r = compressing
+		}
 		create.InternalPath = internalPath
 
 		osPath := filepath.FromSlash(internalPath)
@@ -441,16 +1844,23 @@ func SaveResourceBlob(ctx context.Context, s *store.Store, create *store.Resourc
 		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
 			return errors.Wrap(err, "Failed to create directory")
 		}
-		dst, err := os.Create(osPath)
-		if err != nil {
-			return errors.Wrap(err, "Failed to create file")
-		}
-		defer dst.Close()
-		_, err = io.Copy(dst, r)
+		start := time.Now()
+		size, err := limiter.writeFile(ctx, osPath, r)
+		metric.ObserveStorageOperation("upload", "local", internalPath, start, size, err)
 		if err != nil {
-			return errors.Wrap(err, "Failed to copy file")
+			return errors.Wrap(err, "Failed to write file")
 		}
 
+		if compressed {
+			// The sidecar describes the content, not the compressed file.
+			size = create.Size
+		}
+		if sidecar := getResourceSidecar(ctx, s, create, size); sidecar != nil {
+			if err := os.WriteFile(osPath+store.ResourceSidecarSuffix, sidecar, 0644); err != nil {
+				_ = os.Remove(osPath)
+				return errors.Wrap(err, "Failed to write metadata sidecar")
+			}
+		}
 		return nil
 	}
 
@@ -472,16 +1882,7 @@ func SaveResourceBlob(ctx context.Context, s *store.Store, create *store.Resourc
 	}
 
 	s3Config := storageMessage.Config.S3Config
-	s3Client, err := s3.NewClient(ctx, &s3.Config{
-		AccessKey: s3Config.AccessKey,
-		SecretKey: s3Config.SecretKey,
-		EndPoint:  s3Config.EndPoint,
-		Region:    s3Config.Region,
-		Bucket:    s3Config.Bucket,
-		URLPrefix: s3Config.URLPrefix,
-		URLSuffix: s3Config.URLSuffix,
-		PreSign:   s3Config.PreSign,
-	})
+	s3Client, err := newS3Client(ctx, s3Config)
 	if err != nil {
 		return errors.Wrap(err, "Failed to create s3 client")
 	}
@@ -490,13 +1891,138 @@ func SaveResourceBlob(ctx context.Context, s *store.Store, create *store.Resourc
 	if !strings.Contains(filePath, "{filename}") {
 		filePath = filepath.Join(filePath, "{filename}")
 	}
-	filePath = replacePathTemplate(filePath, create.Filename)
+	values, err := getPathTemplateValues(ctx, s, filePath, create)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(filePath, "{hash}") {
+		spooled, hash, err := spoolAndHash(r)
+		if err != nil {
+			return errors.Wrap(err, "Failed to hash file")
+		}
+		defer removeSpooledFile(spooled)
+		r, values.ContentHash = spooled, hash
+	}
+	filePath = replacePathTemplate(filePath, values)
 
-	link, err := s3Client.UploadFile(ctx, filePath, create.Type, r)
+	start := time.Now()
+	var tags map[string]string
+	if s3Config.TagResourceMetadata {
+		tags = getResourceMetadataTags(create)
+	}
+	link, err := s3Client.UploadFileWithTags(ctx, filePath, create.Type, r, tags)
+	metric.ObserveStorageOperation("upload", "s3", s3Config.Bucket+"/"+filePath, start, create.Size, err)
 	if err != nil {
 		return errors.Wrap(err, "Failed to upload via s3 client")
 	}
 
+	if sidecar := getResourceSidecar(ctx, s, create, create.Size); sidecar != nil {
+		if _, err := s3Client.UploadFile(ctx, filePath+store.ResourceSidecarSuffix, "application/json", bytes.NewReader(sidecar)); err != nil {
+			if deleteErr := s3Client.DeleteFile(context.WithoutCancel(ctx), filePath); deleteErr != nil {
+				log.Warn("failed to delete object without metadata sidecar", zap.String("key", filePath), zap.Error(deleteErr))
+			}
+			return errors.Wrap(err, "Failed to upload metadata sidecar via s3 client")
+		}
+	}
+
 	create.ExternalLink = link
 	return nil
 }
+
+// getResourceSidecar returns the encoded store.ResourceSidecar of the blob being saved, nil when sidecars are disabled.
+func getResourceSidecar(ctx context.Context, s *store.Store, create *store.Resource, size int64) []byte {
+	if s.GetWorkspaceSettingWithDefaultValue(ctx, SystemSettingResourceMetadataSidecarName.String(), "false") != "true" {
+		return nil
+	}
+	createdTs := create.CreatedTs
+	if createdTs == 0 {
+		createdTs = time.Now().Unix()
+	}
+	sidecar, _ := json.Marshal(&store.ResourceSidecar{
+		Filename:  create.Filename,
+		Type:      create.Type,
+		Size:      size,
+		CreatorID: create.CreatorID,
+		CreatedTs: createdTs,
+	})
+	return sidecar
+}
+
+// maxProcessedImagePixels is the maximum amount of pixels of the images decoded on upload,
+// which takes 4 bytes of memory per pixel.
+const maxProcessedImagePixels = 100 * 1000 * 1000
+
+// imageTooLargeError is returned when an image to process on upload has more than maxProcessedImagePixels.
+type imageTooLargeError struct {
+	width, height int
+}
+
+func (e *imageTooLargeError) Error() string {
+	return fmt.Sprintf("image of %dx%d pixels exceeds the limit of %d megapixels", e.width, e.height, maxProcessedImagePixels/1000/1000)
+}
+
+// invalidImageError is returned when an image to process on upload can't be decoded.
+type invalidImageError struct {
+	err error
+}
+
+func (e *invalidImageError) Error() string {
+	return fmt.Sprintf("invalid image: %v", e.err)
+}
+
+// convertImageError returns an echo.HTTPError with status 413 when the image has too many pixels to be processed,
+// or 400 when it's invalid, nil for other errors.
+func convertImageError(err error) *echo.HTTPError {
+	tooLargeErr := &imageTooLargeError{}
+	if errors.As(err, &tooLargeErr) {
+		message := fmt.Sprintf("Image dimensions %dx%d exceed the limit of %d megapixels", tooLargeErr.width, tooLargeErr.height, maxProcessedImagePixels/1000/1000)
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, message).SetInternal(err)
+	}
+	invalidErr := &invalidImageError{}
+	if errors.As(err, &invalidErr) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid image").SetInternal(err)
+	}
+	return nil
+}
+
+// processImageBlob applies the EXIF orientation of the JPEG/PNG image from r to its pixels.
+// When stripMetadata is set the image is always re-encoded so that EXIF and other metadata are dropped,
+// otherwise images which don't need to be rotated are returned as-is, with their metadata.
+// The image is decoded and encoded once either way. At most maxSize bytes are read from r,
+// larger images fail with an uploadSizeExceededError, and those with more than maxProcessedImagePixels
+// with an imageTooLargeError, see convertImageError.
+func processImageBlob(r io.Reader, mimeType string, maxSize int64, stripMetadata bool) ([]byte, error) {
+	src, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read image")
+	}
+	if int64(len(src)) > maxSize {
+		return nil, &uploadSizeExceededError{limit: maxSize}
+	}
+	// Only JPEG orientations are applied when decoding, and re-encoding drops the orientation tag.
+	if !stripMetadata && readJPEGOrientation(src) <= 1 {
+		return src, nil
+	}
+
+	// The dimensions are checked before the pixels are allocated.
+	config, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return nil, &invalidImageError{err: err}
+	}
+	if int64(config.Width)*int64(config.Height) > maxProcessedImagePixels {
+		return nil, &imageTooLargeError{width: config.Width, height: config.Height}
+	}
+	format := imaging.PNG
+	if strings.HasPrefix(mimeType, "image/jpeg") {
+		format = imaging.JPEG
+	}
+	img, err := imaging.Decode(bytes.NewReader(src), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, &invalidImageError{err: err}
+	}
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, format); err != nil {
+		return nil, errors.Wrap(err, "failed to encode image")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,99 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// ResourceErrorCode is the stable machine-readable code of the errors of the resource routes.
+type ResourceErrorCode string
+
+const (
+	ResourceErrorInvalidArgument ResourceErrorCode = "invalid_argument"
+	ResourceErrorUnauthorized    ResourceErrorCode = "unauthorized"
+	ResourceErrorForbidden       ResourceErrorCode = "forbidden"
+	ResourceErrorNotFound        ResourceErrorCode = "not_found"
+	ResourceErrorConflict        ResourceErrorCode = "conflict"
+	ResourceErrorTooLarge        ResourceErrorCode = "too_large"
+	ResourceErrorUnsupportedType ResourceErrorCode = "unsupported_type"
+	ResourceErrorInfected        ResourceErrorCode = "infected"
+	ResourceErrorRateLimited     ResourceErrorCode = "rate_limited"
+	ResourceErrorTimeout         ResourceErrorCode = "timeout"
+	ResourceErrorScanUnavailable ResourceErrorCode = "scan_unavailable"
+	ResourceErrorUnavailable     ResourceErrorCode = "unavailable"
+	ResourceErrorInternal        ResourceErrorCode = "internal"
+)
+
+// resourceErrorCodesByStatus are the codes of the errors which aren't typed.
+var resourceErrorCodesByStatus = map[int]ResourceErrorCode{
+	http.StatusBadRequest:            ResourceErrorInvalidArgument,
+	http.StatusUnauthorized:          ResourceErrorUnauthorized,
+	http.StatusForbidden:             ResourceErrorForbidden,
+	http.StatusNotFound:              ResourceErrorNotFound,
+	http.StatusRequestTimeout:        ResourceErrorTimeout,
+	http.StatusConflict:              ResourceErrorConflict,
+	http.StatusRequestEntityTooLarge: ResourceErrorTooLarge,
+	http.StatusUnsupportedMediaType:  ResourceErrorUnsupportedType,
+	http.StatusUnprocessableEntity:   ResourceErrorInfected,
+	http.StatusTooManyRequests:       ResourceErrorRateLimited,
+	http.StatusServiceUnavailable:    ResourceErrorUnavailable,
+}
+
+// ResourceError is the error body of the resource routes.
+type ResourceError struct {
+	Error ResourceErrorDetail `json:"error"`
+	// Message repeats Error.Message for the clients reading the message of plain errors.
+	Message string `json:"message"`
+}
+
+type ResourceErrorDetail struct {
+	Code    ResourceErrorCode `json:"code"`
+	Message string            `json:"message"`
+}
+
+// renderResourceError is the middleware of the resource routes rendering their errors as a ResourceError.
+// The status of the errors is kept.
+func renderResourceError(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err == nil {
+			return nil
+		}
+		httpErr := &echo.HTTPError{}
+		if !errors.As(err, &httpErr) {
+			httpErr = echo.NewHTTPError(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)).SetInternal(err)
+		}
+		if _, ok := httpErr.Message.(*ResourceError); ok {
+			return httpErr
+		}
+		message := fmt.Sprint(httpErr.Message)
+		return &echo.HTTPError{
+			Code: httpErr.Code,
+			Message: &ResourceError{
+				Error:   ResourceErrorDetail{Code: getResourceErrorCode(httpErr), Message: message},
+				Message: message,
+			},
+			Internal: httpErr.Internal,
+		}
+	}
+}
+
+// getResourceErrorCode returns the code of the typed cause of the error, or else the code of its status.
+func getResourceErrorCode(httpErr *echo.HTTPError) ResourceErrorCode {
+	sizeErr, infectedErr := &uploadSizeExceededError{}, &resourceInfectedError{}
+	switch err := httpErr.Internal; {
+	case errors.As(err, &sizeErr):
+		return ResourceErrorTooLarge
+	case errors.As(err, &infectedErr):
+		return ResourceErrorInfected
+	case errors.Is(err, errAntivirusUnavailable):
+		return ResourceErrorScanUnavailable
+	}
+	if code, ok := resourceErrorCodesByStatus[httpErr.Code]; ok {
+		return code
+	}
+	return ResourceErrorInternal
+}
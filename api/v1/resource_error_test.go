@@ -0,0 +1,73 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderResourceError(t *testing.T) {
+	tests := []struct {
+		err         error
+		wantStatus  int
+		wantCode    ResourceErrorCode
+		wantMessage string
+	}{
+		{
+			err:         echo.NewHTTPError(http.StatusNotFound, "Resource not found: 1"),
+			wantStatus:  http.StatusNotFound,
+			wantCode:    ResourceErrorNotFound,
+			wantMessage: "Resource not found: 1",
+		},
+		{
+			err:         echo.NewHTTPError(http.StatusBadRequest, "File size exceeds allowed limit of 1 MiB").SetInternal(&uploadSizeExceededError{limit: MebiByte}),
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    ResourceErrorTooLarge,
+			wantMessage: "File size exceeds allowed limit of 1 MiB",
+		},
+		{
+			err:         convertScanError(errors.Wrap(&resourceInfectedError{signature: "Eicar-Signature"}, "failed to save")),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantCode:    ResourceErrorInfected,
+			wantMessage: "File is infected: Eicar-Signature",
+		},
+		{
+			err:         convertScanError(errAntivirusUnavailable),
+			wantStatus:  http.StatusServiceUnavailable,
+			wantCode:    ResourceErrorScanUnavailable,
+			wantMessage: "Failed to scan file",
+		},
+		{
+			err:         echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized"),
+			wantStatus:  http.StatusUnauthorized,
+			wantCode:    ResourceErrorUnauthorized,
+			wantMessage: "Unauthorized",
+		},
+		{
+			err:         errors.New("boom"),
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    ResourceErrorInternal,
+			wantMessage: "Internal Server Error",
+		},
+	}
+	for _, test := range tests {
+		e := echo.New()
+		e.GET("/api/v1/resource", func(echo.Context) error {
+			return test.err
+		}, renderResourceError)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/resource", nil))
+		require.Equal(t, test.wantStatus, rec.Code, test.err.Error())
+
+		body := &ResourceError{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), body))
+		require.Equal(t, test.wantCode, body.Error.Code, test.err.Error())
+		require.Equal(t, test.wantMessage, body.Error.Message, test.err.Error())
+		require.Equal(t, test.wantMessage, body.Message, test.err.Error())
+	}
+}
@@ -0,0 +1,915 @@
+package v1
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lithammer/shortuuid/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usememos/memos/server/profile"
+	"github.com/usememos/memos/store"
+	teststore "github.com/usememos/memos/test/store"
+)
+
+func TestFindDisallowedUploadType(t *testing.T) {
+	tests := []struct {
+		filename  string
+		mimeTypes []string
+		allowlist []string
+		denylist  []string
+		want      string
+		wantOK    bool
+	}{
+		{
+			filename:  "photo.png",
+			mimeTypes: []string{"image/png", "image/png"},
+			wantOK:    true,
+		},
+		{
+			filename:  "photo.png",
+			mimeTypes: []string{"image/png", "image/png"},
+			allowlist: []string{"image/*", "application/pdf"},
+			wantOK:    true,
+		},
+		{
+			filename:  "page.png",
+			mimeTypes: []string{"image/png", "text/html; charset=utf-8"},
+			allowlist: []string{"image/*"},
+			want:      "text/html",
+		},
+		{
+			filename:  "notes.txt",
+			mimeTypes: []string{"text/plain", "text/plain; charset=utf-8"},
+			allowlist: []string{"image/*", "application/pdf"},
+			want:      "text/plain",
+		},
+		{
+			filename:  "document.pdf",
+			mimeTypes: []string{"application/pdf", "application/octet-stream"},
+			allowlist: []string{"application/pdf"},
+			wantOK:    true,
+		},
+		{
+			filename:  "drawing.svg",
+			mimeTypes: []string{"image/svg+xml", "text/xml; charset=utf-8"},
+			denylist:  []string{"image/svg+xml", "text/html"},
+			want:      "image/svg+xml",
+		},
+		{
+			filename:  "setup.EXE",
+			mimeTypes: []string{"application/octet-stream", "application/octet-stream"},
+			denylist:  []string{".exe"},
+			want:      ".exe",
+		},
+		{
+			filename:  "archive.zip",
+			mimeTypes: []string{"application/zip"},
+			allowlist: []string{".zip"},
+			wantOK:    true,
+		},
+	}
+	for _, test := range tests {
+		got, ok := findDisallowedUploadType(test.filename, test.mimeTypes, test.allowlist, test.denylist)
+		if ok != test.wantOK || got != test.want {
+			t.Errorf("findDisallowedUploadType %s %v: got %q %v, want %q %v.", test.filename, test.mimeTypes, got, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestReplacePathTemplateHash(t *testing.T) {
+	const contentHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	if got := replacePathTemplate("blobs/{hash}", &pathTemplateValues{Filename: "test.txt", ContentHash: contentHash}); got != "blobs/"+contentHash {
+		t.Errorf("replacePathTemplate with hash: got %s, want blobs/%s.", got, contentHash)
+	}
+	// Without a computed hash the token falls back to a uuid.
+	got := replacePathTemplate("blobs/{hash}_{filename}", &pathTemplateValues{Filename: "test.txt"})
+	if !regexp.MustCompile(`^blobs/[0-9a-f-]{36}_test\.txt$`).MatchString(got) {
+		t.Errorf("replacePathTemplate without hash: got %s, want uuid fallback.", got)
+	}
+}
+
+func TestReplacePathTemplateCreator(t *testing.T) {
+	tests := []struct {
+		path   string
+		values *pathTemplateValues
+		want   string
+	}{
+		{
+			path:   "users/{creatorId}/{filename}",
+			values: &pathTemplateValues{Filename: "test.txt", CreatorID: 101},
+			want:   "users/101/test.txt",
+		},
+		{
+			path:   "users/{username}/{filename}",
+			values: &pathTemplateValues{Filename: "test.txt", CreatorID: 101, Username: "alice"},
+			want:   "users/alice/test.txt",
+		},
+		{
+			path:   "users/{username}/{filename}",
+			values: &pathTemplateValues{Filename: "test.txt", Username: "../etc"},
+			want:   "users/__etc/test.txt",
+		},
+	}
+	for _, test := range tests {
+		if got := replacePathTemplate(test.path, test.values); got != test.want {
+			t.Errorf("replacePathTemplate %s: got %s, want %s.", test.path, got, test.want)
+		}
+	}
+}
+
+func TestValidatePathTemplate(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{
+			path: "assets/{timestamp}_{filename}",
+		},
+		{
+			path: "users/{creatorId}/{year}/{month}/{hash}_{filename}",
+		},
+		{
+			path:    "assets/{foo}/{filename}",
+			wantErr: true,
+		},
+		{
+			path:    "assets/{Filename}",
+			wantErr: true,
+		},
+		{
+			path:    "assets/../{filename}",
+			wantErr: true,
+		},
+		{
+			path:    "..\\{filename}",
+			wantErr: true,
+		},
+		{
+			path: "assets/..{filename}",
+		},
+		{
+			path: "assets/{random}_{random:4}_{filename}",
+		},
+		{
+			path:    "assets/{random:0}_{filename}",
+			wantErr: true,
+		},
+		{
+			path:    "assets/{random:65}_{filename}",
+			wantErr: true,
+		},
+		{
+			path:    "assets/{random:abc}_{filename}",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		if err := validatePathTemplate(test.path); (err != nil) != test.wantErr {
+			t.Errorf("validatePathTemplate %s: got error %v, want error %v.", test.path, err, test.wantErr)
+		}
+	}
+}
+
+func TestReplacePathTemplateRandom(t *testing.T) {
+	tests := []struct {
+		path    string
+		pattern string
+	}{
+		{
+			path:    "assets/{random}_{filename}",
+			pattern: `^assets/[0-9a-zA-Z]{8}_test\.txt$`,
+		},
+		{
+			path:    "assets/{random:4}/{random:12}",
+			pattern: `^assets/[0-9a-zA-Z]{4}/[0-9a-zA-Z]{12}$`,
+		},
+		{
+			path:    "assets/{random:0}_{filename}",
+			pattern: `^assets/\{random:0\}_test\.txt$`,
+		},
+		{
+			path:    "{year}/{filename}",
+			pattern: `^\d{4}/test\.txt$`,
+		},
+	}
+	for _, test := range tests {
+		got := replacePathTemplate(test.path, &pathTemplateValues{Filename: "test.txt"})
+		if !regexp.MustCompile(test.pattern).MatchString(got) {
+			t.Errorf("replacePathTemplate %s: got %s, want match %s.", test.path, got, test.pattern)
+		}
+	}
+}
+
+func TestPathTemplatePrefix(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "assets/{timestamp}_{filename}", want: "assets/"},
+		{path: "memos/uploads/{year}/{filename}", want: "memos/uploads/"},
+		{path: "memos/{filename}", want: "memos/"},
+		{path: "memos/file_{filename}", want: "memos/"},
+		{path: "{year}/{filename}", want: ""},
+		{path: "static", want: ""},
+	}
+	for _, test := range tests {
+		if got := PathTemplatePrefix(test.path); got != test.want {
+			t.Errorf("PathTemplatePrefix %s: got %s, want %s.", test.path, got, test.want)
+		}
+	}
+}
+
+func TestGetAdminResourceList(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+
+	users := map[store.Role]*store.User{}
+	for _, role := range []store.Role{store.RoleHost, store.RoleAdmin, store.RoleUser} {
+		user, err := ts.CreateUser(ctx, &store.User{
+			Username: string(role),
+			Role:     role,
+			Email:    string(role) + "@test.com",
+			Nickname: string(role),
+		})
+		require.NoError(t, err)
+		users[role] = user
+	}
+	for _, user := range users {
+		_, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    user.ID,
+			Filename:     "test.txt",
+			Blob:         []byte("test"),
+			Type:         "text/plain",
+			Size:         4,
+		})
+		require.NoError(t, err)
+	}
+
+	list := func(userID int32, query string) (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/admin/resource"+query, nil), rec)
+		c.Set(userIDContextKey, userID)
+		return rec, s.GetAdminResourceList(c)
+	}
+
+	for _, role := range []store.Role{store.RoleHost, store.RoleAdmin} {
+		rec, err := list(users[role].ID, "")
+		require.NoError(t, err)
+		resources := []*AdminResource{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resources))
+		require.Len(t, resources, 3)
+		require.Equal(t, "3", rec.Header().Get("X-Total-Count"))
+		require.Equal(t, "database", resources[0].Storage)
+	}
+
+	rec, err := list(users[store.RoleHost].ID, fmt.Sprintf("?creatorId=%d", users[store.RoleUser].ID))
+	require.NoError(t, err)
+	resources := []*AdminResource{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resources))
+	require.Len(t, resources, 1)
+	require.Equal(t, users[store.RoleUser].ID, resources[0].CreatorID)
+
+	for storage, want := range map[string]int{"database": 3, "local": 0} {
+		rec, err := list(users[store.RoleHost].ID, "?storage="+storage)
+		require.NoError(t, err)
+		resources := []*AdminResource{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resources))
+		require.Len(t, resources, want, storage)
+	}
+	_, err = list(users[store.RoleHost].ID, "?storage=s3")
+	httpErr, ok := err.(*echo.HTTPError)
+	require.True(t, ok)
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+
+	// The order is case-insensitive, and applies to the update time without orderBy.
+	for query, ascending := range map[string]bool{"?orderBy=id&order=ASC": true, "?orderBy=id&order=Desc": false, "?order=asc": true, "?order=DESC": false} {
+		rec, err := list(users[store.RoleHost].ID, query)
+		require.NoError(t, err, query)
+		resources := []*AdminResource{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resources))
+		require.Len(t, resources, 3, query)
+		require.Equal(t, ascending, resources[0].ID < resources[2].ID, query)
+	}
+	for _, query := range []string{"?orderBy=id&order=ascending", "?order=up"} {
+		_, err = list(users[store.RoleHost].ID, query)
+		httpErr, ok = err.(*echo.HTTPError)
+		require.True(t, ok, query)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code, query)
+	}
+
+	_, err = list(users[store.RoleUser].ID, "")
+	httpErr, ok = err.(*echo.HTTPError)
+	require.True(t, ok)
+	require.Equal(t, http.StatusUnauthorized, httpErr.Code)
+}
+
+func TestOpenUploadFileBufferSize(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	uploadProfile := *ts.Profile
+	uploadProfile.UploadBufferSize = minUploadBufferSizeBytes
+	s := NewAPIV1Service("", &uploadProfile, ts, nil)
+
+	for _, test := range []struct {
+		size    int64
+		spilled bool
+	}{
+		{size: minUploadBufferSizeBytes / 2},
+		// Parts bigger than the configured buffer are written to a temp file.
+		{size: 2 * minUploadBufferSizeBytes, spilled: true},
+	} {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "notes.txt")
+		require.NoError(t, err)
+		_, err = part.Write(bytes.Repeat([]byte("a"), int(test.size)))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/resource/blob", body)
+		request.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		c := echo.New().NewContext(request, httptest.NewRecorder())
+		_, file, err := s.openUploadFile(c)
+		require.NoError(t, err)
+		_, spilled := file.(*os.File)
+		require.Equal(t, test.spilled, spilled, "size %d", test.size)
+		file.Close()
+		require.NoError(t, request.MultipartForm.RemoveAll())
+	}
+}
+
+func TestGetUploadBufferSizeBytes(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int64
+	}{
+		{size: 0, want: defaultUploadBufferSizeBytes},
+		{size: -1, want: defaultUploadBufferSizeBytes},
+		{size: 1024, want: minUploadBufferSizeBytes},
+		{size: 8 << 20, want: 8 << 20},
+		{size: 4 << 30, want: maxUploadBufferSizeBytes},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.want, getUploadBufferSizeBytes(&profile.Profile{UploadBufferSize: test.size}), "size %d", test.size)
+	}
+}
+
+func TestResourceCursor(t *testing.T) {
+	createdTs, id, err := decodeResourceCursor(encodeResourceCursor(1700000000, 42))
+	require.NoError(t, err)
+	require.Equal(t, int64(1700000000), createdTs)
+	require.Equal(t, int32(42), id)
+
+	for _, cursor := range []string{"", "!!", encodeResourceCursor(1, 2)[:3]} {
+		_, _, err := decodeResourceCursor(cursor)
+		require.Error(t, err, "cursor %q", cursor)
+	}
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodGet, "/?cursor="+encodeResourceCursor(1, 2)+"&offset=10", nil)
+	err = applyResourceListQuery(e.NewContext(request, httptest.NewRecorder()), &store.FindResource{})
+	require.Error(t, err)
+}
+
+func TestMoveResource(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	user, err := ts.CreateUser(ctx, &store.User{
+		Username: "test",
+		Role:     store.RoleUser,
+		Email:    "test@test.com",
+		Nickname: "test",
+	})
+	require.NoError(t, err)
+	content := "moved content"
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    user.ID,
+		Filename:     "notes.txt",
+		BlobReader:   strings.NewReader(content),
+		Type:         "text/plain",
+		Size:         int64(len(content)),
+	})
+	require.NoError(t, err)
+
+	move := func(storageID int32) error {
+		body := strings.NewReader(fmt.Sprintf(`{"storageId":%d}`, storageID))
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/api/v1/resource/1/move", body), httptest.NewRecorder())
+		c.SetParamNames("resourceId")
+		c.SetParamValues(fmt.Sprint(resource.ID))
+		c.Set(userIDContextKey, user.ID)
+		return s.MoveResource(c)
+	}
+	readContent := func() *store.Resource {
+		moved, err := ts.GetResource(ctx, &store.FindResource{ID: &resource.ID})
+		require.NoError(t, err)
+		reader, err := ts.GetResourceContent(ctx, moved)
+		require.NoError(t, err)
+		defer reader.Close()
+		blob, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, content, string(blob))
+		require.Equal(t, resource.Sha256, moved.Sha256)
+		return moved
+	}
+
+	require.NoError(t, move(LocalStorage))
+	moved := readContent()
+	require.NotEmpty(t, moved.InternalPath)
+	localPath := filepath.Join(ts.Profile.Data, filepath.FromSlash(moved.InternalPath))
+	require.FileExists(t, localPath)
+
+	err = move(LocalStorage)
+	httpErr, ok := err.(*echo.HTTPError)
+	require.True(t, ok)
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+
+	require.NoError(t, move(DatabaseStorage))
+	moved = readContent()
+	require.Empty(t, moved.InternalPath)
+	require.NoFileExists(t, localPath)
+
+	err = move(42)
+	httpErr, ok = err.(*echo.HTTPError)
+	require.True(t, ok)
+	require.Equal(t, http.StatusNotFound, httpErr.Code)
+}
+
+func TestGetResourceBlob(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	content := "raw content"
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "notes.txt",
+		BlobReader:   strings.NewReader(content),
+		Type:         "text/plain",
+		Size:         int64(len(content)),
+	})
+	require.NoError(t, err)
+	external, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "external.txt",
+		ExternalLink: "https://example.com/external.txt",
+		Type:         "text/plain",
+	})
+	require.NoError(t, err)
+
+	get := func(userID int32, resourceID int32) (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/resource/1/blob?download=1", nil), rec)
+		c.SetParamNames("resourceId")
+		c.SetParamValues(fmt.Sprint(resourceID))
+		c.Set(userIDContextKey, userID)
+		return rec, s.GetResourceBlob(c)
+	}
+
+	rec, err := get(101, resource.ID)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, content, rec.Body.String())
+	require.Equal(t, `attachment; filename="notes.txt"`, rec.Header().Get(echo.HeaderContentDisposition))
+	require.Equal(t, "private, no-store", rec.Header().Get(echo.HeaderCacheControl))
+	require.Equal(t, "nosniff", rec.Header().Get(echo.HeaderXContentTypeOptions))
+	require.NotEmpty(t, rec.Header().Get(echo.HeaderContentSecurityPolicy))
+
+	for _, test := range []struct {
+		userID     int32
+		resourceID int32
+		wantStatus int
+	}{
+		{userID: 102, resourceID: resource.ID, wantStatus: http.StatusUnauthorized},
+		{userID: 101, resourceID: 42, wantStatus: http.StatusNotFound},
+		{userID: 101, resourceID: external.ID, wantStatus: http.StatusBadRequest},
+	} {
+		_, err := get(test.userID, test.resourceID)
+		httpErr, ok := err.(*echo.HTTPError)
+		require.True(t, ok, "%+v", test)
+		require.Equal(t, test.wantStatus, httpErr.Code, "%+v", test)
+	}
+}
+
+func TestGetUploadOriginalTs(t *testing.T) {
+	tests := []struct {
+		field        string
+		lastModified string
+		want         int64
+		wantStatus   int
+	}{
+		{},
+		{field: "1136214245", want: 1136214245},
+		{field: "1136214245", lastModified: "Mon, 02 Jan 2006 15:04:05 GMT", want: 1136214245},
+		{lastModified: "Mon, 02 Jan 2006 15:04:05 GMT", want: 1136214245},
+		{lastModified: "yesterday"},
+		{field: "yesterday", wantStatus: http.StatusBadRequest},
+		{field: "-1", wantStatus: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		body := &strings.Builder{}
+		writer := multipart.NewWriter(body)
+		header := textproto.MIMEHeader{}
+		header.Set(echo.HeaderContentDisposition, `form-data; name="file"; filename="test.txt"`)
+		if test.lastModified != "" {
+			header.Set(echo.HeaderLastModified, test.lastModified)
+		}
+		part, err := writer.CreatePart(header)
+		require.NoError(t, err)
+		_, err = part.Write([]byte("hello"))
+		require.NoError(t, err)
+		if test.field != "" {
+			require.NoError(t, writer.WriteField("originalTs", test.field))
+		}
+		require.NoError(t, writer.Close())
+
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/resource/blob", strings.NewReader(body.String()))
+		request.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		c := echo.New().NewContext(request, httptest.NewRecorder())
+		file, err := c.FormFile("file")
+		require.NoError(t, err)
+		originalTs, err := getUploadOriginalTs(c, file)
+		if test.wantStatus != 0 {
+			httpErr, ok := err.(*echo.HTTPError)
+			require.True(t, ok, "%+v", test)
+			require.Equal(t, test.wantStatus, httpErr.Code, "%+v", test)
+			continue
+		}
+		require.NoError(t, err, "%+v", test)
+		if test.want == 0 {
+			require.Nil(t, originalTs, "%+v", test)
+		} else {
+			require.Equal(t, test.want, *originalTs, "%+v", test)
+		}
+	}
+}
+
+func TestSaveResourceBlobSha256(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	value, err := json.Marshal(LocalStorage)
+	require.NoError(t, err)
+	_, err = ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: SystemSettingStorageServiceIDName.String(), Value: string(value)})
+	require.NoError(t, err)
+
+	create := &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "test.txt",
+		Type:         "text/plain",
+		Size:         5,
+	}
+	require.NoError(t, s.SaveResourceBlob(ctx, create, strings.NewReader("hello")))
+	require.NotEmpty(t, create.InternalPath)
+	require.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", create.Sha256)
+	resource, err := ts.CreateResource(ctx, create)
+	require.NoError(t, err)
+	require.Equal(t, create.Sha256, convertResourceFromStore(resource).Sha256)
+}
+
+func TestSaveResourceBlobUploadType(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	_, err := ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: SystemSettingAllowedUploadTypesName.String(), Value: `["image/*"]`})
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		filename string
+		mimeType string
+		content  string
+		allowed  bool
+	}{
+		{filename: "photo.png", mimeType: "image/png", content: "\x89PNG\r\n\x1a\n", allowed: true},
+		{filename: "page.html", mimeType: "text/html", content: "<html></html>"},
+		// The sniffed content gives away a disguised file.
+		{filename: "photo.png", mimeType: "image/png", content: "<html><script></script></html>"},
+	} {
+		create := &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     test.filename,
+			Type:         test.mimeType,
+			Size:         int64(len(test.content)),
+		}
+		err := s.SaveResourceBlob(ctx, create, strings.NewReader(test.content))
+		if test.allowed {
+			require.NoError(t, err, test.content)
+			// The sniffed head is kept in the stored content.
+			content, err := io.ReadAll(create.BlobReader)
+			require.NoError(t, err)
+			require.Equal(t, test.content, string(content))
+			continue
+		}
+		httpErr := convertUploadTypeError(err)
+		require.NotNil(t, httpErr, test.content)
+		require.Equal(t, http.StatusUnsupportedMediaType, httpErr.Code)
+	}
+}
+
+func TestSaveResourceBlobOversizeImage(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	_, err := ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: SystemSettingStripImageMetadataName.String(), Value: "true"})
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 1, 1))))
+	// Small enough to be uploaded, with far too many pixels to be decoded.
+	content := withPNGSize(t, buf.Bytes(), 30000, 30000)
+
+	create := &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "huge.png",
+		Type:         "image/png",
+		Size:         int64(len(content)),
+	}
+	err = s.SaveResourceBlob(ctx, create, bytes.NewReader(content))
+	httpErr := convertImageError(err)
+	require.NotNil(t, httpErr)
+	require.Equal(t, http.StatusRequestEntityTooLarge, httpErr.Code)
+}
+
+func TestUpdateResourceType(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	_, err := ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: SystemSettingDeniedUploadTypesName.String(), Value: `["text/html"]`})
+	require.NoError(t, err)
+	content := "%PDF-1.4"
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "scan",
+		Blob:         []byte(content),
+		Type:         "application/octet-stream",
+		Size:         int64(len(content)),
+	})
+	require.NoError(t, err)
+
+	patch := func(body string) error {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodPatch, "/api/v1/resource/1", strings.NewReader(body)), httptest.NewRecorder())
+		c.SetParamNames("resourceId")
+		c.SetParamValues(fmt.Sprint(resource.ID))
+		c.Set(userIDContextKey, int32(101))
+		return s.UpdateResource(c)
+	}
+	for _, test := range []struct {
+		body       string
+		wantStatus int
+	}{
+		{body: `{"type":"pdf"}`, wantStatus: http.StatusBadRequest},
+		{body: `{"type":"application/"}`, wantStatus: http.StatusBadRequest},
+		{body: `{"type":"application/pdf\r\nX: y"}`, wantStatus: http.StatusBadRequest},
+		{body: `{"type":"text/html"}`, wantStatus: http.StatusUnsupportedMediaType},
+	} {
+		err := patch(test.body)
+		httpErr, ok := err.(*echo.HTTPError)
+		require.True(t, ok, test.body)
+		require.Equal(t, test.wantStatus, httpErr.Code, test.body)
+	}
+
+	require.NoError(t, patch(`{"type":"application/pdf"}`))
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/resource/1/blob", nil), rec)
+	c.SetParamNames("resourceId")
+	c.SetParamValues(fmt.Sprint(resource.ID))
+	c.Set(userIDContextKey, int32(101))
+	require.NoError(t, s.GetResourceBlob(c))
+	require.Equal(t, "application/pdf", rec.Header().Get(echo.HeaderContentType))
+	require.Equal(t, content, rec.Body.String())
+}
+
+func TestSaveResourceBlobSidecar(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	for name, value := range map[SystemSettingName]string{
+		SystemSettingStorageServiceIDName:        fmt.Sprint(LocalStorage),
+		SystemSettingResourceMetadataSidecarName: "true",
+	} {
+		_, err := ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: name.String(), Value: value})
+		require.NoError(t, err)
+	}
+
+	create := &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "test.txt",
+		Type:         "text/plain",
+		Size:         5,
+	}
+	require.NoError(t, s.SaveResourceBlob(ctx, create, strings.NewReader("hello")))
+	resource, err := ts.CreateResource(ctx, create)
+	require.NoError(t, err)
+
+	sidecarPath := filepath.Join(ts.Profile.Data, filepath.FromSlash(resource.InternalPath)) + store.ResourceSidecarSuffix
+	content, err := os.ReadFile(sidecarPath)
+	require.NoError(t, err)
+	sidecar := &store.ResourceSidecar{}
+	require.NoError(t, json.Unmarshal(content, sidecar))
+	require.Equal(t, "test.txt", sidecar.Filename)
+	require.Equal(t, "text/plain", sidecar.Type)
+	require.Equal(t, int64(5), sidecar.Size)
+	require.Equal(t, int32(101), sidecar.CreatorID)
+	require.NotZero(t, sidecar.CreatedTs)
+
+	// The sidecar is deleted with the resource.
+	require.NoError(t, ts.DeleteResource(ctx, &store.DeleteResource{ID: resource.ID}))
+	require.NoFileExists(t, sidecarPath)
+}
+
+func TestSaveResourceBlobLocalCompression(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	for name, value := range map[SystemSettingName]string{
+		SystemSettingStorageServiceIDName:        fmt.Sprint(LocalStorage),
+		SystemSettingLocalStorageCompressionName: "true",
+		SystemSettingResourceMetadataSidecarName: "true",
+	} {
+		_, err := ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: name.String(), Value: value})
+		require.NoError(t, err)
+	}
+
+	text := strings.Repeat("compressible text ", 100)
+	archive := &bytes.Buffer{}
+	writer := gzip.NewWriter(archive)
+	_, err := writer.Write([]byte(text))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	for _, test := range []struct {
+		filename   string
+		mimeType   string
+		content    string
+		compressed bool
+	}{
+		{filename: "notes.txt", mimeType: "text/plain", content: text, compressed: true},
+		{filename: "photo.png", mimeType: "image/png", content: text},
+		// Uploaded already compressed, it's stored and served as-is.
+		{filename: "backup.tar.gz", mimeType: "application/x-compressed-tar", content: archive.String()},
+	} {
+		content := test.content
+		contentHash := sha256.Sum256([]byte(content))
+		create := &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     test.filename,
+			Type:         test.mimeType,
+			Size:         int64(len(content)),
+		}
+		require.NoError(t, s.SaveResourceBlob(ctx, create, strings.NewReader(content)))
+		resource, err := ts.CreateResource(ctx, create)
+		require.NoError(t, err)
+		require.Equal(t, test.compressed, resource.Compression == store.ResourceCompressionGzip, test.filename)
+		require.Equal(t, hex.EncodeToString(contentHash[:]), resource.Sha256, test.filename)
+
+		osPath := filepath.Join(ts.Profile.Data, filepath.FromSlash(resource.InternalPath))
+		stored, err := os.ReadFile(osPath)
+		require.NoError(t, err)
+		if test.compressed {
+			require.Less(t, len(stored), len(content))
+			reader, err := gzip.NewReader(bytes.NewReader(stored))
+			require.NoError(t, err)
+			stored, err = io.ReadAll(reader)
+			require.NoError(t, err)
+		}
+		require.Equal(t, content, string(stored), test.filename)
+
+		sidecarContent, err := os.ReadFile(osPath + store.ResourceSidecarSuffix)
+		require.NoError(t, err)
+		sidecar := &store.ResourceSidecar{}
+		require.NoError(t, json.Unmarshal(sidecarContent, sidecar))
+		require.Equal(t, int64(len(content)), sidecar.Size, test.filename)
+
+		reader, err := ts.GetResourceContent(ctx, resource)
+		require.NoError(t, err)
+		read, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, content, string(read), test.filename)
+
+		require.NoError(t, ts.DeleteResource(ctx, &store.DeleteResource{ID: resource.ID}))
+		require.NoFileExists(t, osPath)
+	}
+}
+
+func TestCreateResourceDefaultFilename(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	for _, test := range []struct {
+		body string
+		want string
+	}{
+		{body: `{"externalLink":"https://8.8.8.8/files/report.pdf","type":"application/pdf"}`, want: "report.pdf"},
+		{body: `{"externalLink":"https://8.8.8.8/","type":"image/jpeg"}`, want: "download.jpg"},
+		{body: `{"filename":"named.png","externalLink":"https://8.8.8.8/files/other.png","type":"image/png"}`, want: "named.png"},
+	} {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/api/v1/resource", strings.NewReader(test.body)), rec)
+		c.Set(userIDContextKey, int32(101))
+		require.NoError(t, s.CreateResource(c), test.body)
+		resource := &Resource{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), resource))
+		require.Equal(t, test.want, resource.Filename, test.body)
+	}
+}
+
+func TestUnlinkResource(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+	user, err := ts.CreateUser(ctx, &store.User{
+		Username: "test",
+		Role:     store.RoleUser,
+		Email:    "test@test.com",
+		Nickname: "test",
+	})
+	require.NoError(t, err)
+	memo, err := ts.CreateMemo(ctx, &store.Memo{
+		ResourceName: shortuuid.New(),
+		CreatorID:    user.ID,
+		Content:      "memo with a file",
+		Visibility:   store.Private,
+	})
+	require.NoError(t, err)
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    user.ID,
+		Filename:     "notes.txt",
+		Blob:         []byte("kept"),
+		Type:         "text/plain",
+		Size:         4,
+		MemoID:       &memo.ID,
+	})
+	require.NoError(t, err)
+
+	unlink := func(userID int32) error {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/api/v1/resource/1/unlink", nil), rec)
+		c.SetParamNames("resourceId")
+		c.SetParamValues(fmt.Sprint(resource.ID))
+		c.Set(userIDContextKey, userID)
+		if err := s.UnlinkResource(c); err != nil {
+			return err
+		}
+		unlinked := &Resource{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), unlinked))
+		require.Equal(t, resource.ID, unlinked.ID)
+		return nil
+	}
+
+	err = unlink(user.ID + 1)
+	httpErr, ok := err.(*echo.HTTPError)
+	require.True(t, ok)
+	require.Equal(t, http.StatusUnauthorized, httpErr.Code)
+
+	// Unlinking an unlinked resource is a no-op.
+	require.NoError(t, unlink(user.ID))
+	require.NoError(t, unlink(user.ID))
+	found, err := ts.GetResource(ctx, &store.FindResource{ID: &resource.ID, GetBlob: true})
+	require.NoError(t, err)
+	require.Nil(t, found.MemoID)
+	require.Equal(t, []byte("kept"), found.Blob)
+	memoResources, err := ts.ListResources(ctx, &store.FindResource{MemoID: &memo.ID})
+	require.NoError(t, err)
+	require.Empty(t, memoResources)
+}
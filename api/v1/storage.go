@@ -1,13 +1,26 @@
 package v1
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
 
+	"github.com/usememos/memos/internal/log"
 	"github.com/usememos/memos/internal/util"
+	"github.com/usememos/memos/plugin/storage/s3"
 	"github.com/usememos/memos/store"
 )
 
@@ -18,8 +31,20 @@ const (
 	DatabaseStorage int32 = 0
 	// Default storage service is database.
 	DefaultStorage int32 = DatabaseStorage
+
+	// storageProbePrefix is the key prefix of the objects uploaded by storage health checks.
+	storageProbePrefix = ".memos-health-check"
+	// storageProbeCleanupTimeout is the time allowed to delete the probe object.
+	storageProbeCleanupTimeout = 10 * time.Second
+	// maxObjectTagKeyLength is the maximum length of S3 object tag keys.
+	maxObjectTagKeyLength = 128
+	// maxObjectTagValueLength is the maximum length of S3 object tag values.
+	maxObjectTagValueLength = 256
 )
 
+// resourceMetadataTagKeys are the object tag keys set from the resource metadata, see StorageS3Config.TagResourceMetadata.
+var resourceMetadataTagKeys = []string{"memos-category", "memos-creator-id"}
+
 type StorageType string
 
 const (
@@ -44,6 +69,17 @@ type StorageS3Config struct {
 	URLPrefix string `json:"urlPrefix"`
 	URLSuffix string `json:"urlSuffix"`
 	PreSign   bool   `json:"presign"`
+	// PartSize is the multipart upload part size in bytes, at least 5 MiB, 0 uses the default.
+	PartSize int64 `json:"partSize,omitempty"`
+	// Concurrency is the amount of parts uploaded in parallel, 0 uses the default.
+	Concurrency int `json:"concurrency,omitempty"`
+	// LeavePartsOnError keeps the parts of failed multipart uploads, they are billed until removed.
+	LeavePartsOnError bool `json:"leavePartsOnError,omitempty"`
+	// ObjectTags are the tags set on every uploaded object, e.g. to match lifecycle rules.
+	ObjectTags map[string]string `json:"objectTags,omitempty"`
+	// TagResourceMetadata also tags the objects with the MIME type category and the creator ID of the resource,
+	// as `memos-category` and `memos-creator-id`.
+	TagResourceMetadata bool `json:"tagResourceMetadata,omitempty"`
 }
 
 type Storage struct {
@@ -59,6 +95,13 @@ type CreateStorageRequest struct {
 	Config *StorageConfig `json:"config"`
 }
 
+type StorageHealth struct {
+	Healthy bool `json:"healthy"`
+	// Latency of the probe round-trip in milliseconds.
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
 type UpdateStorageRequest struct {
 	Type   StorageType    `json:"type"`
 	Name   *string        `json:"name"`
@@ -68,6 +111,7 @@ type UpdateStorageRequest struct {
 func (s *APIV1Service) registerStorageRoutes(g *echo.Group) {
 	g.GET("/storage", s.GetStorageList)
 	g.POST("/storage", s.CreateStorage)
+	g.GET("/storage/:storageId/health", s.GetStorageHealth)
 	g.PATCH("/storage/:storageId", s.UpdateStorage)
 	g.DELETE("/storage/:storageId", s.DeleteStorage)
 }
@@ -121,11 +165,12 @@ func (s *APIV1Service) GetStorageList(c echo.Context) error {
 //	@Tags		storage
 //	@Accept		json
 //	@Produce	json
-//	@Param		body	body		CreateStorageRequest	true	"Request object."
-//	@Success	200		{object}	store.Storage			"Created storage"
-//	@Failure	400		{object}	nil						"Malformatted post storage request"
-//	@Failure	401		{object}	nil						"Missing user in session"
-//	@Failure	500		{object}	nil						"Failed to find user | Failed to create storage | Failed to convert storage"
+//	@Param		body			body		CreateStorageRequest	true	"Request object."
+//	@Param		skipValidation	query		bool					false	"Skip probing the storage"
+//	@Success	200				{object}	store.Storage			"Created storage"
+//	@Failure	400				{object}	nil						"Malformatted post storage request | Invalid storage config: %v | Storage validation failed: %v"
+//	@Failure	401				{object}	nil						"Missing user in session"
+//	@Failure	500				{object}	nil						"Failed to find user | Failed to create storage | Failed to convert storage"
 //	@Router		/api/v1/storage [POST]
 func (s *APIV1Service) CreateStorage(c echo.Context) error {
 	ctx := c.Request().Context()
@@ -151,12 +196,18 @@ func (s *APIV1Service) CreateStorage(c echo.Context) error {
 
 	configString := ""
 	if create.Type == StorageS3 && create.Config.S3Config != nil {
+		if err := validateS3Config(create.Config.S3Config); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid storage config: %v", err)).SetInternal(err)
+		}
 		configBytes, err := json.Marshal(create.Config.S3Config)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "Malformatted post storage request").SetInternal(err)
 		}
 		configString = string(configBytes)
 	}
+	if err := validateStorage(c, &Storage{Type: create.Type, Config: create.Config}); err != nil {
+		return err
+	}
 
 	storage, err := s.Store.CreateStorage(ctx, &store.Storage{
 		Name:   create.Name,
@@ -235,12 +286,13 @@ func (s *APIV1Service) DeleteStorage(c echo.Context) error {
 //	@Summary	Update a storage
 //	@Tags		storage
 //	@Produce	json
-//	@Param		storageId	path		int						true	"Storage ID"
-//	@Param		patch		body		UpdateStorageRequest	true	"Patch request"
-//	@Success	200			{object}	store.Storage			"Updated resource"
-//	@Failure	400			{object}	nil						"ID is not a number: %s | Malformatted patch storage request | Malformatted post storage request"
-//	@Failure	401			{object}	nil						"Missing user in session | Unauthorized"
-//	@Failure	500			{object}	nil						"Failed to find user | Failed to patch storage | Failed to convert storage"
+//	@Param		storageId		path		int						true	"Storage ID"
+//	@Param		patch			body		UpdateStorageRequest	true	"Patch request"
+//	@Param		skipValidation	query		bool					false	"Skip probing the storage"
+//	@Success	200				{object}	store.Storage			"Updated resource"
+//	@Failure	400				{object}	nil						"ID is not a number: %s | Malformatted patch storage request | Malformatted post storage request | Invalid storage config: %v | Storage validation failed: %v"
+//	@Failure	401				{object}	nil						"Missing user in session | Unauthorized"
+//	@Failure	500				{object}	nil						"Failed to find user | Failed to patch storage | Failed to convert storage"
 //	@Router		/api/v1/storage/{storageId} [PATCH]
 func (s *APIV1Service) UpdateStorage(c echo.Context) error {
 	ctx := c.Request().Context()
@@ -276,12 +328,20 @@ func (s *APIV1Service) UpdateStorage(c echo.Context) error {
 	}
 	if update.Config != nil {
 		if update.Type == StorageS3 {
+			if update.Config.S3Config != nil {
+				if err := validateS3Config(update.Config.S3Config); err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid storage config: %v", err)).SetInternal(err)
+				}
+			}
 			configBytes, err := json.Marshal(update.Config.S3Config)
 			if err != nil {
 				return echo.NewHTTPError(http.StatusBadRequest, "Malformatted post storage request").SetInternal(err)
 			}
 			configString := string(configBytes)
 			storageUpdate.Config = &configString
+			if err := validateStorage(c, &Storage{Type: update.Type, Config: update.Config}); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -296,6 +356,176 @@ func (s *APIV1Service) UpdateStorage(c echo.Context) error {
 	return c.JSON(http.StatusOK, storageMessage)
 }
 
+// GetStorageHealth godoc
+//
+//	@Summary	Check that a storage is reachable
+//	@Tags		storage
+//	@Produce	json
+//	@Param		storageId	path		int				true	"Storage ID"
+//	@Success	200			{object}	StorageHealth	"Storage health"
+//	@Failure	400			{object}	nil				"ID is not a number: %s"
+//	@Failure	401			{object}	nil				"Missing user in session | Unauthorized"
+//	@Failure	404			{object}	nil				"Storage not found: %d"
+//	@Failure	500			{object}	nil				"Failed to find user | Failed to find storage | Failed to convert storage"
+//	@Router		/api/v1/storage/{storageId}/health [GET]
+func (s *APIV1Service) GetStorageHealth(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get(userIDContextKey).(int32)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing user in session")
+	}
+
+	user, err := s.Store.GetUser(ctx, &store.FindUser{
+		ID: &userID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find user").SetInternal(err)
+	}
+	if user == nil || user.Role != store.RoleHost {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	storageID, err := util.ConvertStringToInt32(c.Param("storageId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ID is not a number: %s", c.Param("storageId"))).SetInternal(err)
+	}
+
+	storage, err := s.Store.GetStorage(ctx, &store.FindStorage{
+		ID: &storageID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find storage").SetInternal(err)
+	}
+	if storage == nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Storage not found: %d", storageID))
+	}
+	storageMessage, err := ConvertStorageFromStore(storage)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to convert storage").SetInternal(err)
+	}
+
+	health := &StorageHealth{}
+	startTime := time.Now()
+	err = probeStorage(ctx, storageMessage)
+	health.LatencyMs = time.Since(startTime).Milliseconds()
+	if err != nil {
+		health.Error = err.Error()
+	} else {
+		health.Healthy = true
+	}
+	return c.JSON(http.StatusOK, health)
+}
+
+// validateStorage probes the storage configuration unless the request sets skipValidation=true for offline setups.
+// The returned error is an echo.HTTPError ready to be returned by the handler.
+func validateStorage(c echo.Context, storage *Storage) error {
+	if skipValidation, _ := strconv.ParseBool(c.QueryParam("skipValidation")); skipValidation {
+		return nil
+	}
+	if err := probeStorage(c.Request().Context(), storage); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Storage validation failed: %v", err)).SetInternal(err)
+	}
+	return nil
+}
+
+// probeStorage uploads a tiny object to the storage, reads it back and deletes it.
+func probeStorage(ctx context.Context, storage *Storage) error {
+	if storage.Type != StorageS3 || storage.Config == nil || storage.Config.S3Config == nil {
+		return errors.Errorf("Unsupported storage type: %s", storage.Type)
+	}
+	s3Client, err := newS3Client(ctx, storage.Config.S3Config)
+	if err != nil {
+		return errors.Wrap(err, "failed to create s3 client")
+	}
+
+	probeKey := fmt.Sprintf("%s/%s", storageProbePrefix, util.GenUUID())
+	probeContent := []byte("memos storage health check")
+	if _, err := s3Client.UploadFile(ctx, probeKey, "text/plain", bytes.NewReader(probeContent)); err != nil {
+		return errors.Wrap(err, "failed to upload probe object")
+	}
+	defer func() {
+		// Clean up even if the request context is already canceled.
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), storageProbeCleanupTimeout)
+		defer cancel()
+		if err := s3Client.DeleteFile(cleanupCtx, probeKey); err != nil {
+			log.Warn("failed to delete storage probe object", zap.String("key", probeKey), zap.Error(err))
+		}
+	}()
+
+	reader, err := s3Client.GetFile(ctx, probeKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to download probe object")
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return errors.Wrap(err, "failed to read probe object")
+	}
+	if !bytes.Equal(content, probeContent) {
+		return errors.New("probe object content mismatch")
+	}
+	return nil
+}
+
+func newS3Client(ctx context.Context, s3Config *StorageS3Config) (*s3.Client, error) {
+	return s3.NewClient(ctx, &s3.Config{
+		AccessKey: s3Config.AccessKey,
+		SecretKey: s3Config.SecretKey,
+		EndPoint:  s3Config.EndPoint,
+		Region:    s3Config.Region,
+		Bucket:    s3Config.Bucket,
+		URLPrefix: s3Config.URLPrefix,
+		URLSuffix: s3Config.URLSuffix,
+		PreSign:   s3Config.PreSign,
+
+		PartSize:          s3Config.PartSize,
+		Concurrency:       s3Config.Concurrency,
+		LeavePartsOnError: s3Config.LeavePartsOnError,
+		ObjectTags:        s3Config.ObjectTags,
+	})
+}
+
+// validateS3Config checks the S3 config values that can be verified without connecting to the storage.
+func validateS3Config(s3Config *StorageS3Config) error {
+	if err := validatePathTemplate(s3Config.Path); err != nil {
+		return errors.Wrap(err, "invalid path")
+	}
+	if s3Config.PartSize != 0 && s3Config.PartSize < manager.MinUploadPartSize {
+		return errors.Errorf("part size must be at least %d bytes", manager.MinUploadPartSize)
+	}
+	if s3Config.Concurrency < 0 {
+		return errors.New("concurrency must not be negative")
+	}
+	maxObjectTags := s3.MaxObjectTags
+	if s3Config.TagResourceMetadata {
+		maxObjectTags -= len(resourceMetadataTagKeys)
+	}
+	if len(s3Config.ObjectTags) > maxObjectTags {
+		return errors.Errorf("at most %d object tags are allowed", maxObjectTags)
+	}
+	for key, value := range s3Config.ObjectTags {
+		if key == "" || utf8.RuneCountInString(key) > maxObjectTagKeyLength {
+			return errors.Errorf("object tag key must be 1 to %d characters", maxObjectTagKeyLength)
+		}
+		if utf8.RuneCountInString(value) > maxObjectTagValueLength {
+			return errors.Errorf("object tag %s value must be at most %d characters", key, maxObjectTagValueLength)
+		}
+		if s3Config.TagResourceMetadata && slices.Contains(resourceMetadataTagKeys, key) {
+			return errors.Errorf("object tag %s is set from the resource metadata", key)
+		}
+	}
+	return nil
+}
+
+// getResourceMetadataTags returns the object tags of the resource set by StorageS3Config.TagResourceMetadata.
+func getResourceMetadataTags(create *store.Resource) map[string]string {
+	category, _, _ := strings.Cut(create.Type, "/")
+	return map[string]string{
+		resourceMetadataTagKeys[0]: category,
+		resourceMetadataTagKeys[1]: strconv.Itoa(int(create.CreatorID)),
+	}
+}
+
 func ConvertStorageFromStore(storage *store.Storage) (*Storage, error) {
 	storageMessage := &Storage{
 		ID:     storage.ID,
@@ -314,3 +544,32 @@ func ConvertStorageFromStore(storage *store.Storage) (*Storage, error) {
 	}
 	return storageMessage, nil
 }
+
+// ParseStorageServiceID parses a storage selector, `database`, `local` or a storage ID, into a storage service ID.
+func ParseStorageServiceID(value string) (int32, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "database":
+		return DatabaseStorage, nil
+	case "local":
+		return LocalStorage, nil
+	}
+	id, err := util.ConvertStringToInt32(strings.TrimSpace(value))
+	if err != nil || id < LocalStorage {
+		return 0, errors.Errorf("invalid storage %q, expected database, local or a storage ID", value)
+	}
+	return id, nil
+}
+
+// findStorageRoutingRule returns the first rule matching the resource type and size, or nil if none match.
+func findStorageRoutingRule(rules []StorageRoutingRule, mimeType string, size int64) *StorageRoutingRule {
+	for i, rule := range rules {
+		if rule.MimeTypePrefix != "" && !strings.HasPrefix(strings.ToLower(mimeType), strings.ToLower(rule.MimeTypePrefix)) {
+			continue
+		}
+		if size < rule.MinSize || (rule.MaxSize > 0 && size > rule.MaxSize) {
+			continue
+		}
+		return &rules[i]
+	}
+	return nil
+}
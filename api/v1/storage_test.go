@@ -0,0 +1,122 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindStorageRoutingRule(t *testing.T) {
+	rules := []StorageRoutingRule{
+		{
+			MimeTypePrefix: "video/",
+			MinSize:        100 * MebiByte,
+			StorageID:      2,
+		},
+		{
+			MimeTypePrefix: "image/",
+			StorageID:      1,
+		},
+		{
+			MinSize:   10 * MebiByte,
+			StorageID: LocalStorage,
+		},
+	}
+	tests := []struct {
+		mimeType string
+		size     int64
+		want     int32
+		wantOK   bool
+	}{
+		{
+			mimeType: "image/png",
+			size:     1024,
+			want:     1,
+			wantOK:   true,
+		},
+		{
+			mimeType: "Image/JPEG",
+			size:     20 * MebiByte,
+			want:     1,
+			wantOK:   true,
+		},
+		{
+			mimeType: "video/mp4",
+			size:     200 * MebiByte,
+			want:     2,
+			wantOK:   true,
+		},
+		{
+			mimeType: "video/mp4",
+			size:     20 * MebiByte,
+			want:     LocalStorage,
+			wantOK:   true,
+		},
+		{
+			mimeType: "application/pdf",
+			size:     1024,
+		},
+	}
+	for _, test := range tests {
+		rule := findStorageRoutingRule(rules, test.mimeType, test.size)
+		if (rule != nil) != test.wantOK {
+			t.Errorf("findStorageRoutingRule %s %d: got rule %v, want match %v.", test.mimeType, test.size, rule, test.wantOK)
+			continue
+		}
+		if rule != nil && rule.StorageID != test.want {
+			t.Errorf("findStorageRoutingRule %s %d: got storage %d, want %d.", test.mimeType, test.size, rule.StorageID, test.want)
+		}
+	}
+
+	// Rules with an upper bound.
+	rules = []StorageRoutingRule{{MaxSize: MebiByte, StorageID: DatabaseStorage}}
+	if rule := findStorageRoutingRule(rules, "text/plain", 2*MebiByte); rule != nil {
+		t.Errorf("findStorageRoutingRule with max size: got storage %d, want no match.", rule.StorageID)
+	}
+}
+
+func TestParseStorageServiceID(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int32
+		wantErr bool
+	}{
+		{value: "database", want: DatabaseStorage},
+		{value: "Local", want: LocalStorage},
+		{value: " 3 ", want: 3},
+		{value: "-1", want: LocalStorage},
+		{value: "-2", wantErr: true},
+		{value: "s3", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseStorageServiceID(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseStorageServiceID %q: got error %v, want error %v.", test.value, err, test.wantErr)
+			continue
+		}
+		if err == nil && got != test.want {
+			t.Errorf("ParseStorageServiceID %q: got %d, want %d.", test.value, got, test.want)
+		}
+	}
+}
+
+func TestValidateS3Config(t *testing.T) {
+	tests := []struct {
+		config  *StorageS3Config
+		wantErr bool
+	}{
+		{config: &StorageS3Config{Path: "assets/{filename}"}},
+		{config: &StorageS3Config{Path: "assets/{filename}", PartSize: 8 * MebiByte, Concurrency: 3}},
+		{config: &StorageS3Config{Path: "assets/{filename}", PartSize: MebiByte}, wantErr: true},
+		{config: &StorageS3Config{Path: "assets/{filename}", Concurrency: -1}, wantErr: true},
+		{config: &StorageS3Config{Path: "../{filename}"}, wantErr: true},
+		{config: &StorageS3Config{Path: "assets/{filename}", ObjectTags: map[string]string{"tier": "cold"}, TagResourceMetadata: true}},
+		{config: &StorageS3Config{Path: "assets/{filename}", ObjectTags: map[string]string{"": "cold"}}, wantErr: true},
+		{config: &StorageS3Config{Path: "assets/{filename}", ObjectTags: map[string]string{"tier": strings.Repeat("a", 257)}}, wantErr: true},
+		{config: &StorageS3Config{Path: "assets/{filename}", ObjectTags: map[string]string{"memos-category": "image"}, TagResourceMetadata: true}, wantErr: true},
+	}
+	for _, test := range tests {
+		if err := validateS3Config(test.config); (err != nil) != test.wantErr {
+			t.Errorf("validateS3Config %+v: got error %v, want error %v.", test.config, err, test.wantErr)
+		}
+	}
+}
@@ -38,6 +38,24 @@ type SystemStatus struct {
 	LocalStoragePath string `json:"localStoragePath"`
 	// Memo display with updated timestamp.
 	MemoDisplayWithUpdatedTs bool `json:"memoDisplayWithUpdatedTs"`
+	// Strip EXIF and other metadata from uploaded images.
+	StripImageMetadata bool `json:"stripImageMetadata"`
+	// Apply the EXIF orientation to the pixels of uploaded images.
+	AutoOrientImages bool `json:"autoOrientImages"`
+	// Write a metadata sidecar next to every blob of the local and external storages.
+	ResourceMetadataSidecar bool `json:"resourceMetadataSidecar"`
+	// Gzip compress text-like blobs of the local storage.
+	LocalStorageCompression bool `json:"localStorageCompression"`
+	// Generate first-page thumbnails for PDF resources.
+	PDFThumbnail bool `json:"pdfThumbnail"`
+	// Generate poster frame thumbnails for video resources.
+	VideoThumbnail bool `json:"videoThumbnail"`
+	// Format of the generated thumbnails: original, jpeg, png, webp or avif, the latter two are encoded as jpeg when not available.
+	ThumbnailFormat string `json:"thumbnailFormat"`
+	// JPEG quality of the generated thumbnails, from 1 to 100.
+	ThumbnailJPEGQuality int `json:"thumbnailJpegQuality"`
+	// How scriptable resources like svg and html are served: attachment, plain or inline.
+	ActiveContentMode string `json:"activeContentMode"`
 }
 
 func (s *APIV1Service) registerSystemRoutes(g *echo.Group) {
@@ -82,8 +100,12 @@ func (s *APIV1Service) GetSystemStatus(c echo.Context) error {
 			Locale:     "en",
 			Appearance: "system",
 		},
-		StorageServiceID: DefaultStorage,
-		LocalStoragePath: "assets/{timestamp}_{filename}",
+		StorageServiceID:  DefaultStorage,
+		LocalStoragePath:  "assets/{timestamp}_{filename}",
+		ActiveContentMode: "attachment",
+		ThumbnailFormat:   "original",
+		// The default quality of the imaging package.
+		ThumbnailJPEGQuality: 95,
 	}
 
 	hostUserType := store.RoleHost
@@ -102,7 +124,11 @@ func (s *APIV1Service) GetSystemStatus(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find system setting list").SetInternal(err)
 	}
 	for _, systemSetting := range systemSettingList {
-		if systemSetting.Name == SystemSettingServerIDName.String() || systemSetting.Name == SystemSettingSecretSessionName.String() || systemSetting.Name == SystemSettingTelegramBotTokenName.String() || systemSetting.Name == SystemSettingInstanceURLName.String() {
+		if systemSetting.Name == SystemSettingServerIDName.String() || systemSetting.Name == SystemSettingSecretSessionName.String() || systemSetting.Name == SystemSettingTelegramBotTokenName.String() || systemSetting.Name == SystemSettingInstanceURLName.String() || systemSetting.Name == SystemSettingExternalLinkBlocklistName.String() ||
+			systemSetting.Name == SystemSettingAllowedUploadTypesName.String() || systemSetting.Name == SystemSettingDeniedUploadTypesName.String() ||
+			systemSetting.Name == SystemSettingDisableResourceHardeningHeadersName.String() || systemSetting.Name == SystemSettingUploadRateLimitName.String() ||
+			systemSetting.Name == SystemSettingStorageRoutingRulesName.String() || systemSetting.Name == SystemSettingAntivirusName.String() ||
+			systemSetting.Name == SystemSettingDownloadRateLimitName.String() {
 			continue
 		}
 
@@ -138,6 +164,24 @@ func (s *APIV1Service) GetSystemStatus(c echo.Context) error {
 			systemStatus.LocalStoragePath = baseValue.(string)
 		case SystemSettingMemoDisplayWithUpdatedTsName.String():
 			systemStatus.MemoDisplayWithUpdatedTs = baseValue.(bool)
+		case SystemSettingStripImageMetadataName.String():
+			systemStatus.StripImageMetadata = baseValue.(bool)
+		case SystemSettingAutoOrientImagesName.String():
+			systemStatus.AutoOrientImages = baseValue.(bool)
+		case SystemSettingResourceMetadataSidecarName.String():
+			systemStatus.ResourceMetadataSidecar = baseValue.(bool)
+		case SystemSettingLocalStorageCompressionName.String():
+			systemStatus.LocalStorageCompression = baseValue.(bool)
+		case SystemSettingPDFThumbnailName.String():
+			systemStatus.PDFThumbnail = baseValue.(bool)
+		case SystemSettingVideoThumbnailName.String():
+			systemStatus.VideoThumbnail = baseValue.(bool)
+		case SystemSettingThumbnailFormatName.String():
+			systemStatus.ThumbnailFormat = baseValue.(string)
+		case SystemSettingThumbnailJPEGQualityName.String():
+			systemStatus.ThumbnailJPEGQuality = int(baseValue.(float64))
+		case SystemSettingActiveContentModeName.String():
+			systemStatus.ActiveContentMode = baseValue.(string)
 		default:
 			log.Warn("Unknown system setting name", zap.String("setting name", systemSetting.Name))
 		}
@@ -2,13 +2,17 @@ package v1
 
 import (
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 
+	"github.com/usememos/memos/internal/util"
 	"github.com/usememos/memos/store"
 )
 
@@ -43,9 +47,72 @@ const (
 	SystemSettingMemoDisplayWithUpdatedTsName SystemSettingName = "memo-display-with-updated-ts"
 	// SystemSettingInstanceURLName is the name of instance url setting.
 	SystemSettingInstanceURLName SystemSettingName = "instance-url"
+	// SystemSettingStripImageMetadataName is the name of strip image metadata setting.
+	SystemSettingStripImageMetadataName SystemSettingName = "strip-image-metadata"
+	// SystemSettingAutoOrientImagesName is the name of the setting applying the EXIF orientation to uploaded images.
+	SystemSettingAutoOrientImagesName SystemSettingName = "auto-orient-images"
+	// SystemSettingResourceMetadataSidecarName is the name of the setting writing a store.ResourceSidecar next to uploaded blobs.
+	SystemSettingResourceMetadataSidecarName SystemSettingName = "resource-metadata-sidecar"
+	// SystemSettingLocalStorageCompressionName is the name of the setting gzip compressing compressible blobs of the local storage.
+	SystemSettingLocalStorageCompressionName SystemSettingName = "local-storage-compression"
+	// SystemSettingPDFThumbnailName is the name of pdf thumbnail generation setting.
+	SystemSettingPDFThumbnailName SystemSettingName = "pdf-thumbnail"
+	// SystemSettingVideoThumbnailName is the name of video thumbnail generation setting.
+	SystemSettingVideoThumbnailName SystemSettingName = "video-thumbnail"
+	// SystemSettingExternalLinkBlocklistName is the name of the address ranges the server must not fetch URLs from.
+	SystemSettingExternalLinkBlocklistName SystemSettingName = "external-link-blocklist"
+	// SystemSettingAllowedUploadTypesName is the name of the allowed upload mime types and extensions setting.
+	SystemSettingAllowedUploadTypesName SystemSettingName = "allowed-upload-types"
+	// SystemSettingDeniedUploadTypesName is the name of the denied upload mime types and extensions setting.
+	SystemSettingDeniedUploadTypesName SystemSettingName = "denied-upload-types"
+	// SystemSettingThumbnailFormatName is the name of the format generated thumbnails are encoded in.
+	SystemSettingThumbnailFormatName SystemSettingName = "thumbnail-format"
+	// SystemSettingThumbnailJPEGQualityName is the name of the jpeg quality of generated thumbnails, from 1 to 100, 95 by default.
+	SystemSettingThumbnailJPEGQualityName SystemSettingName = "thumbnail-jpeg-quality"
+	// SystemSettingActiveContentModeName is the name of the serving mode for scriptable resources like svg and html.
+	SystemSettingActiveContentModeName SystemSettingName = "active-content-mode"
+	// SystemSettingDisableResourceHardeningHeadersName is the name of the disable resource hardening headers setting.
+	SystemSettingDisableResourceHardeningHeadersName SystemSettingName = "disable-resource-hardening-headers"
+	// SystemSettingUploadRateLimitName is the name of the per user and per IP upload rate limit setting.
+	SystemSettingUploadRateLimitName SystemSettingName = "upload-rate-limit"
+	// SystemSettingAntivirusName is the name of the antivirus scanning uploads setting.
+	SystemSettingAntivirusName SystemSettingName = "antivirus"
+	// SystemSettingStorageRoutingRulesName is the name of the ordered storage routing rules setting.
+	SystemSettingStorageRoutingRulesName SystemSettingName = "storage-routing-rules"
+	// SystemSettingDownloadRateLimitName is the name of the download rate limit of a connection setting, in bytes per second.
+	SystemSettingDownloadRateLimitName SystemSettingName = "download-rate-limit"
 )
 const systemSettingUnmarshalError = `failed to unmarshal value from system setting "%v"`
 
+// UploadRateLimit is the struct definition for SystemSettingUploadRateLimitName system setting item.
+type UploadRateLimit struct {
+	// RequestsPerMinute is the sustained amount of uploads allowed per minute, 0 disables the limit.
+	RequestsPerMinute int `json:"requestsPerMinute"`
+	// Burst is the amount of uploads allowed at once, defaults to RequestsPerMinute.
+	Burst int `json:"burst"`
+}
+
+// Antivirus is the struct definition for SystemSettingAntivirusName system setting item.
+type Antivirus struct {
+	// Address is the host:port of the clamd daemon uploads are streamed to, empty disables scanning.
+	Address string `json:"address"`
+	// FailClosed rejects the uploads that can't be scanned, they are stored unscanned otherwise.
+	FailClosed bool `json:"failClosed"`
+}
+
+// StorageRoutingRule is the struct definition for the items of SystemSettingStorageRoutingRulesName system setting.
+// A rule matches a resource when all of its set conditions match.
+type StorageRoutingRule struct {
+	// MimeTypePrefix matches resources whose type starts with it, e.g. `video/`.
+	MimeTypePrefix string `json:"mimeTypePrefix"`
+	// MinSize matches resources of at least MinSize bytes.
+	MinSize int64 `json:"minSize"`
+	// MaxSize matches resources of at most MaxSize bytes, 0 means no upper bound.
+	MaxSize int64 `json:"maxSize"`
+	// StorageID is the storage service the blob is saved to, it accepts the same values as SystemSettingStorageServiceIDName.
+	StorageID int32 `json:"storageId"`
+}
+
 // CustomizedProfile is the struct definition for SystemSettingCustomizedProfileName system setting item.
 type CustomizedProfile struct {
 	// Name is the server name, default is `memos`
@@ -157,7 +224,7 @@ func (s *APIV1Service) CreateSystemSetting(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Malformatted post system setting request").SetInternal(err)
 	}
 	if err := systemSettingUpsert.Validate(); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid system setting").SetInternal(err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid system setting: %v", err)).SetInternal(err)
 	}
 	if systemSettingUpsert.Name == SystemSettingDisablePasswordLoginName {
 		var disablePasswordLogin bool
@@ -261,6 +328,9 @@ func (upsert UpsertSystemSettingRequest) Validate() error {
 		case !strings.Contains(trimmedValue, "{filename}"):
 			return errors.New("local storage path must contain `{filename}`")
 		}
+		if err := validatePathTemplate(trimmedValue); err != nil {
+			return errors.Wrap(err, "invalid local storage path")
+		}
 	case SystemSettingTelegramBotTokenName:
 		if upsert.Value == "" {
 			return nil
@@ -283,6 +353,100 @@ func (upsert UpsertSystemSettingRequest) Validate() error {
 			return errors.Errorf(systemSettingUnmarshalError, settingName)
 		}
 	case SystemSettingInstanceURLName:
+	case SystemSettingStripImageMetadataName, SystemSettingAutoOrientImagesName, SystemSettingResourceMetadataSidecarName, SystemSettingLocalStorageCompressionName:
+		var value bool
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+	case SystemSettingPDFThumbnailName, SystemSettingVideoThumbnailName:
+		var value bool
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+	case SystemSettingExternalLinkBlocklistName:
+		var value []string
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+		if _, err := util.ParseIPNets(value); err != nil {
+			return errors.Wrap(err, "invalid external link blocklist")
+		}
+	case SystemSettingAllowedUploadTypesName, SystemSettingDeniedUploadTypesName:
+		var value []string
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+	case SystemSettingThumbnailFormatName:
+		var value string
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+		if !slices.Contains([]string{"original", "jpeg", "png", "webp", "avif"}, value) {
+			return errors.New("thumbnail format must be one of original, jpeg, png, webp or avif")
+		}
+	case SystemSettingThumbnailJPEGQualityName:
+		var value int
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+		if value < 1 || value > 100 {
+			return errors.New("thumbnail jpeg quality must be between 1 and 100")
+		}
+	case SystemSettingActiveContentModeName:
+		var value string
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+		if value != "attachment" && value != "plain" && value != "inline" {
+			return errors.New("active content mode must be one of attachment, plain or inline")
+		}
+	case SystemSettingDisableResourceHardeningHeadersName:
+		var value bool
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+	case SystemSettingAntivirusName:
+		value := Antivirus{}
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+		if value.Address != "" {
+			if _, _, err := net.SplitHostPort(value.Address); err != nil {
+				return errors.Wrap(err, "invalid antivirus address")
+			}
+		}
+	case SystemSettingStorageRoutingRulesName:
+		value := []StorageRoutingRule{}
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+		for i, rule := range value {
+			if rule.MinSize < 0 || rule.MaxSize < 0 {
+				return errors.Errorf("storage routing rule %d: size must not be negative", i)
+			}
+			if rule.MaxSize > 0 && rule.MaxSize < rule.MinSize {
+				return errors.Errorf("storage routing rule %d: maxSize must not be less than minSize", i)
+			}
+			if rule.StorageID < LocalStorage {
+				return errors.Errorf("storage routing rule %d: invalid storage id %d", i, rule.StorageID)
+			}
+		}
+	case SystemSettingUploadRateLimitName:
+		value := UploadRateLimit{}
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+		if value.RequestsPerMinute < 0 || value.Burst < 0 {
+			return errors.New("upload rate limit must not be negative")
+		}
+	case SystemSettingDownloadRateLimitName:
+		var value int64
+		if err := json.Unmarshal([]byte(upsert.Value), &value); err != nil {
+			return errors.Errorf(systemSettingUnmarshalError, settingName)
+		}
+		if value < 0 {
+			return errors.New("download rate limit must not be negative")
+		}
 	default:
 		return errors.New("invalid system setting name")
 	}
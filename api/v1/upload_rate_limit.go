@@ -0,0 +1,131 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	"github.com/usememos/memos/internal/log"
+)
+
+const (
+	// uploadRateLimitCleanupInterval is how often idle buckets are dropped.
+	uploadRateLimitCleanupInterval = time.Minute
+	// uploadRateLimitIdleTimeout is how long a bucket is kept without requests.
+	uploadRateLimitIdleTimeout = 3 * time.Minute
+)
+
+// uploadRateLimiter keeps in-memory token buckets of the upload requests per key.
+type uploadRateLimiter struct {
+	mutex       sync.Mutex
+	buckets     map[string]*uploadRateLimitBucket
+	lastCleanup time.Time
+	timeNow     func() time.Time
+}
+
+type uploadRateLimitBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newUploadRateLimiter() *uploadRateLimiter {
+	return &uploadRateLimiter{
+		buckets:     map[string]*uploadRateLimitBucket{},
+		lastCleanup: time.Now(),
+		timeNow:     time.Now,
+	}
+}
+
+// allow takes a token from the bucket of every key, at the given limit.
+// When any bucket is empty no token is taken and the time to wait for the next token is returned.
+func (l *uploadRateLimiter) allow(limit UploadRateLimit, keys ...string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.timeNow()
+	if now.Sub(l.lastCleanup) > uploadRateLimitCleanupInterval {
+		for key, bucket := range l.buckets {
+			if now.Sub(bucket.lastSeen) > uploadRateLimitIdleTimeout {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	ratePerSecond := rate.Limit(float64(limit.RequestsPerMinute) / 60)
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.RequestsPerMinute
+	}
+	reservations := []*rate.Reservation{}
+	for _, key := range keys {
+		bucket, ok := l.buckets[key]
+		if !ok {
+			bucket = &uploadRateLimitBucket{
+				limiter: rate.NewLimiter(ratePerSecond, burst),
+			}
+			l.buckets[key] = bucket
+		}
+		// The limit may have been changed in the workspace settings.
+		if bucket.limiter.Limit() != ratePerSecond {
+			bucket.limiter.SetLimitAt(now, ratePerSecond)
+		}
+		if bucket.limiter.Burst() != burst {
+			bucket.limiter.SetBurstAt(now, burst)
+		}
+		bucket.lastSeen = now
+
+		reservation := bucket.limiter.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			for _, previous := range reservations {
+				previous.CancelAt(now)
+			}
+			return false, delay
+		}
+		reservations = append(reservations, reservation)
+	}
+	return true, 0
+}
+
+// getUploadRateLimit returns the upload rate limit of the workspace, a zero limit means uploads are not limited.
+func (s *APIV1Service) getUploadRateLimit(ctx context.Context) UploadRateLimit {
+	limit := UploadRateLimit{}
+	uploadRateLimitSetting := s.Store.GetWorkspaceSettingWithDefaultValue(ctx, SystemSettingUploadRateLimitName.String(), "")
+	if uploadRateLimitSetting != "" {
+		if err := json.Unmarshal([]byte(uploadRateLimitSetting), &limit); err != nil {
+			log.Warn("Failed to parse upload rate limit", zap.Error(err))
+			return UploadRateLimit{}
+		}
+	}
+	return limit
+}
+
+// checkUploadRateLimit limits the uploads of the current user and of the client IP.
+// The returned error is an echo.HTTPError with status 429 when the limit is exceeded.
+func (s *APIV1Service) checkUploadRateLimit(c echo.Context) error {
+	limit := s.getUploadRateLimit(c.Request().Context())
+	if limit.RequestsPerMinute <= 0 {
+		return nil
+	}
+
+	keys := []string{"ip:" + c.RealIP()}
+	if userID, ok := c.Get(userIDContextKey).(int32); ok {
+		keys = append(keys, fmt.Sprintf("user:%d", userID))
+	}
+	if ok, delay := s.uploadRateLimiter.allow(limit, keys...); !ok {
+		retryAfter := int(math.Ceil(delay.Seconds()))
+		c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return echo.NewHTTPError(http.StatusTooManyRequests, "Too many uploads, please retry later")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usememos/memos/store"
+	teststore "github.com/usememos/memos/test/store"
+)
+
+func TestUploadRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := newUploadRateLimiter()
+	limiter.timeNow = func() time.Time {
+		return now
+	}
+	limit := UploadRateLimit{RequestsPerMinute: 60, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		ok, _ := limiter.allow(limit, "ip:127.0.0.1", "user:1")
+		require.True(t, ok)
+	}
+	ok, delay := limiter.allow(limit, "ip:127.0.0.1", "user:1")
+	require.False(t, ok)
+	require.Equal(t, time.Second, delay)
+
+	// Other users behind the same IP are limited as well, other clients are not.
+	ok, _ = limiter.allow(limit, "ip:127.0.0.1", "user:2")
+	require.False(t, ok)
+	ok, _ = limiter.allow(limit, "ip:10.0.0.1", "user:2")
+	require.True(t, ok)
+
+	now = now.Add(time.Second)
+	ok, _ = limiter.allow(limit, "ip:127.0.0.1", "user:1")
+	require.True(t, ok)
+
+	// Idle buckets are cleaned up.
+	now = now.Add(uploadRateLimitIdleTimeout + time.Second)
+	ok, _ = limiter.allow(limit, "ip:10.0.0.2")
+	require.True(t, ok)
+	require.Len(t, limiter.buckets, 1)
+}
+
+func TestCheckUploadRateLimit(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s := NewAPIV1Service("", ts.Profile, ts, nil)
+
+	e := echo.New()
+	newContext := func() (echo.Context, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodPost, "/api/v1/resource/blob", nil), rec)
+		c.Set(userIDContextKey, int32(1))
+		return c, rec
+	}
+
+	// Uploads aren't limited by default.
+	for i := 0; i < 10; i++ {
+		c, _ := newContext()
+		require.NoError(t, s.checkUploadRateLimit(c))
+	}
+
+	_, err := ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{
+		Name:  SystemSettingUploadRateLimitName.String(),
+		Value: `{"requestsPerMinute":2,"burst":3}`,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		c, _ := newContext()
+		require.NoError(t, s.checkUploadRateLimit(c))
+	}
+	c, rec := newContext()
+	err = s.checkUploadRateLimit(c)
+	require.Error(t, err)
+	httpErr, ok := err.(*echo.HTTPError)
+	require.True(t, ok)
+	require.Equal(t, http.StatusTooManyRequests, httpErr.Code)
+	require.Equal(t, "30", rec.Header().Get(echo.HeaderRetryAfter))
+}
@@ -0,0 +1,50 @@
+package v1
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// uploadSizeExceededError is returned when an upload is larger than the max upload size.
+type uploadSizeExceededError struct {
+	limit int64
+}
+
+func (e *uploadSizeExceededError) Error() string {
+	return fmt.Sprintf("file size exceeds allowed limit of %d MiB", e.limit/MebiByte)
+}
+
+// sizeLimitReader fails once more than limit bytes are read, so the storages never complete an oversize blob
+// whatever size the client declared.
+type sizeLimitReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func newSizeLimitReader(r io.Reader, limit int64) *sizeLimitReader {
+	// One more byte than the limit is read to detect the overflow.
+	return &sizeLimitReader{r: io.LimitReader(r, limit+1), limit: limit}
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	if r.read > r.limit {
+		return n - int(r.read-r.limit), &uploadSizeExceededError{limit: r.limit}
+	}
+	return n, err
+}
+
+// convertUploadSizeError returns an echo.HTTPError with status 400 when the upload exceeds the max upload size.
+func convertUploadSizeError(err error) *echo.HTTPError {
+	sizeErr := &uploadSizeExceededError{}
+	if errors.As(err, &sizeErr) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("File size exceeds allowed limit of %d MiB", sizeErr.limit/MebiByte)).SetInternal(err)
+	}
+	return nil
+}
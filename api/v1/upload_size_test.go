@@ -0,0 +1,65 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lithammer/shortuuid/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usememos/memos/store"
+	teststore "github.com/usememos/memos/test/store"
+)
+
+func TestSaveResourceBlobMaxUploadSize(t *testing.T) {
+	for _, storageID := range []int32{DatabaseStorage, LocalStorage} {
+		ctx := context.Background()
+		ts := teststore.NewTestingStore(ctx, t)
+		s := NewAPIV1Service("", ts.Profile, ts, nil)
+		value, err := json.Marshal(storageID)
+		require.NoError(t, err)
+		_, err = ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: SystemSettingStorageServiceIDName.String(), Value: string(value)})
+		require.NoError(t, err)
+		_, err = ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: SystemSettingMaxUploadSizeMiBName.String(), Value: "1"})
+		require.NoError(t, err)
+
+		// The declared size doesn't match the actual content.
+		create := &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     "test.bin",
+			Type:         "application/octet-stream",
+			Size:         16,
+		}
+		err = s.SaveResourceBlob(ctx, create, bytes.NewReader(make([]byte, MebiByte+1)))
+		if err == nil {
+			_, err = ts.CreateResource(ctx, create)
+		}
+		require.Error(t, err)
+		httpErr := convertUploadSizeError(err)
+		require.NotNil(t, httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code)
+
+		// Nothing of the oversize upload is left behind.
+		resources, err := ts.ListResources(ctx, &store.FindResource{})
+		require.NoError(t, err)
+		require.Empty(t, resources)
+		entries, err := os.ReadDir(filepath.Join(ts.Profile.Data, "assets"))
+		if !os.IsNotExist(err) {
+			require.NoError(t, err)
+		}
+		require.Empty(t, entries)
+
+		// An upload of exactly the max size is stored.
+		create.ResourceName = shortuuid.New()
+		require.NoError(t, s.SaveResourceBlob(ctx, create, bytes.NewReader(make([]byte, MebiByte))))
+		_, err = ts.CreateResource(ctx, create)
+		require.NoError(t, err)
+		ts.Close()
+	}
+}
@@ -0,0 +1,48 @@
+package v1
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// withUploadTimeout bounds the upload request by the timeout configured in the profile.
+// The returned context replaces the one of the request, it's not bounded when no timeout is set.
+func (s *APIV1Service) withUploadTimeout(c echo.Context) (context.Context, context.CancelFunc) {
+	if s.Profile.UploadTimeout <= 0 {
+		return context.WithCancel(c.Request().Context())
+	}
+	deadline := time.Now().Add(s.Profile.UploadTimeout)
+	// Reading the request body doesn't follow the context, bound the connection as well.
+	// Writers which don't support deadlines, like recorders in tests, are only bounded by the context.
+	_ = http.NewResponseController(c.Response().Writer).SetReadDeadline(deadline)
+	ctx, cancel := context.WithDeadline(c.Request().Context(), deadline)
+	c.SetRequest(c.Request().WithContext(ctx))
+	return ctx, cancel
+}
+
+// convertUploadTimeoutError returns an echo.HTTPError with status 408 when the upload failed because it timed out.
+func convertUploadTimeoutError(ctx context.Context, err error) *echo.HTTPError {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return echo.NewHTTPError(http.StatusRequestTimeout, "Upload timed out").SetInternal(err)
+	}
+	return nil
+}
+
+// contextReader stops reading once its context is done, so a copy to a storage which doesn't follow the context is bounded too.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
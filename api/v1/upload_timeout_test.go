@@ -0,0 +1,104 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lithammer/shortuuid/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usememos/memos/store"
+	teststore "github.com/usememos/memos/test/store"
+)
+
+// slowReader returns a few bytes per read after a delay, like a stalled client or storage.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.r.Read(p[:min(len(p), 4)])
+}
+
+func TestUploadResourceTimeout(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	profile := *ts.Profile
+	profile.UploadTimeout = 50 * time.Millisecond
+	s := NewAPIV1Service("", &profile, ts, nil)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "test.txt")
+	require.NoError(t, err)
+	_, err = part.Write([]byte(strings.Repeat("hello", 20)))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/resource/blob", &slowReader{r: body, delay: 5 * time.Millisecond})
+	request.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	c := echo.New().NewContext(request, httptest.NewRecorder())
+	c.Set(userIDContextKey, int32(101))
+	err = s.UploadResource(c)
+	httpErr := &echo.HTTPError{}
+	require.ErrorAs(t, err, &httpErr)
+	require.Equal(t, http.StatusRequestTimeout, httpErr.Code)
+
+	resources, err := ts.ListResources(ctx, &store.FindResource{})
+	require.NoError(t, err)
+	require.Empty(t, resources)
+}
+
+func TestSaveResourceBlobTimeout(t *testing.T) {
+	for _, storageID := range []int32{DatabaseStorage, LocalStorage} {
+		ctx := context.Background()
+		ts := teststore.NewTestingStore(ctx, t)
+		s := NewAPIV1Service("", ts.Profile, ts, nil)
+		value, err := json.Marshal(storageID)
+		require.NoError(t, err)
+		_, err = ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: SystemSettingStorageServiceIDName.String(), Value: string(value)})
+		require.NoError(t, err)
+
+		content := strings.Repeat("hello", 20)
+		create := &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     "test.txt",
+			Type:         "text/plain",
+			Size:         int64(len(content)),
+		}
+		uploadCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		err = s.SaveResourceBlob(uploadCtx, create, &slowReader{r: strings.NewReader(content), delay: 5 * time.Millisecond})
+		if err == nil {
+			_, err = ts.CreateResource(uploadCtx, create)
+		}
+		cancel()
+		require.Error(t, err)
+		require.NotNil(t, convertUploadTimeoutError(uploadCtx, err))
+
+		// Nothing of the partial upload is left behind.
+		resources, err := ts.ListResources(ctx, &store.FindResource{})
+		require.NoError(t, err)
+		require.Empty(t, resources)
+		entries, err := os.ReadDir(filepath.Join(ts.Profile.Data, "assets"))
+		if !os.IsNotExist(err) {
+			require.NoError(t, err)
+		}
+		require.Empty(t, entries)
+		ts.Close()
+	}
+}
@@ -1,6 +1,7 @@
 package v1
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -19,6 +20,10 @@ type APIV1Service struct {
 	Profile     *profile.Profile
 	Store       *store.Store
 	telegramBot *telegram.Bot
+
+	uploadRateLimiter  *uploadRateLimiter
+	localUploadLimiter *localUploadLimiter
+	resourceService    *resource.ResourceService
 }
 
 // @title						memos API
@@ -41,6 +46,10 @@ func NewAPIV1Service(secret string, profile *profile.Profile, store *store.Store
 		Profile:     profile,
 		Store:       store,
 		telegramBot: telegramBot,
+
+		uploadRateLimiter:  newUploadRateLimiter(),
+		localUploadLimiter: newLocalUploadLimiter(profile.LocalUploadConcurrency, profile.LocalUploadBufferSize),
+		resourceService:    resource.NewResourceService(profile, store),
 	}
 }
 
@@ -76,6 +85,7 @@ func (s *APIV1Service) Register(rootGroup *echo.Group) {
 	s.registerMemoRoutes(apiV1Group)
 	s.registerMemoOrganizerRoutes(apiV1Group)
 	s.registerMemoRelationRoutes(apiV1Group)
+	s.registerMemoResourceRoutes(apiV1Group)
 
 	// Register public routes.
 	publicGroup := rootGroup.Group("/o")
@@ -85,7 +95,7 @@ func (s *APIV1Service) Register(rootGroup *echo.Group) {
 	s.registerGetterPublicRoutes(publicGroup)
 
 	// Create and register resource public routes.
-	resource.NewResourceService(s.Profile, s.Store).RegisterRoutes(publicGroup)
+	s.resourceService.RegisterRoutes(publicGroup)
 
 	// Create and register rss public routes.
 	rss.NewRSSService(s.Profile, s.Store).RegisterRoutes(rootGroup)
@@ -93,3 +103,11 @@ func (s *APIV1Service) Register(rootGroup *echo.Group) {
 	// programmatically set API version same as the server version
 	SwaggerInfo.Version = s.Profile.Version
 }
+
+// Shutdown waits for the in-flight thumbnail generations and local uploads to finish, until ctx is done.
+func (s *APIV1Service) Shutdown(ctx context.Context) error {
+	if err := s.resourceService.Shutdown(ctx); err != nil {
+		return err
+	}
+	return s.localUploadLimiter.wait(ctx)
+}
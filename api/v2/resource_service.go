@@ -6,10 +6,12 @@ import (
 	"time"
 
 	"github.com/lithammer/shortuuid/v4"
+	"github.com/pkg/errors"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	apiv1 "github.com/usememos/memos/api/v1"
 	apiv2pb "github.com/usememos/memos/proto/gen/api/v2"
 	"github.com/usememos/memos/server/service/metric"
 	"github.com/usememos/memos/store"
@@ -30,6 +32,13 @@ func (s *APIV2Service) CreateResource(ctx context.Context, request *apiv2pb.Crea
 			return nil, status.Errorf(codes.InvalidArgument, "invalid external link scheme: %v", linkURL.Scheme)
 		}
 	}
+	if err := apiv1.CheckUploadType(ctx, s.Store, request.Filename, request.Type); err != nil {
+		typeErr := &apiv1.UploadTypeNotAllowedError{}
+		if errors.As(err, &typeErr) {
+			return nil, status.Errorf(codes.InvalidArgument, "file type %s is not allowed", typeErr.Type)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get upload type settings: %v", err)
+	}
 
 	create := &store.Resource{
 		ResourceName: shortuuid.New(),
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -43,6 +44,17 @@ var (
 	dsn          string
 	enableMetric bool
 
+	enablePrometheus       bool
+	enableStorageLog       bool
+	defaultStorage         string
+	uploadBufferSize       int64
+	uploadTimeout          time.Duration
+	localUploadConcurrency int
+	localUploadBufferSize  int
+	abortUploadsAfter      time.Duration
+	thumbnailConcurrency   int
+	trustedProxies         string
+
 	rootCmd = &cobra.Command{
 		Use:   "memos",
 		Short: `An open-source, self-hosted memo hub with knowledge management and social networking.`,
@@ -95,6 +107,12 @@ var (
 
 			// update (pre-sign) object storage links if applicable
 			go jobs.RunPreSignLinks(ctx, storeInstance)
+			// delete resources whose expiry has passed
+			go jobs.RunDeleteExpiredResources(ctx, storeInstance)
+			// hash the content of resources stored before hashes were recorded
+			go jobs.RunBackfillResourceSha256(ctx, storeInstance)
+			// abort stale multipart uploads of object storages
+			go jobs.RunAbortIncompleteUploads(ctx, storeInstance, profile.AbortIncompleteUploadsAfter)
 
 			if err := s.Start(ctx); err != nil {
 				if err != http.ErrServerClosed {
@@ -124,6 +142,16 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&driver, "driver", "", "", "database driver")
 	rootCmd.PersistentFlags().StringVarP(&dsn, "dsn", "", "", "database source name(aka. DSN)")
 	rootCmd.PersistentFlags().BoolVarP(&enableMetric, "metric", "", true, "allow metric collection")
+	rootCmd.PersistentFlags().BoolVarP(&enablePrometheus, "prometheus", "", false, "expose Prometheus metrics at /metrics")
+	rootCmd.PersistentFlags().BoolVarP(&enableStorageLog, "storage-log", "", false, "log every storage operation with its key")
+	rootCmd.PersistentFlags().StringVarP(&defaultStorage, "default-storage", "", "", "storage used when the workspace has none set: database, local or a storage ID")
+	rootCmd.PersistentFlags().Int64VarP(&uploadBufferSize, "upload-buffer-size", "", 32<<20, "bytes of an upload kept in memory, the rest is spilled to temp files (1 MiB to 1 GiB)")
+	rootCmd.PersistentFlags().DurationVarP(&uploadTimeout, "upload-timeout", "", 0, "maximum duration of a resource upload, reading the request included, 0 means unlimited")
+	rootCmd.PersistentFlags().IntVarP(&localUploadConcurrency, "local-upload-concurrency", "", 0, "maximum amount of uploads written to the local storage at the same time, 0 means unlimited")
+	rootCmd.PersistentFlags().IntVarP(&localUploadBufferSize, "local-upload-buffer-size", "", 32*1024, "size in bytes of the buffer used to write uploads to the local storage")
+	rootCmd.PersistentFlags().DurationVarP(&abortUploadsAfter, "abort-incomplete-uploads-after", "", 24*time.Hour, "age after which incomplete S3 multipart uploads are aborted, 0 disables it")
+	rootCmd.PersistentFlags().IntVarP(&thumbnailConcurrency, "thumbnail-concurrency", "", 32, "maximum amount of thumbnails generated at the same time")
+	rootCmd.PersistentFlags().StringVarP(&trustedProxies, "trusted-proxies", "", "", "comma separated CIDRs of the reverse proxies whose X-Forwarded-For header is trusted, any client's forwarded headers are trusted when empty")
 
 	err := viper.BindPFlag("mode", rootCmd.PersistentFlags().Lookup("mode"))
 	if err != nil {
@@ -153,12 +181,59 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	err = viper.BindPFlag("prometheus", rootCmd.PersistentFlags().Lookup("prometheus"))
+	if err != nil {
+		panic(err)
+	}
+	err = viper.BindPFlag("storage_log", rootCmd.PersistentFlags().Lookup("storage-log"))
+	if err != nil {
+		panic(err)
+	}
+	err = viper.BindPFlag("default_storage", rootCmd.PersistentFlags().Lookup("default-storage"))
+	if err != nil {
+		panic(err)
+	}
+	err = viper.BindPFlag("upload_buffer_size", rootCmd.PersistentFlags().Lookup("upload-buffer-size"))
+	if err != nil {
+		panic(err)
+	}
+	err = viper.BindPFlag("upload_timeout", rootCmd.PersistentFlags().Lookup("upload-timeout"))
+	if err != nil {
+		panic(err)
+	}
+	err = viper.BindPFlag("local_upload_concurrency", rootCmd.PersistentFlags().Lookup("local-upload-concurrency"))
+	if err != nil {
+		panic(err)
+	}
+	err = viper.BindPFlag("local_upload_buffer_size", rootCmd.PersistentFlags().Lookup("local-upload-buffer-size"))
+	if err != nil {
+		panic(err)
+	}
+	err = viper.BindPFlag("abort_incomplete_uploads_after", rootCmd.PersistentFlags().Lookup("abort-incomplete-uploads-after"))
+	if err != nil {
+		panic(err)
+	}
+	err = viper.BindPFlag("thumbnail_concurrency", rootCmd.PersistentFlags().Lookup("thumbnail-concurrency"))
+	if err != nil {
+		panic(err)
+	}
+	err = viper.BindPFlag("trusted_proxies", rootCmd.PersistentFlags().Lookup("trusted-proxies"))
+	if err != nil {
+		panic(err)
+	}
 
 	viper.SetDefault("mode", "demo")
 	viper.SetDefault("driver", "sqlite")
 	viper.SetDefault("addr", "")
 	viper.SetDefault("port", 8081)
 	viper.SetDefault("metric", true)
+	viper.SetDefault("prometheus", false)
+	viper.SetDefault("storage_log", false)
+	viper.SetDefault("upload_buffer_size", 32<<20)
+	viper.SetDefault("upload_timeout", 0)
+	viper.SetDefault("local_upload_buffer_size", 32*1024)
+	viper.SetDefault("abort_incomplete_uploads_after", 24*time.Hour)
+	viper.SetDefault("thumbnail_concurrency", 32)
 	viper.SetEnvPrefix("memos")
 }
 
@@ -181,6 +256,16 @@ func initConfig() {
 	println("driver:", profile.Driver)
 	println("version:", profile.Version)
 	println("metric:", profile.Metric)
+	println("prometheus:", profile.Prometheus)
+	println("storage log:", profile.StorageLog)
+	println("default storage:", profile.DefaultStorage)
+	println("upload buffer size:", profile.UploadBufferSize)
+	println("upload timeout:", profile.UploadTimeout.String())
+	println("local upload concurrency:", profile.LocalUploadConcurrency)
+	println("local upload buffer size:", profile.LocalUploadBufferSize)
+	println("abort incomplete uploads after:", profile.AbortIncompleteUploadsAfter.String())
+	println("thumbnail concurrency:", profile.ThumbnailConcurrency)
+	println("trusted proxies:", profile.TrustedProxies)
 	println("---")
 }
 
@@ -0,0 +1,76 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	apiv1 "github.com/usememos/memos/api/v1"
+	"github.com/usememos/memos/internal/log"
+	"github.com/usememos/memos/store"
+)
+
+// incompleteUploadCleanupInterval is how often incomplete multipart uploads are looked for.
+const incompleteUploadCleanupInterval = time.Hour
+
+// RunAbortIncompleteUploads is a background job that aborts the multipart uploads of S3 storages
+// left incomplete for longer than maxAge, so that their parts aren't billed forever.
+// Only keys under the static prefix of the storage path are considered.
+func RunAbortIncompleteUploads(ctx context.Context, dataStore *store.Store, maxAge time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+	for {
+		aborted, err := abortIncompleteUploads(ctx, dataStore, maxAge)
+		if err != nil {
+			log.Warn("failed abort incomplete multipart uploads", zap.Error(err))
+		} else if aborted > 0 {
+			log.Info("incomplete multipart uploads aborted", zap.Int("count", aborted))
+		}
+		select {
+		case <-time.After(incompleteUploadCleanupInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func abortIncompleteUploads(ctx context.Context, dataStore *store.Store, maxAge time.Duration) (int, error) {
+	storages, err := dataStore.ListStorages(ctx, &store.FindStorage{})
+	if err != nil {
+		return 0, errors.Wrap(err, "list storages")
+	}
+
+	var aborted int
+	initiatedBefore := time.Now().Add(-maxAge)
+	for _, storage := range storages {
+		storageMessage, err := apiv1.ConvertStorageFromStore(storage)
+		if err != nil {
+			return aborted, errors.Wrapf(err, "convert storage %d", storage.ID)
+		}
+		if storageMessage.Type != apiv1.StorageS3 || storageMessage.Config.S3Config == nil {
+			continue
+		}
+
+		s3Config := storageMessage.Config.S3Config
+		prefix := apiv1.PathTemplatePrefix(s3Config.Path)
+		if prefix == "" {
+			// the bucket may be shared - never touch uploads outside of a known prefix
+			log.Debug("skip storage without static path prefix", zap.Int32("storage", storage.ID))
+			continue
+		}
+		client, err := newS3Client(ctx, s3Config)
+		if err != nil {
+			log.Warn("failed create s3 client", zap.Int32("storage", storage.ID), zap.Error(err))
+			continue
+		}
+		count, err := client.AbortIncompleteUploads(ctx, prefix, initiatedBefore)
+		aborted += count
+		if err != nil {
+			log.Warn("failed abort incomplete multipart uploads", zap.Int32("storage", storage.ID), zap.Error(err))
+		}
+	}
+	return aborted, nil
+}
@@ -126,7 +126,10 @@ func findObjectStorage(ctx context.Context, dataStore *store.Store) (*s3.Client,
 		return nil, nil
 	}
 
-	s3Config := storageMessage.Config.S3Config
+	return newS3Client(ctx, storageMessage.Config.S3Config)
+}
+
+func newS3Client(ctx context.Context, s3Config *apiv1.StorageS3Config) (*s3.Client, error) {
 	return s3.NewClient(ctx, &s3.Config{
 		AccessKey: s3Config.AccessKey,
 		SecretKey: s3Config.SecretKey,
@@ -0,0 +1,67 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/usememos/memos/internal/log"
+	"github.com/usememos/memos/store"
+)
+
+// resourceExpiryInterval is how often expired resources are deleted.
+const resourceExpiryInterval = 10 * time.Minute
+
+// RunDeleteExpiredResources is a background job that deletes resources whose expiry has passed,
+// including their local files and thumbnails.
+func RunDeleteExpiredResources(ctx context.Context, dataStore *store.Store) {
+	for {
+		deleted, err := deleteExpiredResources(ctx, dataStore)
+		if err != nil {
+			log.Warn("failed delete expired resources", zap.Error(err))
+		} else if deleted > 0 {
+			log.Info("expired resources deleted", zap.Int("count", deleted))
+		}
+		select {
+		case <-time.After(resourceExpiryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func deleteExpiredResources(ctx context.Context, dataStore *store.Store) (int, error) {
+	const pageSize = 32
+
+	now := time.Now().Unix()
+	var deleted int
+	// Deleted resources drop out of the result, so the offset only skips the ones failed to delete.
+	var offset int
+	var limit = pageSize
+	for {
+		resources, err := dataStore.ListResources(ctx, &store.FindResource{
+			ExpiresBefore: &now,
+			Limit:         &limit,
+			Offset:        &offset,
+		})
+		if err != nil {
+			return deleted, errors.Wrapf(err, "list expired resources, offset %d", offset)
+		}
+
+		for _, res := range resources {
+			if err := dataStore.DeleteResource(ctx, &store.DeleteResource{ID: res.ID}); err != nil {
+				log.Warn("failed delete expired resource", zap.Int32("resource", res.ID), zap.Error(err))
+				offset++
+				continue
+			}
+			deleted++
+		}
+
+		if len(resources) < limit {
+			break
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/usememos/memos/internal/log"
+	"github.com/usememos/memos/store"
+)
+
+// RunBackfillResourceSha256 is a one-time background job that hashes the content of the resources
+// stored before hashes were recorded. Once every resource has a hash it finds nothing to do.
+func RunBackfillResourceSha256(ctx context.Context, dataStore *store.Store) {
+	hashed, err := dataStore.BackfillResourceSha256(ctx)
+	if err != nil {
+		log.Warn("failed to back-fill resource hashes", zap.Error(err))
+	}
+	if hashed > 0 {
+		log.Info("resource hashes back-filled", zap.Int("count", hashed))
+	}
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"mime"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultExternalLinkBlocklist contains the address ranges the server must not fetch URLs from:
+// loopback, private, carrier-grade NAT, link-local (including cloud metadata services) and unspecified addresses.
+var DefaultExternalLinkBlocklist = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// ErrExternalLinkBlocked is returned when a URL fetched by the server resolves to a blocked address.
+var ErrExternalLinkBlocked = errors.New("external link points to a blocked address")
+
+// ParseIPNets parses the list of CIDR notations.
+func ParseIPNets(cidrs []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid CIDR %q", cidr)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+// IsBlockedIP returns true if the ip is contained in any of the blocked networks.
+func IsBlockedIP(ip net.IP, blocklist []*net.IPNet) bool {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+	}
+	for _, ipNet := range blocklist {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// preferredExtensions are the extensions of the common types which have several, mime.ExtensionsByType sorts them alphabetically.
+var preferredExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/tiff":      ".tiff",
+	"text/plain":      ".txt",
+	"text/html":       ".html",
+	"video/mpeg":      ".mpeg",
+	"audio/mpeg":      ".mp3",
+	"application/xml": ".xml",
+}
+
+// DefaultLinkFilename returns the filename of a resource without one: the last segment of the link path,
+// or else "download" with the extension of the mime type.
+func DefaultLinkFilename(link, mimeType string) string {
+	if linkURL, err := url.Parse(link); err == nil {
+		if name := path.Base(linkURL.Path); name != "." && name != "/" && name != "" {
+			return name
+		}
+	}
+	filename := "download"
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return filename
+	}
+	mediaType = strings.ToLower(mediaType)
+	if extension, ok := preferredExtensions[mediaType]; ok {
+		return filename + extension
+	}
+	if extensions, err := mime.ExtensionsByType(mediaType); err == nil && len(extensions) > 0 {
+		return filename + extensions[0]
+	}
+	return filename
+}
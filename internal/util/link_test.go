@@ -0,0 +1,54 @@
+package util
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsBlockedIP(t *testing.T) {
+	blocklist, err := ParseIPNets(DefaultExternalLinkBlocklist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{ip: "8.8.8.8"},
+		{ip: "2001:4860:4860::8888"},
+		{ip: "127.0.0.1", blocked: true},
+		{ip: "169.254.169.254", blocked: true},
+		{ip: "192.168.1.1", blocked: true},
+		{ip: "::1", blocked: true},
+		{ip: "::ffff:10.0.0.1", blocked: true},
+		{ip: "0.0.0.0", blocked: true},
+	}
+	for _, test := range tests {
+		if blocked := IsBlockedIP(net.ParseIP(test.ip), blocklist); blocked != test.blocked {
+			t.Errorf("IsBlockedIP %s: got blocked %v, want blocked %v.", test.ip, blocked, test.blocked)
+		}
+	}
+	if IsBlockedIP(net.ParseIP("127.0.0.1"), nil) {
+		t.Errorf("IsBlockedIP with empty blocklist: got blocked, want not blocked.")
+	}
+}
+
+func TestDefaultLinkFilename(t *testing.T) {
+	tests := []struct {
+		link     string
+		mimeType string
+		want     string
+	}{
+		{link: "https://example.com/files/report.pdf", mimeType: "application/pdf", want: "report.pdf"},
+		{link: "https://example.com/files/photo%20one.jpg?size=large", want: "photo one.jpg"},
+		{link: "https://example.com/", mimeType: "image/jpeg", want: "download.jpg"},
+		{link: "https://example.com", mimeType: "image/png; charset=binary", want: "download.png"},
+		{link: "", mimeType: "application/x-unknown", want: "download"},
+		{link: "", mimeType: "", want: "download"},
+	}
+	for _, test := range tests {
+		if got := DefaultLinkFilename(test.link, test.mimeType); got != test.want {
+			t.Errorf("DefaultLinkFilename(%q, %q) = %q, want %q", test.link, test.mimeType, got, test.want)
+		}
+	}
+}
@@ -37,6 +37,20 @@ func ValidateEmail(email string) bool {
 	return true
 }
 
+// ValidateHeaderValue reports whether the value is safe to send as an HTTP header value.
+// Only printable ASCII and tabs are accepted, so the value can't split the response.
+func ValidateHeaderValue(value string, maxLength int) bool {
+	if value == "" || len(value) > maxLength {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < 0x20 && c != '\t') || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
 func GenUUID() string {
 	return uuid.New().String()
 }
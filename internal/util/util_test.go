@@ -1,6 +1,7 @@
 package util
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -29,3 +30,45 @@ func TestValidateEmail(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateHeaderValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{
+			value: "public, max-age=31536000, immutable",
+			want:  true,
+		},
+		{
+			value: "no-store",
+			want:  true,
+		},
+		{
+			value: "",
+			want:  false,
+		},
+		{
+			value: "no-store\r\nSet-Cookie: a=b",
+			want:  false,
+		},
+		{
+			value: "max-age=60\n",
+			want:  false,
+		},
+		{
+			value: "max-age=60, é",
+			want:  false,
+		},
+		{
+			value: strings.Repeat("a", 257),
+			want:  false,
+		},
+	}
+	for _, test := range tests {
+		result := ValidateHeaderValue(test.value, 256)
+		if result != test.want {
+			t.Errorf("Validate header value %q: got result %v, want %v.", test.value, result, test.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package clamav scans streams with a ClamAV daemon over the INSTREAM protocol.
+// It doesn't use github.com/dutchcoders/go-clamd: that client is unmaintained, has no module release,
+// and its ScanStream can't be cancelled with a context or bounded by timeouts, which an upload scan needs.
+package clamav
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultTimeout is the default time allowed for each network operation with clamd.
+	DefaultTimeout = 30 * time.Second
+	// chunkSize is the size of the chunks the stream is sent to clamd in.
+	chunkSize = 64 * 1024
+)
+
+// Client scans streams with a clamd daemon over TCP.
+type Client struct {
+	// Address is the host:port clamd listens on.
+	Address string
+	// Timeout is the time allowed for each network operation, DefaultTimeout when 0.
+	Timeout time.Duration
+}
+
+// Result is the verdict of clamd on a stream.
+type Result struct {
+	Infected bool
+	// Signature is the name of the threat found in an infected stream.
+	Signature string
+}
+
+// ScanStream sends the content of r to clamd with the INSTREAM command and returns its verdict.
+// Streams over the StreamMaxLength of clamd fail with an error.
+func (c *Client) ScanStream(ctx context.Context, r io.Reader) (*Result, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to clamd")
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	write := func(b []byte) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+		_, err := conn.Write(b)
+		return err
+	}
+	if err := write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, errors.Wrap(err, "failed to send the scan command")
+	}
+	chunk := make([]byte, 4+chunkSize)
+	for {
+		n, err := r.Read(chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk[:4], uint32(n))
+			if err := write(chunk[:4+n]); err != nil {
+				return nil, errors.Wrap(err, "failed to send the stream")
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the stream")
+		}
+	}
+	// A zero length chunk ends the stream.
+	if err := write([]byte{0, 0, 0, 0}); err != nil {
+		return nil, errors.Wrap(err, "failed to end the stream")
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(err == io.EOF && reply != "") {
+		return nil, errors.Wrap(err, "failed to read the scan result")
+	}
+	return parseReply(reply)
+}
+
+// parseReply parses the reply of clamd to a scan, like `stream: OK` or `stream: Eicar-Signature FOUND`.
+func parseReply(reply string) (*Result, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	_, status, ok := strings.Cut(reply, ": ")
+	if !ok {
+		return nil, errors.Errorf("unexpected scan result: %s", reply)
+	}
+	switch {
+	case status == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return &Result{
+			Infected:  true,
+			Signature: strings.TrimSuffix(status, " FOUND"),
+		}, nil
+	default:
+		return nil, errors.Errorf("failed to scan the stream: %s", status)
+	}
+}
@@ -0,0 +1,86 @@
+package clamav
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serveFakeClamd answers the INSTREAM scans on listener, streams containing EICAR are infected.
+func serveFakeClamd(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			command := make([]byte, len("zINSTREAM\x00"))
+			if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+				_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+				return
+			}
+			content := &bytes.Buffer{}
+			for {
+				var length uint32
+				if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+					return
+				}
+				if length == 0 {
+					break
+				}
+				if _, err := io.CopyN(content, conn, int64(length)); err != nil {
+					return
+				}
+			}
+			if strings.Contains(content.String(), "EICAR") {
+				_, _ = conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				return
+			}
+			_, _ = conn.Write([]byte("stream: OK\x00"))
+		}()
+	}
+}
+
+func TestScanStream(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveFakeClamd(listener)
+	client := &Client{Address: listener.Addr().String()}
+	ctx := context.Background()
+
+	result, err := client.ScanStream(ctx, strings.NewReader("hello"))
+	require.NoError(t, err)
+	require.False(t, result.Infected)
+
+	// Large enough to be sent in several chunks.
+	infected := strings.Repeat("a", 3*chunkSize) + "EICAR"
+	result, err = client.ScanStream(ctx, strings.NewReader(infected))
+	require.NoError(t, err)
+	require.True(t, result.Infected)
+	require.Equal(t, "Eicar-Test-Signature", result.Signature)
+
+	listener.Close()
+	_, err = client.ScanStream(ctx, strings.NewReader("hello"))
+	require.Error(t, err)
+}
+
+func TestParseReply(t *testing.T) {
+	result, err := parseReply("stream: OK\x00")
+	require.NoError(t, err)
+	require.Equal(t, &Result{}, result)
+	result, err = parseReply("stream: Win.Test.EICAR_HDB-1 FOUND\x00")
+	require.NoError(t, err)
+	require.Equal(t, &Result{Infected: true, Signature: "Win.Test.EICAR_HDB-1"}, result)
+	_, err = parseReply("INSTREAM size limit exceeded. ERROR\x00")
+	require.Error(t, err)
+	_, err = parseReply("stream: Can't allocate memory ERROR")
+	require.Error(t, err)
+}
@@ -0,0 +1,42 @@
+package getter
+
+import (
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/usememos/memos/internal/util"
+)
+
+// newClient returns an HTTP client which refuses to connect to the blocked addresses.
+// The address is checked on every connection after DNS resolution, so neither redirects nor rebinding reach them.
+func newClient(blocklist []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return errors.Errorf("invalid address %s", address)
+			}
+			if util.IsBlockedIP(ip, blocklist) {
+				return errors.Wrapf(util.ErrExternalLinkBlocked, "address %s", ip)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would connect to the blocked addresses on behalf of the client.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Minute,
+	}
+}
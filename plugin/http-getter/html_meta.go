@@ -3,7 +3,7 @@ package getter
 import (
 	"errors"
 	"io"
-	"net/http"
+	"net"
 	"net/url"
 
 	"golang.org/x/net/html"
@@ -16,12 +16,12 @@ type HTMLMeta struct {
 	Image       string `json:"image"`
 }
 
-func GetHTMLMeta(urlStr string) (*HTMLMeta, error) {
+func GetHTMLMeta(urlStr string, blocklist []*net.IPNet) (*HTMLMeta, error) {
 	if _, err := url.Parse(urlStr); err != nil {
 		return nil, err
 	}
 
-	response, err := http.Get(urlStr)
+	response, err := newClient(blocklist).Get(urlStr)
 	if err != nil {
 		return nil, err
 	}
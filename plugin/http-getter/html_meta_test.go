@@ -12,7 +12,7 @@ func TestGetHTMLMeta(t *testing.T) {
 		htmlMeta HTMLMeta
 	}{}
 	for _, test := range tests {
-		metadata, err := GetHTMLMeta(test.urlStr)
+		metadata, err := GetHTMLMeta(test.urlStr, nil)
 		require.NoError(t, err)
 		require.Equal(t, test.htmlMeta, *metadata)
 	}
@@ -3,7 +3,7 @@ package getter
 import (
 	"errors"
 	"io"
-	"net/http"
+	"net"
 	"net/url"
 	"strings"
 
@@ -15,12 +15,12 @@ type Image struct {
 	Mediatype string
 }
 
-func GetImage(urlStr string) (*Image, error) {
+func GetImage(urlStr string, blocklist []*net.IPNet) (*Image, error) {
 	if _, err := url.Parse(urlStr); err != nil {
 		return nil, err
 	}
 
-	response, err := http.Get(urlStr)
+	response, err := newClient(blocklist).Get(urlStr)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,34 @@
+package getter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usememos/memos/internal/util"
+)
+
+func TestGetImageBlocklist(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/image.png", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("image"))
+	}))
+	defer server.Close()
+	blocklist, err := util.ParseIPNets(util.DefaultExternalLinkBlocklist)
+	require.NoError(t, err)
+
+	image, err := GetImage(server.URL+"/redirect", nil)
+	require.NoError(t, err)
+	require.Equal(t, "image/png", image.Mediatype)
+
+	// The test server listens on a loopback address.
+	_, err = GetImage(server.URL+"/image.png", blocklist)
+	require.True(t, errors.Is(err, util.ErrExternalLinkBlocked), "%v", err)
+}
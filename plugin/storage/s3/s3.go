@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,6 +20,9 @@ import (
 
 const LinkLifetime = 24 * time.Hour
 
+// MaxObjectTags is the maximum amount of tags of an object.
+const MaxObjectTags = 10
+
 type Config struct {
 	AccessKey string
 	SecretKey string
@@ -28,6 +32,14 @@ type Config struct {
 	URLPrefix string
 	URLSuffix string
 	PreSign   bool
+	// PartSize is the size in bytes of multipart upload parts, the uploader default is used when 0.
+	PartSize int64
+	// Concurrency is the amount of parts uploaded in parallel, the uploader default is used when 0.
+	Concurrency int
+	// LeavePartsOnError keeps the uploaded parts of a failed multipart upload instead of aborting it.
+	LeavePartsOnError bool
+	// ObjectTags are the tags set on every uploaded object, e.g. to match lifecycle rules.
+	ObjectTags map[string]string
 }
 
 type Client struct {
@@ -69,13 +81,21 @@ func NewClient(ctx context.Context, config *Config) (*Client, error) {
 }
 
 func (client *Client) UploadFile(ctx context.Context, filename string, fileType string, src io.Reader) (string, error) {
-	uploader := manager.NewUploader(client.Client)
+	return client.UploadFileWithTags(ctx, filename, fileType, src, nil)
+}
+
+// UploadFileWithTags uploads the object like UploadFile, with the given tags on top of the configured ones.
+func (client *Client) UploadFileWithTags(ctx context.Context, filename string, fileType string, src io.Reader, tags map[string]string) (string, error) {
+	uploader := client.newUploader()
 	putInput := awss3.PutObjectInput{
 		Bucket:      aws.String(client.Config.Bucket),
 		Key:         aws.String(filename),
 		Body:        src,
 		ContentType: aws.String(fileType),
 	}
+	if tagging := encodeTagging(client.Config.ObjectTags, tags); tagging != "" {
+		putInput.Tagging = aws.String(tagging)
+	}
 	// Set ACL according to if url prefix is set.
 	if client.Config.URLPrefix == "" {
 		putInput.ACL = types.ObjectCannedACL(*aws.String("public-read"))
@@ -103,6 +123,103 @@ func (client *Client) UploadFile(ctx context.Context, filename string, fileType
 	return link, nil
 }
 
+// encodeTagging returns the tags URL-encoded as the tagging of an object, the later tags override the earlier ones.
+func encodeTagging(tagSets ...map[string]string) string {
+	merged := map[string]string{}
+	for _, tags := range tagSets {
+		for key, value := range tags {
+			merged[key] = value
+		}
+	}
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, escapeTag(key)+"="+escapeTag(merged[key]))
+	}
+	return strings.Join(pairs, "&")
+}
+
+// escapeTag escapes s as a query parameter, with spaces as %20 instead of the + S3 could keep as-is.
+func escapeTag(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// newUploader creates a multipart uploader with the configured options.
+func (client *Client) newUploader() *manager.Uploader {
+	return manager.NewUploader(client.Client, func(uploader *manager.Uploader) {
+		if client.Config.PartSize > 0 {
+			uploader.PartSize = client.Config.PartSize
+		}
+		if client.Config.Concurrency > 0 {
+			uploader.Concurrency = client.Config.Concurrency
+		}
+		uploader.LeavePartsOnError = client.Config.LeavePartsOnError
+	})
+}
+
+// GetFile returns the content of the object with the given key, the caller must close it.
+func (client *Client) GetFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	output, err := client.Client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(client.Config.Bucket),
+		Key:    aws.String(filename),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// DeleteFile deletes the object with the given key.
+func (client *Client) DeleteFile(ctx context.Context, filename string) error {
+	_, err := client.Client.DeleteObject(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(client.Config.Bucket),
+		Key:    aws.String(filename),
+	})
+	return err
+}
+
+// AbortIncompleteUploads aborts the multipart uploads of keys under prefix initiated before the given time.
+// The prefix is required so that uploads of other applications sharing the bucket are left alone.
+func (client *Client) AbortIncompleteUploads(ctx context.Context, prefix string, initiatedBefore time.Time) (int, error) {
+	if prefix == "" {
+		return 0, errors.New("prefix is required")
+	}
+
+	aborted := 0
+	input := &awss3.ListMultipartUploadsInput{
+		Bucket: aws.String(client.Config.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	for {
+		output, err := client.Client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return aborted, errors.Wrap(err, "failed to list multipart uploads")
+		}
+		for _, upload := range output.Uploads {
+			if upload.Initiated == nil || !upload.Initiated.Before(initiatedBefore) {
+				continue
+			}
+			if _, err := client.Client.AbortMultipartUpload(ctx, &awss3.AbortMultipartUploadInput{
+				Bucket:   aws.String(client.Config.Bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			}); err != nil {
+				return aborted, errors.Wrapf(err, "failed to abort multipart upload of %s", aws.ToString(upload.Key))
+			}
+			aborted++
+		}
+		if !aws.ToBool(output.IsTruncated) {
+			return aborted, nil
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.UploadIdMarker = output.NextUploadIdMarker
+	}
+}
+
 // PreSignLink generates a pre-signed URL for the given sourceLink.
 // If the link does not belong to the configured storage endpoint, it is returned as-is.
 // If the link belongs to the storage, the function generates a pre-signed URL using the AWS S3 client.
@@ -0,0 +1,46 @@
+package s3
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUploader(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewClient(ctx, &Config{
+		EndPoint:    "http://localhost:9000",
+		Region:      "us-east-1",
+		Bucket:      "memos",
+		PartSize:    16 * 1024 * 1024,
+		Concurrency: 2,
+	})
+	require.NoError(t, err)
+	uploader := client.newUploader()
+	require.Equal(t, int64(16*1024*1024), uploader.PartSize)
+	require.Equal(t, 2, uploader.Concurrency)
+	require.False(t, uploader.LeavePartsOnError)
+
+	client, err = NewClient(ctx, &Config{
+		EndPoint:          "http://localhost:9000",
+		Region:            "us-east-1",
+		Bucket:            "memos",
+		LeavePartsOnError: true,
+	})
+	require.NoError(t, err)
+	uploader = client.newUploader()
+	require.Equal(t, int64(manager.DefaultUploadPartSize), uploader.PartSize)
+	require.Equal(t, manager.DefaultUploadConcurrency, uploader.Concurrency)
+	require.True(t, uploader.LeavePartsOnError)
+}
+
+func TestEncodeTagging(t *testing.T) {
+	require.Equal(t, "", encodeTagging(nil, nil))
+	require.Equal(t, "tier=cold", encodeTagging(map[string]string{"tier": "cold"}, nil))
+	require.Equal(t, "category=image&tier=hot",
+		encodeTagging(map[string]string{"tier": "cold", "category": "video"}, map[string]string{"tier": "hot", "category": "image"}))
+	require.Equal(t, "a%26b=c%3Dd&owner=Jane%20Doe&path=x%2Fy%2Bz",
+		encodeTagging(map[string]string{"owner": "Jane Doe", "a&b": "c=d", "path": "x/y+z"}))
+}
@@ -18,13 +18,19 @@ import (
 )
 
 type TelegramHandler struct {
-	store *store.Store
+	store        *store.Store
+	apiV1Service *apiv1.APIV1Service
 }
 
 func NewTelegramHandler(store *store.Store) *TelegramHandler {
 	return &TelegramHandler{store: store}
 }
 
+// SetAPIV1Service sets the service saving the attachments, which is created with the bot of the handler.
+func (t *TelegramHandler) SetAPIV1Service(apiV1Service *apiv1.APIV1Service) {
+	t.apiV1Service = apiV1Service
+}
+
 func (t *TelegramHandler) BotToken(ctx context.Context) string {
 	return t.store.GetWorkspaceSettingWithDefaultValue(ctx, apiv1.SystemSettingTelegramBotTokenName.String(), "")
 }
@@ -94,7 +100,7 @@ func (t *TelegramHandler) MessageHandle(ctx context.Context, bot *telegram.Bot,
 			MemoID:       &memoMessage.ID,
 		}
 
-		err := apiv1.SaveResourceBlob(ctx, t.store, &create, bytes.NewReader(attachment.Data))
+		err := t.apiV1Service.SaveResourceBlob(ctx, &create, bytes.NewReader(attachment.Data))
 		if err != nil {
 			_, err := bot.EditMessage(ctx, message.Chat.ID, reply.MessageID, fmt.Sprintf("Failed to SaveResourceBlob: %s", err), nil)
 			return err
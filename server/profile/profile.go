@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
@@ -32,6 +33,27 @@ type Profile struct {
 	Version string `json:"version"`
 	// Metric indicate the metric collection is enabled or not
 	Metric bool `json:"-"`
+	// Prometheus indicates the Prometheus metrics are exposed at /metrics
+	Prometheus bool `json:"-"`
+	// StorageLog indicates every storage operation is logged
+	StorageLog bool `json:"-" mapstructure:"storage_log"`
+	// DefaultStorage seeds the storage service setting when the workspace has none: database, local or a storage ID
+	DefaultStorage string `json:"-" mapstructure:"default_storage"`
+	// LocalUploadConcurrency is the maximum amount of uploads written to the local storage at the same time, 0 means unlimited
+	LocalUploadConcurrency int `json:"-" mapstructure:"local_upload_concurrency"`
+	// UploadBufferSize is the amount in bytes of an upload kept in memory, the rest is spilled to temp files
+	UploadBufferSize int64 `json:"-" mapstructure:"upload_buffer_size"`
+	// UploadTimeout bounds the whole upload of a resource, reading the request included, 0 means unlimited
+	UploadTimeout time.Duration `json:"-" mapstructure:"upload_timeout"`
+	// LocalUploadBufferSize is the size in bytes of the buffer used to write uploads to the local storage
+	LocalUploadBufferSize int `json:"-" mapstructure:"local_upload_buffer_size"`
+	// AbortIncompleteUploadsAfter is the age after which incomplete S3 multipart uploads are aborted, 0 disables it
+	AbortIncompleteUploadsAfter time.Duration `json:"-" mapstructure:"abort_incomplete_uploads_after"`
+	// ThumbnailConcurrency is the maximum amount of thumbnails generated at the same time
+	ThumbnailConcurrency int `json:"-" mapstructure:"thumbnail_concurrency"`
+	// TrustedProxies is the comma separated CIDRs of the reverse proxies whose X-Forwarded-For header is trusted,
+	// the forwarded headers of any client are trusted when empty
+	TrustedProxies string `json:"-" mapstructure:"trusted_proxies"`
 }
 
 func (p *Profile) IsDev() bool {
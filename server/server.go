@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 
 	apiv1 "github.com/usememos/memos/api/v1"
 	apiv2 "github.com/usememos/memos/api/v2"
+	"github.com/usememos/memos/internal/util"
 	"github.com/usememos/memos/plugin/telegram"
 	"github.com/usememos/memos/server/frontend"
 	"github.com/usememos/memos/server/integration"
@@ -31,6 +33,8 @@ type Server struct {
 	Profile *profile.Profile
 	Store   *store.Store
 
+	apiV1Service *apiv1.APIV1Service
+
 	// Asynchronous runners.
 	telegramBot *telegram.Bot
 }
@@ -41,13 +45,22 @@ func NewServer(ctx context.Context, profile *profile.Profile, store *store.Store
 	e.HideBanner = true
 	e.HidePort = true
 
+	ipExtractor, err := newIPExtractor(profile.TrustedProxies)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse trusted proxies")
+	}
+	if ipExtractor != nil {
+		e.IPExtractor = ipExtractor
+	}
+
+	telegramHandler := integration.NewTelegramHandler(store)
 	s := &Server{
 		e:       e,
 		Store:   store,
 		Profile: profile,
 
 		// Asynchronous runners.
-		telegramBot: telegram.NewBotWithHandler(integration.NewTelegramHandler(store)),
+		telegramBot: telegram.NewBotWithHandler(telegramHandler),
 	}
 
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
@@ -73,6 +86,15 @@ func NewServer(ctx context.Context, profile *profile.Profile, store *store.Store
 	}
 	s.ID = serverID
 
+	if err := s.seedDefaultStorage(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to seed default storage")
+	}
+
+	// Uploads interrupted by a previous shutdown leave their temp files behind.
+	if _, err := apiv1.RemoveLocalUploadTempFiles(ctx, store); err != nil {
+		fmt.Printf("failed to remove local upload temp files, error: %v\n", err)
+	}
+
 	// Register frontend service.
 	frontendService := frontend.NewFrontendService(profile, store)
 	frontendService.Serve(ctx, e)
@@ -91,10 +113,21 @@ func NewServer(ctx context.Context, profile *profile.Profile, store *store.Store
 		return c.String(http.StatusOK, "Service ready.")
 	})
 
+	// Register Prometheus metrics endpoint.
+	if profile.Prometheus {
+		metric.EnablePrometheus()
+		e.GET("/metrics", echo.WrapHandler(metric.PrometheusHandler()))
+	}
+
+	if profile.StorageLog {
+		metric.EnableStorageLog()
+	}
+
 	// Register API v1 endpoints.
 	rootGroup := e.Group("")
-	apiV1Service := apiv1.NewAPIV1Service(s.Secret, profile, store, s.telegramBot)
-	apiV1Service.Register(rootGroup)
+	s.apiV1Service = apiv1.NewAPIV1Service(s.Secret, profile, store, s.telegramBot)
+	telegramHandler.SetAPIV1Service(s.apiV1Service)
+	s.apiV1Service.Register(rootGroup)
 
 	apiV2Service := apiv2.NewAPIV2Service(s.Secret, profile, store, s.Profile.Port+1)
 	// Register gRPC gateway as api v2.
@@ -122,6 +155,16 @@ func (s *Server) Shutdown(ctx context.Context) {
 		fmt.Printf("failed to shutdown server, error: %v\n", err)
 	}
 
+	// Wait for the thumbnails and uploads still being written
+	if err := s.apiV1Service.Shutdown(ctx); err != nil {
+		fmt.Printf("failed to wait for in-flight resource operations, error: %v\n", err)
+	}
+
+	// Let the resource observers finish while the database is still open
+	if err := s.Store.WaitResourceObservers(ctx); err != nil {
+		fmt.Printf("failed to wait for resource observers, error: %v\n", err)
+	}
+
 	// Close database connection
 	if err := s.Store.Close(); err != nil {
 		fmt.Printf("failed to close database, error: %v\n", err)
@@ -172,6 +215,56 @@ func (s *Server) getSystemSecretSessionName(ctx context.Context) (string, error)
 	return secretSessionNameValue.Value, nil
 }
 
+// seedDefaultStorage sets the storage service from the profile when the workspace has none.
+// A storage service set by an admin is never overridden.
+func (s *Server) seedDefaultStorage(ctx context.Context) error {
+	if s.Profile.DefaultStorage == "" {
+		return nil
+	}
+	storageServiceID, err := apiv1.ParseStorageServiceID(s.Profile.DefaultStorage)
+	if err != nil {
+		return err
+	}
+	storageServiceIDSetting, err := s.Store.GetWorkspaceSetting(ctx, &store.FindWorkspaceSetting{
+		Name: apiv1.SystemSettingStorageServiceIDName.String(),
+	})
+	if err != nil {
+		return err
+	}
+	if storageServiceIDSetting != nil && storageServiceIDSetting.Value != "" {
+		return nil
+	}
+	_, err = s.Store.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{
+		Name:  apiv1.SystemSettingStorageServiceIDName.String(),
+		Value: strconv.Itoa(int(storageServiceID)),
+	})
+	return err
+}
+
+// newIPExtractor returns how the client IP is found, from the X-Forwarded-For header set by the trusted proxies.
+// Without trusted proxies it returns nil, keeping echo's default of honouring the X-Forwarded-For and X-Real-IP headers,
+// which deployments behind a reverse proxy rely on for the per-IP rate limits.
+func newIPExtractor(trustedProxies string) (echo.IPExtractor, error) {
+	cidrs := []string{}
+	for _, cidr := range strings.Split(trustedProxies, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	if len(cidrs) == 0 {
+		return nil, nil
+	}
+	ipNets, err := util.ParseIPNets(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, ipNet := range ipNets {
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
 func grpcRequestSkipper(c echo.Context) bool {
 	return strings.HasPrefix(c.Request().URL.Path, "/memos.api.v2.")
 }
@@ -182,5 +275,40 @@ func timeoutSkipper(c echo.Context) bool {
 	}
 
 	// Skip timeout for blob upload which is frequently timed out.
-	return c.Request().Method == http.MethodPost && c.Request().URL.Path == "/api/v1/resource/blob"
+	if c.Request().Method == http.MethodPost && c.Request().URL.Path == "/api/v1/resource/blob" {
+		return true
+	}
+	if c.Request().Method == http.MethodPut && strings.HasPrefix(c.Request().URL.Path, "/api/v1/resource/") && strings.HasSuffix(c.Request().URL.Path, "/blob") {
+		return true
+	}
+
+	// Skip timeout for resource downloads, which are streamed and may be throttled.
+	// The timeout handler buffers the whole response in memory and hides the sendfile of the connection.
+	if isResourceStreamRequest(c.Request()) {
+		return true
+	}
+
+	// Skip timeout for moving a resource which copies its whole content.
+	if c.Request().Method == http.MethodPost && strings.HasPrefix(c.Request().URL.Path, "/api/v1/resource/") && strings.HasSuffix(c.Request().URL.Path, "/move") {
+		return true
+	}
+
+	// Skip timeout for resource verification which reads every resource.
+	if c.Request().Method == http.MethodPost && c.Request().URL.Path == "/api/v1/resource/verify" {
+		return true
+	}
+
+	// Skip timeout for memo resources archive which is streamed and may take long.
+	return c.Request().Method == http.MethodGet && strings.HasSuffix(c.Request().URL.Path, "/resources.zip")
+}
+
+// isResourceStreamRequest reports whether the request downloads the content of a resource.
+func isResourceStreamRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if strings.HasPrefix(r.URL.Path, "/o/r/") {
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/api/v1/resource/") && strings.HasSuffix(r.URL.Path, "/blob")
 }
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lithammer/shortuuid/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usememos/memos/store"
+	teststore "github.com/usememos/memos/test/store"
+)
+
+// sendfileRecorder is a recorder whose connection supports io.ReaderFrom, like the one of net/http.
+type sendfileRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom       bool
+	firstWriteTime time.Time
+}
+
+func (r *sendfileRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	// Hide ReadFrom so the copy goes through Write.
+	return io.Copy(struct{ io.Writer }{r}, src)
+}
+
+func (r *sendfileRecorder) Write(p []byte) (int, error) {
+	if r.firstWriteTime.IsZero() {
+		r.firstWriteTime = time.Now()
+	}
+	return r.ResponseRecorder.Write(p)
+}
+
+func TestResourceStreamSkipsTimeout(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s, err := NewServer(ctx, ts.Profile, ts)
+	require.NoError(t, err)
+
+	localPath := filepath.Join(t.TempDir(), "notes.txt")
+	content := strings.Repeat("streamed ", 100)
+	require.NoError(t, os.WriteFile(localPath, []byte(content), 0644))
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "notes.txt",
+		InternalPath: localPath,
+		Type:         "text/plain",
+		Size:         int64(len(content)),
+	})
+	require.NoError(t, err)
+
+	// The timeout middleware would buffer the response, hiding the ReadFrom of the connection.
+	rec := &sendfileRecorder{ResponseRecorder: httptest.NewRecorder()}
+	s.e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/o/r/%s", resource.ResourceName), nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, content, rec.Body.String())
+	require.True(t, rec.readFrom)
+}
+
+func TestThrottledResourceStream(t *testing.T) {
+	ctx := context.Background()
+	ts := teststore.NewTestingStore(ctx, t)
+	defer ts.Close()
+	s, err := NewServer(ctx, ts.Profile, ts)
+	require.NoError(t, err)
+	bytesPerSecond := 8000
+	_, err = ts.UpsertWorkspaceSetting(ctx, &store.WorkspaceSetting{Name: "download-rate-limit", Value: strconv.Itoa(bytesPerSecond)})
+	require.NoError(t, err)
+
+	content := strings.Repeat("0123456789", 2*bytesPerSecond/10)
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "clip.mp4",
+		Blob:         []byte(content),
+		Type:         "video/mp4",
+		Size:         int64(len(content)),
+	})
+	require.NoError(t, err)
+
+	rec := &sendfileRecorder{ResponseRecorder: httptest.NewRecorder()}
+	startTime := time.Now()
+	s.e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/o/r/%s", resource.ResourceName), nil))
+	elapsed := time.Since(startTime)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, content, rec.Body.String())
+	require.GreaterOrEqual(t, elapsed, 900*time.Millisecond)
+	// The bytes are sent to the client while paced, not buffered until the end.
+	require.Less(t, rec.firstWriteTime.Sub(startTime), elapsed/2)
+}
+
+func TestTimeoutSkipper(t *testing.T) {
+	for _, test := range []struct {
+		method string
+		path   string
+		skip   bool
+	}{
+		{method: http.MethodGet, path: "/o/r/abc", skip: true},
+		{method: http.MethodHead, path: "/o/r/abc/notes.txt", skip: true},
+		{method: http.MethodGet, path: "/api/v1/resource/1/blob", skip: true},
+		{method: http.MethodHead, path: "/api/v1/resource/1/blob", skip: true},
+		{method: http.MethodGet, path: "/api/v1/resource/1"},
+		{method: http.MethodDelete, path: "/api/v1/resource/1/blob"},
+	} {
+		c := echo.New().NewContext(httptest.NewRequest(test.method, test.path, nil), httptest.NewRecorder())
+		require.Equal(t, test.skip, timeoutSkipper(c), "%s %s", test.method, test.path)
+	}
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		trustedProxies string
+		remoteAddr     string
+		want           string
+	}{
+		// Without trusted proxies the forwarded headers are honoured, like echo does by default.
+		{remoteAddr: "203.0.113.7:1234", want: "198.51.100.1"},
+		// Otherwise clients can't choose their IP by sending forwarded headers.
+		{trustedProxies: "10.0.0.0/8", remoteAddr: "203.0.113.7:1234", want: "203.0.113.7"},
+		// The headers of a trusted proxy are honoured.
+		{trustedProxies: "192.168.0.0/16, 10.0.0.0/8", remoteAddr: "10.0.0.2:1234", want: "198.51.100.1"},
+	} {
+		// Every server listens on the gRPC port of its profile.
+		ts := teststore.NewTestingStore(ctx, t)
+		ts.Profile.TrustedProxies = test.trustedProxies
+		s, err := NewServer(ctx, ts.Profile, ts)
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/resource/blob", nil)
+		request.RemoteAddr = test.remoteAddr
+		request.Header.Set(echo.HeaderXForwardedFor, "198.51.100.1")
+		request.Header.Set(echo.HeaderXRealIP, "198.51.100.2")
+		c := s.e.NewContext(request, httptest.NewRecorder())
+		require.Equal(t, test.want, c.RealIP(), "%s from %s", test.trustedProxies, test.remoteAddr)
+		ts.Close()
+	}
+
+	_, err := newIPExtractor("not a cidr")
+	require.Error(t, err)
+}
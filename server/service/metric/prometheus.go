@@ -0,0 +1,147 @@
+package metric
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+
+	"github.com/usememos/memos/internal/log"
+)
+
+var (
+	prometheusEnabled atomic.Bool
+	storageLogEnabled atomic.Bool
+	registry          = prometheus.NewRegistry()
+
+	storageOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "memos",
+		Name:      "storage_operation_duration_seconds",
+		Help:      "Duration of resource storage operations.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"operation", "provider", "result"})
+	storageOperationBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "memos",
+		Name:      "storage_operation_bytes_total",
+		Help:      "Bytes transferred by resource storage operations.",
+	}, []string{"operation", "provider"})
+)
+
+func init() {
+	registry.MustRegister(storageOperationDuration, storageOperationBytes)
+}
+
+// EnablePrometheus turns on the collection of the Prometheus metrics.
+// Until it is called, observing operations only costs an atomic load.
+func EnablePrometheus() {
+	prometheusEnabled.Store(true)
+}
+
+// EnableStorageLog turns on the logging of every storage operation.
+// The operations are logged at info level with a storage field, so other debug logs stay off.
+func EnableStorageLog() {
+	storageLogEnabled.Store(true)
+}
+
+// PrometheusHandler serves the collected metrics in the Prometheus exposition format.
+func PrometheusHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveStorageOperation records an operation of the storage provider on key started at start.
+// The key is only logged, it must never contain credentials.
+func ObserveStorageOperation(operation, provider, key string, start time.Time, size int64, err error) {
+	if storageLogEnabled.Load() {
+		log.Info("storage operation",
+			zap.String("storage", provider),
+			zap.String("operation", operation),
+			zap.String("key", key),
+			zap.Int64("size", size),
+			zap.Duration("duration", time.Since(start)),
+			zap.Error(err),
+		)
+	}
+	if !prometheusEnabled.Load() {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	storageOperationDuration.WithLabelValues(operation, provider, result).Observe(time.Since(start).Seconds())
+	if size > 0 {
+		storageOperationBytes.WithLabelValues(operation, provider).Add(float64(size))
+	}
+}
+
+// ObserveStorageReader wraps the reader to record the operation when it is closed.
+// The reader is returned as-is when nothing is recorded, the wrapper stays seekable when the reader is.
+func ObserveStorageReader(operation, provider, key string, start time.Time, reader io.ReadCloser) io.ReadCloser {
+	if !prometheusEnabled.Load() && !storageLogEnabled.Load() {
+		return reader
+	}
+	observed := &observedReader{
+		ReadCloser: reader,
+		operation:  operation,
+		provider:   provider,
+		key:        key,
+		start:      start,
+	}
+	if seeker, ok := reader.(io.Seeker); ok {
+		return &observedReadSeeker{observedReader: observed, seeker: seeker}
+	}
+	return observed
+}
+
+type observedReader struct {
+	io.ReadCloser
+	operation string
+	provider  string
+	key       string
+	start     time.Time
+	size      int64
+	err       error
+}
+
+func (r *observedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.Record(int64(n), err)
+	return n, err
+}
+
+// Unwrap returns the observed reader, so it can be copied without the wrapper, like a file with sendfile.
+// What is read from it directly is recorded with Record.
+func (r *observedReader) Unwrap() io.Reader {
+	return r.ReadCloser
+}
+
+// Record adds size bytes read from the observed reader, failed with err unless it is nil or io.EOF.
+func (r *observedReader) Record(size int64, err error) {
+	r.size += size
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+}
+
+func (r *observedReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.err == nil {
+		r.err = err
+	}
+	ObserveStorageOperation(r.operation, r.provider, r.key, r.start, r.size, r.err)
+	return err
+}
+
+// observedReadSeeker is a seekable observedReader, so byte ranges of observed files are still served.
+type observedReadSeeker struct {
+	*observedReader
+	seeker io.Seeker
+}
+
+func (r *observedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
@@ -1,5 +1,7 @@
 package store
 
+import "strings"
+
 // RowStatus is the status for a row.
 type RowStatus string
 
@@ -13,3 +15,11 @@ const (
 func (r RowStatus) String() string {
 	return string(r)
 }
+
+// likePatternEscaper escapes the wildcards of LIKE patterns, with backslash as the escape character.
+var likePatternEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// EscapeLikePattern escapes s so it's matched literally by a LIKE pattern with an ESCAPE '\' clause.
+func EscapeLikePattern(s string) string {
+	return likePatternEscaper.Replace(s)
+}
@@ -74,7 +74,20 @@ CREATE TABLE `resource` (
   `type` VARCHAR(256) NOT NULL DEFAULT '',
   `size` INT NOT NULL DEFAULT '0',
   `internal_path` VARCHAR(256) NOT NULL DEFAULT '',
-  `memo_id` INT DEFAULT NULL
+  `memo_id` INT DEFAULT NULL,
+  `expires_ts` BIGINT NOT NULL DEFAULT 0,
+  `cache_control` VARCHAR(256) DEFAULT NULL,
+  `original_ts` BIGINT DEFAULT NULL,
+  `sha256` VARCHAR(64) NOT NULL DEFAULT '',
+  `compression` VARCHAR(16) NOT NULL DEFAULT ''
+);
+
+-- resource_blob_chunk
+CREATE TABLE `resource_blob_chunk` (
+  `resource_id` INT NOT NULL,
+  `seq` INT NOT NULL,
+  `data` MEDIUMBLOB NOT NULL,
+  UNIQUE(`resource_id`,`seq`)
 );
 
 -- tag
@@ -0,0 +1,6 @@
+CREATE TABLE `resource_blob_chunk` (
+  `resource_id` INT NOT NULL,
+  `seq` INT NOT NULL,
+  `data` MEDIUMBLOB NOT NULL,
+  UNIQUE(`resource_id`,`seq`)
+);
@@ -0,0 +1 @@
+ALTER TABLE `resource` ADD COLUMN `cache_control` VARCHAR(256) DEFAULT NULL;
@@ -0,0 +1 @@
+ALTER TABLE `resource` ADD COLUMN `sha256` VARCHAR(64) NOT NULL DEFAULT '';
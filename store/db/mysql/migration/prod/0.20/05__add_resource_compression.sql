@@ -0,0 +1 @@
+ALTER TABLE `resource` ADD COLUMN `compression` VARCHAR(16) NOT NULL DEFAULT '';
@@ -60,6 +60,9 @@ func (d *DB) Vacuum(ctx context.Context) error {
 	if err := vacuumResource(ctx, tx); err != nil {
 		return err
 	}
+	if err := vacuumResourceBlobChunk(ctx, tx); err != nil {
+		return err
+	}
 	if err := vacuumUserSetting(ctx, tx); err != nil {
 		return err
 	}
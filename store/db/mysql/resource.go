@@ -6,13 +6,15 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/pkg/errors"
+
 	"github.com/usememos/memos/store"
 )
 
 func (d *DB) CreateResource(ctx context.Context, create *store.Resource) (*store.Resource, error) {
-	fields := []string{"`resource_name`", "`filename`", "`blob`", "`external_link`", "`type`", "`size`", "`creator_id`", "`internal_path`", "`memo_id`"}
-	placeholder := []string{"?", "?", "?", "?", "?", "?", "?", "?", "?"}
-	args := []any{create.ResourceName, create.Filename, create.Blob, create.ExternalLink, create.Type, create.Size, create.CreatorID, create.InternalPath, create.MemoID}
+	fields := []string{"`resource_name`", "`filename`", "`blob`", "`external_link`", "`type`", "`size`", "`creator_id`", "`internal_path`", "`memo_id`", "`expires_ts`", "`cache_control`", "`original_ts`", "`sha256`", "`compression`"}
+	placeholder := []string{"?", "?", "?", "?", "?", "?", "?", "?", "?", "?", "?", "?", "?", "?"}
+	args := []any{create.ResourceName, create.Filename, create.Blob, create.ExternalLink, create.Type, create.Size, create.CreatorID, create.InternalPath, create.MemoID, create.ExpiresTs, create.CacheControl, create.OriginalTs, create.Sha256, create.Compression}
 
 	stmt := "INSERT INTO `resource` (" + strings.Join(fields, ", ") + ") VALUES (" + strings.Join(placeholder, ", ") + ")"
 	result, err := d.db.ExecContext(ctx, stmt, args...)
@@ -30,33 +32,18 @@ func (d *DB) CreateResource(ctx context.Context, create *store.Resource) (*store
 }
 
 func (d *DB) ListResources(ctx context.Context, find *store.FindResource) ([]*store.Resource, error) {
-	where, args := []string{"1 = 1"}, []any{}
-
-	if v := find.ID; v != nil {
-		where, args = append(where, "`id` = ?"), append(args, *v)
-	}
-	if v := find.ResourceName; v != nil {
-		where, args = append(where, "`resource_name` = ?"), append(args, *v)
-	}
-	if v := find.CreatorID; v != nil {
-		where, args = append(where, "`creator_id` = ?"), append(args, *v)
-	}
-	if v := find.Filename; v != nil {
-		where, args = append(where, "`filename` = ?"), append(args, *v)
-	}
-	if v := find.MemoID; v != nil {
-		where, args = append(where, "`memo_id` = ?"), append(args, *v)
-	}
-	if find.HasRelatedMemo {
-		where = append(where, "`memo_id` IS NOT NULL")
+	where, args := buildResourceWhere(find)
+	orderBy, err := buildResourceOrderBy(find)
+	if err != nil {
+		return nil, err
 	}
 
-	fields := []string{"`id`", "`resource_name`", "`filename`", "`external_link`", "`type`", "`size`", "`creator_id`", "UNIX_TIMESTAMP(`created_ts`)", "UNIX_TIMESTAMP(`updated_ts`)", "`internal_path`", "`memo_id`"}
+	fields := []string{"`id`", "`resource_name`", "`filename`", "`external_link`", "`type`", "`size`", "`creator_id`", "UNIX_TIMESTAMP(`created_ts`)", "UNIX_TIMESTAMP(`updated_ts`)", "`internal_path`", "`memo_id`", "`expires_ts`", "`cache_control`", "`original_ts`", "`sha256`", "`compression`"}
 	if find.GetBlob {
 		fields = append(fields, "`blob`")
 	}
 
-	query := fmt.Sprintf("SELECT %s FROM `resource` WHERE %s ORDER BY `updated_ts` DESC, `created_ts` DESC", strings.Join(fields, ", "), strings.Join(where, " AND "))
+	query := fmt.Sprintf("SELECT %s FROM `resource` WHERE %s ORDER BY %s", strings.Join(fields, ", "), strings.Join(where, " AND "), orderBy)
 	if find.Limit != nil {
 		query = fmt.Sprintf("%s LIMIT %d", query, *find.Limit)
 		if find.Offset != nil {
@@ -74,6 +61,8 @@ func (d *DB) ListResources(ctx context.Context, find *store.FindResource) ([]*st
 	for rows.Next() {
 		resource := store.Resource{}
 		var memoID sql.NullInt32
+		var cacheControl sql.NullString
+		var originalTs sql.NullInt64
 		dests := []any{
 			&resource.ID,
 			&resource.ResourceName,
@@ -86,6 +75,11 @@ func (d *DB) ListResources(ctx context.Context, find *store.FindResource) ([]*st
 			&resource.UpdatedTs,
 			&resource.InternalPath,
 			&memoID,
+			&resource.ExpiresTs,
+			&cacheControl,
+			&originalTs,
+			&resource.Sha256,
+			&resource.Compression,
 		}
 		if find.GetBlob {
 			dests = append(dests, &resource.Blob)
@@ -96,6 +90,12 @@ func (d *DB) ListResources(ctx context.Context, find *store.FindResource) ([]*st
 		if memoID.Valid {
 			resource.MemoID = &memoID.Int32
 		}
+		if cacheControl.Valid {
+			resource.CacheControl = &cacheControl.String
+		}
+		if originalTs.Valid {
+			resource.OriginalTs = &originalTs.Int64
+		}
 		list = append(list, &resource)
 	}
 
@@ -106,6 +106,16 @@ func (d *DB) ListResources(ctx context.Context, find *store.FindResource) ([]*st
 	return list, nil
 }
 
+func (d *DB) CountResources(ctx context.Context, find *store.FindResource) (int64, error) {
+	where, args := buildResourceWhere(find)
+	query := "SELECT COUNT(*) FROM `resource` WHERE " + strings.Join(where, " AND ")
+	var count int64
+	if err := d.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 func (d *DB) GetResource(ctx context.Context, find *store.FindResource) (*store.Resource, error) {
 	list, err := d.ListResources(ctx, find)
 	if err != nil {
@@ -118,6 +128,49 @@ func (d *DB) GetResource(ctx context.Context, find *store.FindResource) (*store.
 	return list[0], nil
 }
 
+func (d *DB) SumResourceSize(ctx context.Context, find *store.FindResource) (*store.ResourceSizeSum, error) {
+	where, args := buildResourceWhere(find)
+	query := "SELECT COUNT(*), COALESCE(SUM(`size`), 0) FROM `resource` WHERE " + strings.Join(where, " AND ")
+	sum := &store.ResourceSizeSum{}
+	if err := d.db.QueryRowContext(ctx, query, args...).Scan(&sum.Count, &sum.Size); err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+func (d *DB) GroupResourceSize(ctx context.Context, find *store.FindResource, groupBy store.ResourceSizeGroupBy) ([]*store.ResourceSizeSum, error) {
+	var key string
+	switch groupBy {
+	case store.ResourceSizeGroupByStorage:
+		key = "CASE WHEN `internal_path` != '' THEN 'local' WHEN `external_link` != '' THEN 'external' ELSE 'database' END"
+	case store.ResourceSizeGroupByType:
+		key = "SUBSTRING_INDEX(`type`, '/', 1)"
+	default:
+		return nil, errors.Errorf("invalid group by %q", groupBy)
+	}
+
+	where, args := buildResourceWhere(find)
+	query := fmt.Sprintf("SELECT %s AS `group_key`, COUNT(*), COALESCE(SUM(`size`), 0) FROM `resource` WHERE %s GROUP BY `group_key` ORDER BY `group_key`", key, strings.Join(where, " AND "))
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*store.ResourceSizeSum{}
+	for rows.Next() {
+		sum := &store.ResourceSizeSum{}
+		if err := rows.Scan(&sum.Key, &sum.Count, &sum.Size); err != nil {
+			return nil, err
+		}
+		list = append(list, sum)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
 func (d *DB) UpdateResource(ctx context.Context, update *store.UpdateResource) (*store.Resource, error) {
 	set, args := []string{}, []any{}
 
@@ -130,6 +183,12 @@ func (d *DB) UpdateResource(ctx context.Context, update *store.UpdateResource) (
 	if v := update.Filename; v != nil {
 		set, args = append(set, "`filename` = ?"), append(args, *v)
 	}
+	if v := update.Type; v != nil {
+		set, args = append(set, "`type` = ?"), append(args, *v)
+	}
+	if v := update.Size; v != nil {
+		set, args = append(set, "`size` = ?"), append(args, *v)
+	}
 	if v := update.InternalPath; v != nil {
 		set, args = append(set, "`internal_path` = ?"), append(args, *v)
 	}
@@ -137,11 +196,27 @@ func (d *DB) UpdateResource(ctx context.Context, update *store.UpdateResource) (
 		set, args = append(set, "`external_link` = ?"), append(args, *v)
 	}
 	if v := update.MemoID; v != nil {
-		set, args = append(set, "`memo_id` = ?"), append(args, *v)
+		if *v == 0 {
+			set = append(set, "`memo_id` = NULL")
+		} else {
+			set, args = append(set, "`memo_id` = ?"), append(args, *v)
+		}
 	}
 	if v := update.Blob; v != nil {
 		set, args = append(set, "`blob` = ?"), append(args, v)
 	}
+	if v := update.CacheControl; v != nil {
+		set, args = append(set, "`cache_control` = ?"), append(args, sql.NullString{String: *v, Valid: *v != ""})
+	}
+	if v := update.OriginalTs; v != nil {
+		set, args = append(set, "`original_ts` = ?"), append(args, sql.NullInt64{Int64: *v, Valid: *v != 0})
+	}
+	if v := update.Sha256; v != nil {
+		set, args = append(set, "`sha256` = ?"), append(args, *v)
+	}
+	if v := update.Compression; v != nil {
+		set, args = append(set, "`compression` = ?"), append(args, *v)
+	}
 
 	args = append(args, update.ID)
 	stmt := "UPDATE `resource` SET " + strings.Join(set, ", ") + " WHERE `id` = ?"
@@ -179,3 +254,85 @@ func vacuumResource(ctx context.Context, tx *sql.Tx) error {
 
 	return nil
 }
+
+func buildResourceWhere(find *store.FindResource) ([]string, []any) {
+	where, args := []string{"1 = 1"}, []any{}
+
+	if v := find.ID; v != nil {
+		where, args = append(where, "`id` = ?"), append(args, *v)
+	}
+	if v := find.IDs; v != nil {
+		if len(v) == 0 {
+			where = append(where, "1 = 0")
+		} else {
+			where = append(where, fmt.Sprintf("`id` IN (%s)", strings.TrimSuffix(strings.Repeat("?, ", len(v)), ", ")))
+			for _, id := range v {
+				args = append(args, id)
+			}
+		}
+	}
+	if v := find.ResourceName; v != nil {
+		where, args = append(where, "`resource_name` = ?"), append(args, *v)
+	}
+	if v := find.CreatorID; v != nil {
+		where, args = append(where, "`creator_id` = ?"), append(args, *v)
+	}
+	if v := find.Filename; v != nil {
+		where, args = append(where, "`filename` = ?"), append(args, *v)
+	}
+	if v := find.FilenameSearch; v != nil {
+		where, args = append(where, "LOWER(`filename`) LIKE LOWER(?) ESCAPE '\\\\'"), append(args, fmt.Sprintf("%%%s%%", store.EscapeLikePattern(*v)))
+	}
+	if v := find.MemoID; v != nil {
+		where, args = append(where, "`memo_id` = ?"), append(args, *v)
+	}
+	if find.HasRelatedMemo {
+		where = append(where, "`memo_id` IS NOT NULL")
+	}
+	if find.MissingSha256 {
+		where = append(where, "`sha256` = '' AND (`internal_path` != '' OR `external_link` = '')")
+	}
+	if v := find.Storage; v != nil {
+		switch *v {
+		case store.ResourceStorageLocal:
+			where = append(where, "`internal_path` != ''")
+		case store.ResourceStorageExternal:
+			where = append(where, "`internal_path` = '' AND `external_link` != ''")
+		case store.ResourceStorageDatabase:
+			where = append(where, "`internal_path` = '' AND `external_link` = ''")
+		default:
+			where = append(where, "1 = 0")
+		}
+	}
+	if v := find.ExpiresBefore; v != nil {
+		where, args = append(where, "`expires_ts` > 0 AND `expires_ts` < ?"), append(args, *v)
+	}
+	if v := find.NotExpiredAt; v != nil {
+		where, args = append(where, "(`expires_ts` = 0 OR `expires_ts` > ?)"), append(args, *v)
+	}
+	if v := find.AfterID; v != nil {
+		where, args = append(where, "`id` > ?"), append(args, *v)
+	}
+	if find.CreatedTsBefore != nil && find.IDBefore != nil {
+		where, args = append(where, "(`created_ts` < FROM_UNIXTIME(?) OR (`created_ts` = FROM_UNIXTIME(?) AND `id` < ?))"), append(args, *find.CreatedTsBefore, *find.CreatedTsBefore, *find.IDBefore)
+	}
+	return where, args
+}
+
+func buildResourceOrderBy(find *store.FindResource) (string, error) {
+	if find.OrderBy == "" {
+		return "`updated_ts` DESC, `created_ts` DESC", nil
+	}
+	// Only allowlisted fields are used as column names in the query.
+	if !find.OrderBy.IsValid() {
+		return "", errors.Errorf("invalid order by field %q", find.OrderBy)
+	}
+	direction := "ASC"
+	if find.OrderDesc {
+		direction = "DESC"
+	}
+	if find.OrderBy == store.ResourceOrderByID {
+		return fmt.Sprintf("`id` %s", direction), nil
+	}
+	return fmt.Sprintf("`%s` %s, `id` %s", find.OrderBy, direction, direction), nil
+}
@@ -74,7 +74,20 @@ CREATE TABLE resource (
   type TEXT NOT NULL DEFAULT '',
   size INTEGER NOT NULL DEFAULT 0,
   internal_path TEXT NOT NULL DEFAULT '',
-  memo_id INTEGER DEFAULT NULL
+  memo_id INTEGER DEFAULT NULL,
+  expires_ts BIGINT NOT NULL DEFAULT 0,
+  cache_control TEXT DEFAULT NULL,
+  original_ts BIGINT DEFAULT NULL,
+  sha256 TEXT NOT NULL DEFAULT '',
+  compression TEXT NOT NULL DEFAULT ''
+);
+
+-- resource_blob_chunk
+CREATE TABLE resource_blob_chunk (
+  resource_id INTEGER NOT NULL,
+  seq INTEGER NOT NULL,
+  data BYTEA NOT NULL,
+  UNIQUE(resource_id, seq)
 );
 
 -- tag
@@ -0,0 +1,6 @@
+CREATE TABLE resource_blob_chunk (
+  resource_id INTEGER NOT NULL,
+  seq INTEGER NOT NULL,
+  data BYTEA NOT NULL,
+  UNIQUE(resource_id, seq)
+);
@@ -0,0 +1 @@
+ALTER TABLE resource ADD COLUMN original_ts BIGINT DEFAULT NULL;
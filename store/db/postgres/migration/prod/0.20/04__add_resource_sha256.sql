@@ -0,0 +1 @@
+ALTER TABLE resource ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
@@ -81,13 +81,26 @@ CREATE TABLE resource (
   type TEXT NOT NULL DEFAULT '',
   size INTEGER NOT NULL DEFAULT 0,
   internal_path TEXT NOT NULL DEFAULT '',
-  memo_id INTEGER
+  memo_id INTEGER,
+  expires_ts BIGINT NOT NULL DEFAULT 0,
+  cache_control TEXT DEFAULT NULL,
+  original_ts BIGINT DEFAULT NULL,
+  sha256 TEXT NOT NULL DEFAULT '',
+  compression TEXT NOT NULL DEFAULT ''
 );
 
 CREATE INDEX idx_resource_creator_id ON resource (creator_id);
 
 CREATE INDEX idx_resource_memo_id ON resource (memo_id);
 
+-- resource_blob_chunk
+CREATE TABLE resource_blob_chunk (
+  resource_id INTEGER NOT NULL,
+  seq INTEGER NOT NULL,
+  data BLOB NOT NULL,
+  UNIQUE(resource_id, seq)
+);
+
 -- tag
 CREATE TABLE tag (
   name TEXT NOT NULL,
@@ -0,0 +1 @@
+ALTER TABLE resource ADD COLUMN expires_ts BIGINT NOT NULL DEFAULT 0;
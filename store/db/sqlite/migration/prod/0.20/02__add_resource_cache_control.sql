@@ -0,0 +1 @@
+ALTER TABLE resource ADD COLUMN cache_control TEXT DEFAULT NULL;
@@ -0,0 +1 @@
+ALTER TABLE resource ADD COLUMN compression TEXT NOT NULL DEFAULT '';
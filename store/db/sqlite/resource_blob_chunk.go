@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+
+	"github.com/usememos/memos/store"
+)
+
+func (d *DB) CreateResourceBlobChunk(ctx context.Context, create *store.ResourceBlobChunk) error {
+	stmt := "INSERT INTO `resource_blob_chunk` (`resource_id`, `seq`, `data`) VALUES (?, ?, ?)"
+	if _, err := d.db.ExecContext(ctx, stmt, create.ResourceID, create.Seq, create.Data); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *DB) GetResourceBlobChunk(ctx context.Context, resourceID int32, seq int32) ([]byte, error) {
+	stmt := "SELECT `data` FROM `resource_blob_chunk` WHERE `resource_id` = ? AND `seq` = ?"
+	var data []byte
+	if err := d.db.QueryRowContext(ctx, stmt, resourceID, seq).Scan(&data); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return data, nil
+}
+
+func (d *DB) DeleteResourceBlobChunks(ctx context.Context, resourceID int32) error {
+	stmt := "DELETE FROM `resource_blob_chunk` WHERE `resource_id` = ?"
+	if _, err := d.db.ExecContext(ctx, stmt, resourceID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *DB) SwapResourceBlobChunks(ctx context.Context, resourceID int32, stagingID int32) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM `resource_blob_chunk` WHERE `resource_id` = ?", resourceID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE `resource_blob_chunk` SET `resource_id` = ? WHERE `resource_id` = ?", resourceID, stagingID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func vacuumResourceBlobChunk(ctx context.Context, tx *sql.Tx) error {
+	stmt := "DELETE FROM `resource_blob_chunk` WHERE `resource_id` NOT IN (SELECT `id` FROM `resource`) AND -`resource_id` NOT IN (SELECT `id` FROM `resource`)"
+	_, err := tx.ExecContext(ctx, stmt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -88,6 +88,9 @@ func vacuumImpl(ctx context.Context, tx *sql.Tx) error {
 	if err := vacuumResource(ctx, tx); err != nil {
 		return err
 	}
+	if err := vacuumResourceBlobChunk(ctx, tx); err != nil {
+		return err
+	}
 	if err := vacuumUserSetting(ctx, tx); err != nil {
 		return err
 	}
@@ -30,9 +30,18 @@ type Driver interface {
 	// Resource model related methods.
 	CreateResource(ctx context.Context, create *Resource) (*Resource, error)
 	ListResources(ctx context.Context, find *FindResource) ([]*Resource, error)
+	CountResources(ctx context.Context, find *FindResource) (int64, error)
+	SumResourceSize(ctx context.Context, find *FindResource) (*ResourceSizeSum, error)
+	GroupResourceSize(ctx context.Context, find *FindResource, groupBy ResourceSizeGroupBy) ([]*ResourceSizeSum, error)
 	UpdateResource(ctx context.Context, update *UpdateResource) (*Resource, error)
 	DeleteResource(ctx context.Context, delete *DeleteResource) error
 
+	// ResourceBlobChunk model related methods.
+	CreateResourceBlobChunk(ctx context.Context, create *ResourceBlobChunk) error
+	GetResourceBlobChunk(ctx context.Context, resourceID int32, seq int32) ([]byte, error)
+	DeleteResourceBlobChunks(ctx context.Context, resourceID int32) error
+	SwapResourceBlobChunks(ctx context.Context, resourceID int32, stagingID int32) error
+
 	// Memo model related methods.
 	CreateMemo(ctx context.Context, create *Memo) (*Memo, error)
 	ListMemos(ctx context.Context, find *FindMemo) ([]*Memo, error)
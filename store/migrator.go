@@ -43,6 +43,7 @@ func (s *Store) MigrateResourceInternalPath(ctx context.Context) error {
 		_, err := s.UpdateResource(ctx, &UpdateResource{
 			ID:           resource.ID,
 			InternalPath: &internalPath,
+			Compression:  &resource.Compression,
 		})
 		if err != nil {
 			return errors.Wrap(err, "failed to update local resource path")
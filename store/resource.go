@@ -1,19 +1,33 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 
+	"github.com/usememos/memos/internal/log"
 	"github.com/usememos/memos/internal/util"
+	"github.com/usememos/memos/server/service/metric"
 )
 
 const (
+	// maxResourceIDsPerQuery is the maximum amount of IDs of FindResource.IDs in a single query.
+	maxResourceIDsPerQuery = 500
 	// thumbnailImagePath is the directory to store image thumbnails.
 	thumbnailImagePath = ".thumbnail_cache"
+	// MaxCacheControlLength is the maximum length of the Cache-Control override of a resource.
+	MaxCacheControlLength = 256
 )
 
 type Resource struct {
@@ -26,36 +40,166 @@ type Resource struct {
 	UpdatedTs int64
 
 	// Domain specific fields
-	Filename     string
-	Blob         []byte
+	Filename string
+	Blob     []byte
+	// BlobReader is stored as the chunked blob of the resource when it's created, it is never loaded back.
+	BlobReader   io.Reader
 	InternalPath string
 	ExternalLink string
 	Type         string
 	Size         int64
 	MemoID       *int32
+	// ExpiresTs is the time after which the resource is deleted, 0 means it never expires.
+	ExpiresTs int64
+	// CacheControl overrides the Cache-Control header of the resource content, nil means the default.
+	CacheControl *string
+	// OriginalTs is the modification time of the file before it was uploaded, such as when imported
+	// from another system. It's served as the last modification time of the content instead of UpdatedTs.
+	OriginalTs *int64
+	// Sha256 is the hex encoded SHA-256 of the content, empty for external resources and until it's back-filled.
+	Sha256 string
+	// Compression is how the local file of the resource is compressed, ResourceCompressionGzip or empty when it's stored as-is.
+	Compression string
+}
+
+// ResourceSidecarSuffix is appended to the path or key of a blob to get the one of its ResourceSidecar.
+const ResourceSidecarSuffix = ".meta.json"
+
+// ResourceSidecar is the metadata written as JSON next to blobs of the local and external storages when enabled,
+// so the resources can be rebuilt from the storage alone. The sidecar of `assets/photo.png` is `assets/photo.png.meta.json`.
+type ResourceSidecar struct {
+	Filename  string `json:"filename"`
+	Type      string `json:"type"`
+	Size      int64  `json:"size"`
+	CreatorID int32  `json:"creatorId"`
+	CreatedTs int64  `json:"createdTs"`
+}
+
+// IsExpired reports whether the resource has an expiry before or at ts.
+func (r *Resource) IsExpired(ts int64) bool {
+	return r.ExpiresTs > 0 && r.ExpiresTs <= ts
+}
+
+// LastModifiedTs returns the last modification time of the content, the original one when known.
+func (r *Resource) LastModifiedTs() int64 {
+	if r.OriginalTs != nil {
+		return *r.OriginalTs
+	}
+	return r.UpdatedTs
+}
+
+// The storages the content of a resource can be kept in, see Resource.Storage.
+const (
+	ResourceStorageDatabase = "database"
+	ResourceStorageLocal    = "local"
+	ResourceStorageExternal = "external"
+)
+
+// Storage returns where the content of the resource is kept: database, local or external.
+func (r *Resource) Storage() string {
+	if r.InternalPath != "" {
+		return ResourceStorageLocal
+	} else if r.ExternalLink != "" {
+		return ResourceStorageExternal
+	}
+	return ResourceStorageDatabase
+}
+
+// ResourceOrderBy is the field to order resources by.
+type ResourceOrderBy string
+
+const (
+	ResourceOrderByID        ResourceOrderBy = "id"
+	ResourceOrderByCreatedTs ResourceOrderBy = "created_ts"
+	ResourceOrderByUpdatedTs ResourceOrderBy = "updated_ts"
+	ResourceOrderBySize      ResourceOrderBy = "size"
+	ResourceOrderByFilename  ResourceOrderBy = "filename"
+)
+
+// IsValid returns true if the order by field is supported.
+func (o ResourceOrderBy) IsValid() bool {
+	switch o {
+	case ResourceOrderByID, ResourceOrderByCreatedTs, ResourceOrderByUpdatedTs, ResourceOrderBySize, ResourceOrderByFilename:
+		return true
+	}
+	return false
 }
 
 type FindResource struct {
-	GetBlob        bool
-	ID             *int32
-	ResourceName   *string
-	CreatorID      *int32
-	Filename       *string
+	GetBlob bool
+	ID      *int32
+	// IDs matches resources with any of the IDs, an empty non-nil list matches none.
+	// Without OrderBy the resources are listed in the order of IDs.
+	IDs          []int32
+	ResourceName *string
+	CreatorID    *int32
+	Filename     *string
+	// FilenameSearch matches resources whose filename contains the term, case-insensitively.
+	FilenameSearch *string
 	MemoID         *int32
 	HasRelatedMemo bool
-	Limit          *int
-	Offset         *int
+	// Storage matches resources whose content is kept in the storage, see Resource.Storage.
+	// Resources don't record which external storage they were uploaded to.
+	Storage *string
+	// MissingSha256 matches resources with content kept by the server but without hash.
+	MissingSha256 bool
+	// ExpiresBefore matches resources with an expiry earlier than the timestamp.
+	ExpiresBefore *int64
+	// NotExpiredAt matches resources without expiry or expiring after the timestamp.
+	NotExpiredAt *int64
+	// AfterID matches resources with an ID greater than it.
+	AfterID *int32
+	// CreatedTsBefore and IDBefore are a keyset cursor, they match the resources listed after it
+	// when ordered by created_ts and id descending. Both must be set.
+	CreatedTsBefore *int64
+	IDBefore        *int32
+	Limit           *int
+	Offset          *int
+	// OrderBy is the field to order by, defaults to updated_ts and created_ts descending when empty.
+	OrderBy   ResourceOrderBy
+	OrderDesc bool
+}
+
+// ResourceSizeSum is the amount and total size of a set of resources.
+type ResourceSizeSum struct {
+	// Key is the value the resources are grouped by, empty for a total.
+	Key   string
+	Count int64
+	Size  int64
 }
 
+// ResourceSizeGroupBy is what resource sizes are grouped by.
+type ResourceSizeGroupBy string
+
+const (
+	// ResourceSizeGroupByStorage groups by Resource.Storage.
+	ResourceSizeGroupByStorage ResourceSizeGroupBy = "storage"
+	// ResourceSizeGroupByType groups by the MIME type category, i.e. the part before the slash.
+	ResourceSizeGroupByType ResourceSizeGroupBy = "type"
+)
+
 type UpdateResource struct {
 	ID           int32
 	ResourceName *string
 	UpdatedTs    *int64
 	Filename     *string
+	Type         *string
+	Size         *int64
 	InternalPath *string
 	ExternalLink *string
-	MemoID       *int32
-	Blob         []byte
+	// MemoID links the resource to the memo, 0 unlinks it.
+	MemoID *int32
+	Blob   []byte
+	// BlobReader replaces the chunked blob of the resource.
+	BlobReader io.Reader
+	// CacheControl sets the Cache-Control header of the resource, an empty value resets it to the default.
+	CacheControl *string
+	// OriginalTs sets the modification time of the file before it was uploaded, 0 resets it.
+	OriginalTs *int64
+	// Sha256 sets the hash of the content, it's computed for Blob and BlobReader when unset.
+	Sha256 *string
+	// Compression sets the compression of the local file, it's reset when the content is replaced without it.
+	Compression *string
 }
 
 type DeleteResource struct {
@@ -67,11 +211,86 @@ func (s *Store) CreateResource(ctx context.Context, create *Resource) (*Resource
 	if !util.ResourceNameMatcher.MatchString(create.ResourceName) {
 		return nil, errors.New("invalid resource name")
 	}
-	return s.driver.CreateResource(ctx, create)
+	if create.CacheControl != nil && !util.ValidateHeaderValue(*create.CacheControl, MaxCacheControlLength) {
+		return nil, errors.New("invalid cache control")
+	}
+	if create.Sha256 == "" && create.BlobReader == nil && create.InternalPath == "" && create.ExternalLink == "" {
+		create.Sha256 = hashResourceBlob(create.Blob)
+	}
+	resource, err := s.driver.CreateResource(ctx, create)
+	if err != nil {
+		return nil, err
+	}
+
+	if create.BlobReader != nil {
+		hash, err := s.writeHashedResourceBlob(ctx, resource.ID, create.BlobReader)
+		if err != nil {
+			// The write may have failed because ctx is done, the partial blob is removed regardless.
+			cleanupCtx := context.WithoutCancel(ctx)
+			_ = s.driver.DeleteResourceBlobChunks(cleanupCtx, resource.ID)
+			_ = s.driver.DeleteResource(cleanupCtx, &DeleteResource{ID: resource.ID})
+			return nil, err
+		}
+		resource.Sha256 = hash
+	}
+	s.notifyResourceObservers(ResourceEventCreated, resource)
+	return resource, nil
 }
 
 func (s *Store) ListResources(ctx context.Context, find *FindResource) ([]*Resource, error) {
-	return s.driver.ListResources(ctx, find)
+	if find.IDs == nil {
+		return s.driver.ListResources(ctx, find)
+	}
+	if len(find.IDs) == 0 {
+		return []*Resource{}, nil
+	}
+
+	// Long lists are queried in chunks to stay under the parameter limits of the databases.
+	if len(find.IDs) > maxResourceIDsPerQuery && (find.Limit != nil || find.Offset != nil || find.OrderBy != "") {
+		return nil, errors.Errorf("limit, offset and order by can't be used with more than %d IDs", maxResourceIDsPerQuery)
+	}
+	list := []*Resource{}
+	for start := 0; start < len(find.IDs); start += maxResourceIDsPerQuery {
+		chunkFind := *find
+		chunkFind.IDs = find.IDs[start:min(start+maxResourceIDsPerQuery, len(find.IDs))]
+		chunk, err := s.driver.ListResources(ctx, &chunkFind)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, chunk...)
+	}
+	if find.OrderBy == "" {
+		positions := make(map[int32]int, len(find.IDs))
+		for i, id := range find.IDs {
+			if _, ok := positions[id]; !ok {
+				positions[id] = i
+			}
+		}
+		sort.SliceStable(list, func(i, j int) bool {
+			return positions[list[i].ID] < positions[list[j].ID]
+		})
+	}
+	return list, nil
+}
+
+// CountResources returns the number of resources matching the find filters, ignoring limit and offset.
+func (s *Store) CountResources(ctx context.Context, find *FindResource) (int64, error) {
+	return s.driver.CountResources(ctx, find)
+}
+
+// SumResourceSize returns the amount and total size of the resources matching find.
+func (s *Store) SumResourceSize(ctx context.Context, find *FindResource) (*ResourceSizeSum, error) {
+	return s.driver.SumResourceSize(ctx, find)
+}
+
+// GroupResourceSizeByStorage returns the amount and total size of the resources matching find per storage.
+func (s *Store) GroupResourceSizeByStorage(ctx context.Context, find *FindResource) ([]*ResourceSizeSum, error) {
+	return s.driver.GroupResourceSize(ctx, find, ResourceSizeGroupByStorage)
+}
+
+// GroupResourceSizeByType returns the amount and total size of the resources matching find per MIME type category.
+func (s *Store) GroupResourceSizeByType(ctx context.Context, find *FindResource) ([]*ResourceSizeSum, error) {
+	return s.driver.GroupResourceSize(ctx, find, ResourceSizeGroupByType)
 }
 
 func (s *Store) GetResource(ctx context.Context, find *FindResource) (*Resource, error) {
@@ -87,11 +306,193 @@ func (s *Store) GetResource(ctx context.Context, find *FindResource) (*Resource,
 	return resources[0], nil
 }
 
+// ErrResourceContentExternal is returned when the resource content is stored in an external service.
+var ErrResourceContentExternal = errors.New("resource content is stored externally")
+
+// GetResourceLocalPath returns the path of the local file of the resource, empty when it's not stored locally.
+// The file is compressed when the resource has a Compression.
+func (s *Store) GetResourceLocalPath(resource *Resource) string {
+	if resource.InternalPath == "" {
+		return ""
+	}
+	resourcePath := filepath.FromSlash(resource.InternalPath)
+	if !filepath.IsAbs(resourcePath) {
+		resourcePath = filepath.Join(s.Profile.Data, resourcePath)
+	}
+	return resourcePath
+}
+
+// GetResourceContent opens the content of the resource stored in the database or on the local disk.
+// Chunked blobs are streamed from the database chunk by chunk.
+// ErrResourceContentExternal is returned for resources that only have an external link.
+// The caller is responsible for closing the returned reader.
+func (s *Store) GetResourceContent(ctx context.Context, resource *Resource) (io.ReadCloser, error) {
+	start := time.Now()
+	if resource.InternalPath != "" {
+		resourcePath := s.GetResourceLocalPath(resource)
+		file, err := os.Open(resourcePath)
+		if err != nil {
+			metric.ObserveStorageOperation("download", "local", resource.InternalPath, start, 0, err)
+			return nil, errors.Wrapf(err, "failed to open the local resource: %s", resourcePath)
+		}
+		if resource.Compression == ResourceCompressionGzip {
+			reader, err := openCompressedLocalResource(resource, file)
+			if err != nil {
+				metric.ObserveStorageOperation("download", "local", resource.InternalPath, start, 0, err)
+				return nil, err
+			}
+			return metric.ObserveStorageReader("download", "local", resource.InternalPath, start, reader), nil
+		}
+		return metric.ObserveStorageReader("download", "local", resource.InternalPath, start, file), nil
+	}
+	if resource.ExternalLink != "" {
+		return nil, ErrResourceContentExternal
+	}
+
+	if len(resource.Blob) == 0 {
+		reader, err := s.openResourceBlob(ctx, resource.ID)
+		if err != nil {
+			metric.ObserveStorageOperation("download", "database", strconv.Itoa(int(resource.ID)), start, 0, err)
+			return nil, err
+		}
+		if reader != nil {
+			return metric.ObserveStorageReader("download", "database", strconv.Itoa(int(resource.ID)), start, reader), nil
+		}
+	}
+
+	blob := resource.Blob
+	if blob == nil {
+		resourceWithBlob, err := s.GetResource(ctx, &FindResource{ID: &resource.ID, GetBlob: true})
+		if err != nil {
+			metric.ObserveStorageOperation("download", "database", strconv.Itoa(int(resource.ID)), start, 0, err)
+			return nil, errors.Wrap(err, "failed to get resource blob")
+		}
+		if resourceWithBlob == nil {
+			return nil, errors.Errorf("resource %d not found", resource.ID)
+		}
+		blob = resourceWithBlob.Blob
+	}
+	return metric.ObserveStorageReader("download", "database", strconv.Itoa(int(resource.ID)), start, io.NopCloser(bytes.NewReader(blob))), nil
+}
+
 func (s *Store) UpdateResource(ctx context.Context, update *UpdateResource) (*Resource, error) {
 	if update.ResourceName != nil && !util.ResourceNameMatcher.MatchString(*update.ResourceName) {
 		return nil, errors.New("invalid resource name")
 	}
-	return s.driver.UpdateResource(ctx, update)
+	if update.CacheControl != nil && *update.CacheControl != "" && !util.ValidateHeaderValue(*update.CacheControl, MaxCacheControlLength) {
+		return nil, errors.New("invalid cache control")
+	}
+	if update.Sha256 == nil && update.BlobReader == nil && (update.Blob != nil || update.InternalPath != nil || update.ExternalLink != nil) {
+		// The content is replaced, the hash of content kept elsewhere is unknown until it's back-filled.
+		hash := ""
+		if update.InternalPath == nil && update.ExternalLink == nil {
+			hash = hashResourceBlob(update.Blob)
+		}
+		updateWithHash := *update
+		updateWithHash.Sha256 = &hash
+		update = &updateWithHash
+	}
+	if update.Compression == nil && (update.Blob != nil || update.BlobReader != nil || update.InternalPath != nil || update.ExternalLink != nil) {
+		// Only the local storage compresses, and it sets the compression of the content it writes.
+		compression := ""
+		updateWithCompression := *update
+		updateWithCompression.Compression = &compression
+		update = &updateWithCompression
+	}
+	var previous *Resource
+	if update.BlobReader != nil {
+		// The new blob is staged before the resource is updated, so a failed write leaves the previous content in place.
+		unlock := s.lockResourceBlob(update.ID)
+		defer unlock()
+		var err error
+		previous, err = s.GetResource(ctx, &FindResource{ID: &update.ID, GetBlob: true})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get resource")
+		}
+		if previous == nil {
+			return nil, errors.Errorf("resource %d not found", update.ID)
+		}
+		hasher := sha256.New()
+		if _, err := s.WriteResourceBlob(ctx, resourceBlobStagingID(update.ID), io.TeeReader(update.BlobReader, hasher)); err != nil {
+			return nil, err
+		}
+		hash := hex.EncodeToString(hasher.Sum(nil))
+		updateWithHash := *update
+		updateWithHash.Sha256 = &hash
+		update = &updateWithHash
+	}
+	resource, err := s.driver.UpdateResource(ctx, update)
+	if err != nil {
+		if update.BlobReader != nil {
+			_ = s.driver.DeleteResourceBlobChunks(context.WithoutCancel(ctx), resourceBlobStagingID(update.ID))
+		}
+		return nil, err
+	}
+
+	// Thumbnails of the replaced content are stale.
+	if update.Blob != nil || update.BlobReader != nil || update.InternalPath != nil || update.ExternalLink != nil {
+		s.DeleteResourceThumbnails(resource.ID)
+	}
+	if update.BlobReader != nil {
+		if err := s.driver.SwapResourceBlobChunks(ctx, resource.ID, resourceBlobStagingID(resource.ID)); err != nil {
+			// The previous chunks are kept by the failed swap, the resource is pointed back to them.
+			cleanupCtx := context.WithoutCancel(ctx)
+			_ = s.driver.DeleteResourceBlobChunks(cleanupCtx, resourceBlobStagingID(resource.ID))
+			if _, restoreErr := s.driver.UpdateResource(cleanupCtx, restoreResourceUpdate(previous)); restoreErr != nil {
+				log.Error("failed to restore replaced resource", zap.Int32("resource", resource.ID), zap.Error(restoreErr))
+			}
+			return nil, errors.Wrap(err, "failed to swap resource blob chunks")
+		}
+	} else if update.Blob != nil || update.InternalPath != nil || update.ExternalLink != nil {
+		if err := s.driver.DeleteResourceBlobChunks(ctx, resource.ID); err != nil {
+			return nil, errors.Wrap(err, "failed to delete resource blob chunks")
+		}
+	}
+	return resource, nil
+}
+
+// writeHashedResourceBlob stores the content of the reader as the chunked blob of the resource, along with its hash.
+func (s *Store) writeHashedResourceBlob(ctx context.Context, resourceID int32, r io.Reader) (string, error) {
+	hasher := sha256.New()
+	if _, err := s.WriteResourceBlob(ctx, resourceID, io.TeeReader(r, hasher)); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if _, err := s.driver.UpdateResource(ctx, &UpdateResource{ID: resourceID, Sha256: &hash}); err != nil {
+		return "", errors.Wrap(err, "failed to update resource hash")
+	}
+	return hash, nil
+}
+
+// restoreResourceUpdate returns the update setting the content of the resource back to the given one.
+func restoreResourceUpdate(resource *Resource) *UpdateResource {
+	blob := resource.Blob
+	if blob == nil {
+		blob = []byte{}
+	}
+	originalTs := int64(0)
+	if resource.OriginalTs != nil {
+		originalTs = *resource.OriginalTs
+	}
+	return &UpdateResource{
+		ID:           resource.ID,
+		UpdatedTs:    &resource.UpdatedTs,
+		Filename:     &resource.Filename,
+		Type:         &resource.Type,
+		Size:         &resource.Size,
+		InternalPath: &resource.InternalPath,
+		ExternalLink: &resource.ExternalLink,
+		Blob:         blob,
+		OriginalTs:   &originalTs,
+		Sha256:       &resource.Sha256,
+		Compression:  &resource.Compression,
+	}
+}
+
+// hashResourceBlob returns the hex encoded SHA-256 of the blob.
+func hashResourceBlob(blob []byte) string {
+	hash := sha256.Sum256(blob)
+	return hex.EncodeToString(hash[:])
 }
 
 func (s *Store) DeleteResource(ctx context.Context, delete *DeleteResource) error {
@@ -105,18 +506,35 @@ func (s *Store) DeleteResource(ctx context.Context, delete *DeleteResource) erro
 
 	// Delete the local file.
 	if resource.InternalPath != "" {
-		resourcePath := filepath.FromSlash(resource.InternalPath)
-		if !filepath.IsAbs(resourcePath) {
-			resourcePath = filepath.Join(s.Profile.Data, resourcePath)
+		resourcePath := s.GetResourceLocalPath(resource)
+		start := time.Now()
+		err := os.Remove(resourcePath)
+		if os.IsNotExist(err) {
+			err = nil
 		}
-		_ = os.Remove(resourcePath)
+		metric.ObserveStorageOperation("delete", "local", resource.InternalPath, start, 0, err)
+		// The sidecar may have been written while the setting was enabled.
+		_ = os.Remove(resourcePath + ResourceSidecarSuffix)
+	}
+
+	s.DeleteResourceThumbnails(resource.ID)
+	if err := s.driver.DeleteResource(ctx, delete); err != nil {
+		return err
 	}
+	s.notifyResourceObservers(ResourceEventDeleted, resource)
+	start := time.Now()
+	err = s.driver.DeleteResourceBlobChunks(ctx, resource.ID)
+	metric.ObserveStorageOperation("delete", "database", strconv.Itoa(int(resource.ID)), start, 0, err)
+	return err
+}
 
-	// Delete the thumbnail.
-	if util.HasPrefixes(resource.Type, "image/png", "image/jpeg") {
-		ext := filepath.Ext(resource.Filename)
-		thumbnailPath := filepath.Join(s.Profile.Data, thumbnailImagePath, fmt.Sprintf("%d%s", resource.ID, ext))
-		_ = os.Remove(thumbnailPath)
+// DeleteResourceThumbnails deletes all thumbnail variants of the resource.
+func (s *Store) DeleteResourceThumbnails(resourceID int32) {
+	thumbnailDir := filepath.Join(s.Profile.Data, thumbnailImagePath)
+	for _, pattern := range []string{fmt.Sprintf("%d-*", resourceID), fmt.Sprintf("%d.*", resourceID)} {
+		thumbnailPaths, _ := filepath.Glob(filepath.Join(thumbnailDir, pattern))
+		for _, thumbnailPath := range thumbnailPaths {
+			_ = os.Remove(thumbnailPath)
+		}
 	}
-	return s.driver.DeleteResource(ctx, delete)
 }
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/usememos/memos/server/service/metric"
+)
+
+// resourceBlobChunkSize is the size of the chunks resource blobs are stored in.
+const resourceBlobChunkSize = 1 << 20
+
+// ResourceBlobChunk is a fixed-size part of a resource blob stored in the database.
+type ResourceBlobChunk struct {
+	ResourceID int32
+	Seq        int32
+	Data       []byte
+}
+
+// resourceBlobStagingID returns the key the replacement blob of the resource is staged under until it is swapped in.
+// Resource IDs are positive, so staged chunks never mix with the chunks of another resource.
+func resourceBlobStagingID(resourceID int32) int32 {
+	return -resourceID
+}
+
+// lockResourceBlob serializes the replacements of the blob of the resource, as they share its staging key.
+func (s *Store) lockResourceBlob(resourceID int32) func() {
+	mu, _ := s.resourceBlobLocks.LoadOrStore(resourceID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// WriteResourceBlob stores the content of the reader as the chunked blob of the resource.
+// At most two chunks are held in memory at a time. Existing chunks of the resource are replaced.
+// The first chunk, which readers start from, is written once the reader is done, so a partial blob is never read:
+// a failed write, like an upload rejected by the antivirus at its end, leaves no blob under the resource ID.
+// Replacements are written under resourceBlobStagingID, so the previous blob is kept until the new one is complete.
+func (s *Store) WriteResourceBlob(ctx context.Context, resourceID int32, r io.Reader) (size int64, err error) {
+	start := time.Now()
+	defer func() {
+		metric.ObserveStorageOperation("upload", "database", strconv.Itoa(int(resourceID)), start, size, err)
+	}()
+
+	if err := s.driver.DeleteResourceBlobChunks(ctx, resourceID); err != nil {
+		return 0, errors.Wrap(err, "failed to delete resource blob chunks")
+	}
+	defer func() {
+		if err != nil {
+			// The write may have failed because ctx is done, the written chunks are removed regardless.
+			_ = s.driver.DeleteResourceBlobChunks(context.WithoutCancel(ctx), resourceID)
+		}
+	}()
+
+	first := make([]byte, resourceBlobChunkSize)
+	n, readErr := io.ReadFull(r, first)
+	first, size = first[:n], int64(n)
+	buf := make([]byte, resourceBlobChunkSize)
+	for seq := int32(1); readErr == nil; seq++ {
+		n, readErr = io.ReadFull(r, buf)
+		if n > 0 {
+			if err := s.driver.CreateResourceBlobChunk(ctx, &ResourceBlobChunk{
+				ResourceID: resourceID,
+				Seq:        seq,
+				Data:       buf[:n],
+			}); err != nil {
+				return size, errors.Wrap(err, "failed to create resource blob chunk")
+			}
+			size += int64(n)
+		}
+	}
+	if readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+		return size, errors.Wrap(readErr, "failed to read resource blob")
+	}
+	if len(first) > 0 {
+		if err := s.driver.CreateResourceBlobChunk(ctx, &ResourceBlobChunk{
+			ResourceID: resourceID,
+			Seq:        0,
+			Data:       first,
+		}); err != nil {
+			return size, errors.Wrap(err, "failed to create resource blob chunk")
+		}
+	}
+	return size, nil
+}
+
+// openResourceBlob opens the chunked blob of the resource.
+// It returns nil if the resource has no chunks.
+func (s *Store) openResourceBlob(ctx context.Context, resourceID int32) (io.ReadCloser, error) {
+	data, err := s.driver.GetResourceBlobChunk(ctx, resourceID, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get resource blob chunk")
+	}
+	if data == nil {
+		return nil, nil
+	}
+	return &resourceBlobReader{
+		ctx:        ctx,
+		driver:     s.driver,
+		resourceID: resourceID,
+		seq:        1,
+		buf:        data,
+	}, nil
+}
+
+// resourceBlobReader reads the chunks of a resource blob one by one.
+type resourceBlobReader struct {
+	ctx        context.Context
+	driver     Driver
+	resourceID int32
+	seq        int32
+	buf        []byte
+	eof        bool
+}
+
+func (r *resourceBlobReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		data, err := r.driver.GetResourceBlobChunk(r.ctx, r.resourceID, r.seq)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to get resource blob chunk")
+		}
+		if data == nil {
+			r.eof = true
+			continue
+		}
+		r.buf = data
+		r.seq++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *resourceBlobReader) Close() error {
+	r.buf = nil
+	r.eof = true
+	return nil
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ResourceCompressionGzip is the Compression of resources whose local file is gzip compressed.
+	ResourceCompressionGzip = "gzip"
+	// ResourceCompressedSuffix is appended to the internal path of compressed local files, so they're recognizable on disk.
+	// The compression of a resource is only known from its Compression, never from the path.
+	ResourceCompressedSuffix = ".gz"
+	// maxBufferedCompressedResourceSize is the maximum size of compressed local blobs decompressed in memory,
+	// so they can be read with seeking. Larger ones are decompressed while read.
+	maxBufferedCompressedResourceSize = 8 << 20
+)
+
+// compressibleResourceTypes are the MIME types of text formats outside of text/*.
+var compressibleResourceTypes = map[string]bool{
+	"application/ecmascript":   true,
+	"application/javascript":   true,
+	"application/json":         true,
+	"application/rtf":          true,
+	"application/sql":          true,
+	"application/toml":         true,
+	"application/x-javascript": true,
+	"application/x-ndjson":     true,
+	"application/x-sh":         true,
+	"application/x-tex":        true,
+	"application/x-yaml":       true,
+	"application/xml":          true,
+	"application/yaml":         true,
+}
+
+// IsCompressibleResourceType reports whether content of the MIME type is text, which is worth compressing at rest.
+func IsCompressibleResourceType(mimeType string) bool {
+	mimeType, _, _ = strings.Cut(strings.ToLower(mimeType), ";")
+	mimeType = strings.TrimSpace(mimeType)
+	if strings.HasPrefix(mimeType, "text/") || compressibleResourceTypes[mimeType] {
+		return true
+	}
+	// Structured syntax suffixes, such as image/svg+xml or application/ld+json.
+	return strings.Contains(mimeType, "/") && (strings.HasSuffix(mimeType, "+json") || strings.HasSuffix(mimeType, "+xml"))
+}
+
+// openCompressedLocalResource returns the decompressed content of the local blob of the resource.
+// Small blobs are decompressed in memory so the content can be sought.
+func openCompressedLocalResource(resource *Resource, file *os.File) (io.ReadCloser, error) {
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "failed to decompress the local resource")
+	}
+	if resource.Size > 0 && resource.Size <= maxBufferedCompressedResourceSize {
+		defer file.Close()
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decompress the local resource")
+		}
+		return bufferedLocalResource{bytes.NewReader(content)}, nil
+	}
+	return &compressedLocalResource{Reader: reader, file: file}, nil
+}
+
+// compressedLocalResource decompresses a local blob while it's read.
+type compressedLocalResource struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *compressedLocalResource) Close() error {
+	r.Reader.Close()
+	return r.file.Close()
+}
+
+// bufferedLocalResource is a local blob decompressed in memory.
+type bufferedLocalResource struct {
+	*bytes.Reader
+}
+
+func (bufferedLocalResource) Close() error {
+	return nil
+}
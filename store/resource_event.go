@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/usememos/memos/internal/log"
+)
+
+// ResourceEventType is the kind of change of a resource event.
+type ResourceEventType string
+
+const (
+	ResourceEventCreated ResourceEventType = "created"
+	ResourceEventDeleted ResourceEventType = "deleted"
+)
+
+// ResourceEvent describes a created or deleted resource.
+type ResourceEvent struct {
+	Type ResourceEventType
+	// Resource is the metadata of the resource, without its blob.
+	Resource *Resource
+}
+
+// ResourceObserver is called with the resource events, see Store.ObserveResources.
+// It runs outside of the request that changed the resource, so ctx isn't canceled with it.
+// The event is shared by all the observers and must not be modified.
+type ResourceObserver func(ctx context.Context, event *ResourceEvent)
+
+// resourceObservers holds the observers of a store, keyed by registration so they can be removed.
+type resourceObservers struct {
+	mutex     sync.RWMutex
+	nextID    int
+	observers map[int]ResourceObserver
+	// inflight tracks the notifications being delivered.
+	inflight sync.WaitGroup
+}
+
+// ObserveResources calls observer after every resource creation and deletion, and returns a function removing it.
+// Observers are called in the background, so a slow or panicking observer never delays or fails the change.
+func (s *Store) ObserveResources(observer ResourceObserver) func() {
+	s.resourceObservers.mutex.Lock()
+	defer s.resourceObservers.mutex.Unlock()
+	if s.resourceObservers.observers == nil {
+		s.resourceObservers.observers = map[int]ResourceObserver{}
+	}
+	id := s.resourceObservers.nextID
+	s.resourceObservers.nextID++
+	s.resourceObservers.observers[id] = observer
+	return func() {
+		s.resourceObservers.mutex.Lock()
+		defer s.resourceObservers.mutex.Unlock()
+		delete(s.resourceObservers.observers, id)
+	}
+}
+
+// WaitResourceObservers blocks until the pending resource events are delivered or ctx is done.
+func (s *Store) WaitResourceObservers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.resourceObservers.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// notifyResourceObservers delivers the event to every observer in a new goroutine.
+func (s *Store) notifyResourceObservers(eventType ResourceEventType, resource *Resource) {
+	s.resourceObservers.mutex.RLock()
+	observers := make([]ResourceObserver, 0, len(s.resourceObservers.observers))
+	for _, observer := range s.resourceObservers.observers {
+		observers = append(observers, observer)
+	}
+	s.resourceObservers.mutex.RUnlock()
+	if len(observers) == 0 {
+		return
+	}
+
+	metadata := *resource
+	metadata.Blob, metadata.BlobReader = nil, nil
+	event := &ResourceEvent{
+		Type:     eventType,
+		Resource: &metadata,
+	}
+	s.resourceObservers.inflight.Add(len(observers))
+	for _, observer := range observers {
+		go func(observer ResourceObserver) {
+			defer s.resourceObservers.inflight.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error("resource observer panicked", zap.String("event", string(event.Type)), zap.Int32("resourceId", event.Resource.ID), zap.String("panic", fmt.Sprint(r)))
+				}
+			}()
+			observer(context.Background(), event)
+		}(observer)
+	}
+}
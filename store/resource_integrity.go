@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// verifyResourcesBatchSize is the amount of resources listed at once while verifying.
+	verifyResourcesBatchSize = 100
+	// defaultVerifyResourcesConcurrency is the amount of resources read at the same time by default.
+	defaultVerifyResourcesConcurrency = 4
+	// backfillResourceSha256BatchSize is the amount of resources listed at once while back-filling hashes.
+	backfillResourceSha256BatchSize = 32
+)
+
+type VerifyResources struct {
+	// AfterID resumes a previous scan, only resources with a greater ID are verified.
+	AfterID int32
+	// Limit is the maximum amount of resources to verify, 0 means all.
+	Limit int
+	// Concurrency is the amount of resources read at the same time.
+	Concurrency int
+	// Progress is called after every verified batch with the report so far.
+	Progress func(report *ResourceIntegrityReport)
+}
+
+// ResourceIntegrityFailure describes a resource whose content can't be read back.
+type ResourceIntegrityFailure struct {
+	ResourceID int32  `json:"resourceId"`
+	Storage    string `json:"storage"`
+	Error      string `json:"error"`
+}
+
+// ResourceIntegrityReport is the result of a resource verification.
+type ResourceIntegrityReport struct {
+	// Checked is the amount of verified resources.
+	Checked int `json:"checked"`
+	// Skipped is the amount of resources stored externally, which can't be verified.
+	Skipped int `json:"skipped"`
+	// LastID is the ID of the last verified resource, pass it as AfterID to resume.
+	LastID   int32                       `json:"lastId"`
+	Failures []*ResourceIntegrityFailure `json:"failures"`
+}
+
+// VerifyResources reads the content of every resource in ID order and reports the ones that can't be read.
+// On cancellation the report covers the fully verified batches so it can be resumed from LastID.
+func (s *Store) VerifyResources(ctx context.Context, verify *VerifyResources) (*ResourceIntegrityReport, error) {
+	concurrency := verify.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultVerifyResourcesConcurrency
+	}
+	report := &ResourceIntegrityReport{
+		LastID:   verify.AfterID,
+		Failures: []*ResourceIntegrityFailure{},
+	}
+
+	for verify.Limit <= 0 || report.Checked < verify.Limit {
+		limit := verifyResourcesBatchSize
+		if verify.Limit > 0 {
+			limit = min(limit, verify.Limit-report.Checked)
+		}
+		afterID := report.LastID
+		resources, err := s.ListResources(ctx, &FindResource{
+			AfterID: &afterID,
+			OrderBy: ResourceOrderByID,
+			Limit:   &limit,
+		})
+		if err != nil {
+			return report, errors.Wrap(err, "failed to list resources")
+		}
+		if len(resources) == 0 {
+			break
+		}
+
+		mutex := sync.Mutex{}
+		failures, skipped := []*ResourceIntegrityFailure{}, 0
+		group, groupCtx := errgroup.WithContext(ctx)
+		group.SetLimit(concurrency)
+		for _, resource := range resources {
+			resource := resource
+			group.Go(func() error {
+				storage, err := s.verifyResourceContent(groupCtx, resource)
+				mutex.Lock()
+				defer mutex.Unlock()
+				if errors.Is(err, ErrResourceContentExternal) {
+					skipped++
+				} else if err != nil {
+					failures = append(failures, &ResourceIntegrityFailure{
+						ResourceID: resource.ID,
+						Storage:    storage,
+						Error:      err.Error(),
+					})
+				}
+				return nil
+			})
+		}
+		_ = group.Wait()
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		report.Checked += len(resources)
+		report.Skipped += skipped
+		report.LastID = resources[len(resources)-1].ID
+		report.Failures = append(report.Failures, failures...)
+		if verify.Progress != nil {
+			verify.Progress(report)
+		}
+	}
+	return report, nil
+}
+
+// verifyResourceContent reads the whole content of the resource, checking its size and hash when recorded,
+// and returns the name of its storage.
+func (s *Store) verifyResourceContent(ctx context.Context, resource *Resource) (string, error) {
+	storage := resource.Storage()
+	reader, err := s.GetResourceContent(ctx, resource)
+	if err != nil {
+		return storage, err
+	}
+	defer reader.Close()
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, reader)
+	if err != nil {
+		return storage, errors.Wrap(err, "failed to read resource content")
+	}
+	if resource.Size > 0 && size != resource.Size {
+		return storage, errors.Errorf("size mismatch: read %d bytes, expected %d", size, resource.Size)
+	}
+	if hash := hex.EncodeToString(hasher.Sum(nil)); resource.Sha256 != "" && hash != resource.Sha256 {
+		return storage, errors.Errorf("hash mismatch: read %s, expected %s", hash, resource.Sha256)
+	}
+	return storage, nil
+}
+
+// BackfillResourceSha256 computes the hash of the resources stored before hashes were recorded,
+// reading their content one by one. Resources whose content can't be read are skipped and left without hash.
+// It returns the amount of hashed resources.
+func (s *Store) BackfillResourceSha256(ctx context.Context) (int, error) {
+	hashed := 0
+	afterID := int32(0)
+	limit := backfillResourceSha256BatchSize
+	for {
+		resources, err := s.ListResources(ctx, &FindResource{
+			MissingSha256: true,
+			AfterID:       &afterID,
+			OrderBy:       ResourceOrderByID,
+			Limit:         &limit,
+		})
+		if err != nil {
+			return hashed, errors.Wrap(err, "failed to list resources")
+		}
+		for _, resource := range resources {
+			afterID = resource.ID
+			hash, err := s.hashResourceContent(ctx, resource)
+			if err != nil {
+				if ctx.Err() != nil {
+					return hashed, ctx.Err()
+				}
+				continue
+			}
+			if _, err := s.driver.UpdateResource(ctx, &UpdateResource{ID: resource.ID, Sha256: &hash}); err != nil {
+				return hashed, errors.Wrap(err, "failed to update resource hash")
+			}
+			hashed++
+		}
+		if len(resources) < limit {
+			return hashed, nil
+		}
+	}
+}
+
+// hashResourceContent returns the hex encoded SHA-256 of the content of the resource.
+func (s *Store) hashResourceContent(ctx context.Context, resource *Resource) (string, error) {
+	reader, err := s.GetResourceContent(ctx, resource)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return "", errors.Wrap(err, "failed to read resource content")
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
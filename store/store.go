@@ -15,6 +15,8 @@ type Store struct {
 	userCache          sync.Map // map[int]*User
 	userSettingCache   sync.Map // map[string]*UserSetting
 	idpCache           sync.Map // map[int]*IdentityProvider
+	resourceObservers  resourceObservers
+	resourceBlobLocks  sync.Map // map[int32]*sync.Mutex
 }
 
 // New creates a new instance of Store.
@@ -1,10 +1,22 @@
 package teststore
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/lithammer/shortuuid/v4"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 
 	"github.com/usememos/memos/store"
@@ -63,3 +75,904 @@ func TestResourceStore(t *testing.T) {
 	require.NoError(t, err)
 	ts.Close()
 }
+
+func TestCountResources(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	var creatorID int32 = 101
+	for i := 0; i < 3; i++ {
+		_, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    creatorID,
+			Filename:     "test.txt",
+			Type:         "text/plain",
+		})
+		require.NoError(t, err)
+	}
+	_, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    102,
+		Filename:     "other.txt",
+		Type:         "text/plain",
+	})
+	require.NoError(t, err)
+
+	limit := 1
+	count, err := ts.CountResources(ctx, &store.FindResource{
+		CreatorID: &creatorID,
+		Limit:     &limit,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), count)
+
+	count, err = ts.CountResources(ctx, &store.FindResource{})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), count)
+	ts.Close()
+}
+
+func TestListResourcesOrderBy(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	for _, filename := range []string{"b.txt", "c.txt", "a.txt"} {
+		_, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     filename,
+			Type:         "text/plain",
+			Size:         int64(len(filename) + int(filename[0])),
+		})
+		require.NoError(t, err)
+	}
+
+	resources, err := ts.ListResources(ctx, &store.FindResource{
+		OrderBy: store.ResourceOrderByFilename,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt", "b.txt", "c.txt"}, []string{resources[0].Filename, resources[1].Filename, resources[2].Filename})
+
+	resources, err = ts.ListResources(ctx, &store.FindResource{
+		OrderBy:   store.ResourceOrderBySize,
+		OrderDesc: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c.txt", "b.txt", "a.txt"}, []string{resources[0].Filename, resources[1].Filename, resources[2].Filename})
+
+	_, err = ts.ListResources(ctx, &store.FindResource{
+		OrderBy: store.ResourceOrderBy("id; DROP TABLE resource"),
+	})
+	require.Error(t, err)
+	ts.Close()
+}
+
+func TestDeleteResourceRemovesThumbnails(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "photo.jpg",
+		Blob:         []byte("test"),
+		Type:         "image/jpeg",
+		Size:         4,
+	})
+	require.NoError(t, err)
+
+	thumbnailDir := filepath.Join(ts.Profile.Data, ".thumbnail_cache")
+	require.NoError(t, os.MkdirAll(thumbnailDir, os.ModePerm))
+	thumbnailNames := []string{
+		fmt.Sprintf("%d.jpg", resource.ID),
+		fmt.Sprintf("%d-100.jpg", resource.ID),
+		fmt.Sprintf("%d-200.jpeg", resource.ID),
+	}
+	otherThumbnailName := fmt.Sprintf("%d1-100.jpg", resource.ID)
+	for _, name := range append(thumbnailNames, otherThumbnailName) {
+		require.NoError(t, os.WriteFile(filepath.Join(thumbnailDir, name), []byte("thumbnail"), 0644))
+	}
+
+	err = ts.DeleteResource(ctx, &store.DeleteResource{
+		ID: resource.ID,
+	})
+	require.NoError(t, err)
+	for _, name := range thumbnailNames {
+		_, err := os.Stat(filepath.Join(thumbnailDir, name))
+		require.True(t, os.IsNotExist(err))
+	}
+	_, err = os.Stat(filepath.Join(thumbnailDir, otherThumbnailName))
+	require.NoError(t, err)
+	ts.Close()
+}
+
+func TestListResourcesFilenameSearch(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	for _, filename := range []string{"Holiday-Photo.png", "holiday_notes.txt", "invoice.pdf", "discount100%.pdf", "discount1000.pdf"} {
+		_, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     filename,
+			Blob:         []byte("test"),
+			Type:         "application/octet-stream",
+			Size:         4,
+		})
+		require.NoError(t, err)
+	}
+
+	search := "HOLIDAY"
+	resources, err := ts.ListResources(ctx, &store.FindResource{
+		FilenameSearch: &search,
+	})
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+
+	search = "voic"
+	resources, err = ts.ListResources(ctx, &store.FindResource{
+		FilenameSearch: &search,
+	})
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+	require.Equal(t, "invoice.pdf", resources[0].Filename)
+
+	// Wildcards in the term are matched literally.
+	for search, want := range map[string]string{"y_": "holiday_notes.txt", "100%": "discount100%.pdf"} {
+		search := search
+		resources, err = ts.ListResources(ctx, &store.FindResource{
+			FilenameSearch: &search,
+		})
+		require.NoError(t, err)
+		require.Len(t, resources, 1, search)
+		require.Equal(t, want, resources[0].Filename)
+	}
+	search = `\`
+	resources, err = ts.ListResources(ctx, &store.FindResource{
+		FilenameSearch: &search,
+	})
+	require.NoError(t, err)
+	require.Len(t, resources, 0)
+
+	search = "voic"
+	var creatorID int32 = 102
+	resources, err = ts.ListResources(ctx, &store.FindResource{
+		CreatorID:      &creatorID,
+		FilenameSearch: &search,
+	})
+	require.NoError(t, err)
+	require.Len(t, resources, 0)
+	ts.Close()
+}
+
+func TestUpdateResourceContent(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "draft.txt",
+		Blob:         []byte("draft"),
+		Type:         "text/plain",
+		Size:         5,
+	})
+	require.NoError(t, err)
+
+	filename, resourceType, size := "final.md", "text/markdown", int64(len("final version"))
+	updatedResource, err := ts.UpdateResource(ctx, &store.UpdateResource{
+		ID:       resource.ID,
+		Filename: &filename,
+		Type:     &resourceType,
+		Size:     &size,
+		Blob:     []byte("final version"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, resource.ResourceName, updatedResource.ResourceName)
+	require.Equal(t, filename, updatedResource.Filename)
+	require.Equal(t, resourceType, updatedResource.Type)
+	require.Equal(t, size, updatedResource.Size)
+
+	resource, err = ts.GetResource(ctx, &store.FindResource{
+		ID:      &resource.ID,
+		GetBlob: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte("final version"), resource.Blob)
+	ts.Close()
+}
+
+func TestListResourcesExpiry(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	now := time.Now().Unix()
+	for _, expiresTs := range []int64{0, now - 60, now + 3600} {
+		_, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     "screenshot.png",
+			Blob:         []byte("test"),
+			Type:         "image/png",
+			Size:         4,
+			ExpiresTs:    expiresTs,
+		})
+		require.NoError(t, err)
+	}
+
+	expiredResources, err := ts.ListResources(ctx, &store.FindResource{
+		ExpiresBefore: &now,
+	})
+	require.NoError(t, err)
+	require.Len(t, expiredResources, 1)
+	require.Equal(t, now-60, expiredResources[0].ExpiresTs)
+	require.True(t, expiredResources[0].IsExpired(now))
+
+	activeResources, err := ts.ListResources(ctx, &store.FindResource{
+		NotExpiredAt: &now,
+	})
+	require.NoError(t, err)
+	require.Len(t, activeResources, 2)
+	for _, resource := range activeResources {
+		require.False(t, resource.IsExpired(now))
+	}
+	ts.Close()
+}
+
+func TestResourceBlobChunks(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	content := make([]byte, 3<<20+12345)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "archive.bin",
+		BlobReader:   bytes.NewReader(content),
+		Type:         "application/octet-stream",
+		Size:         int64(len(content)),
+	})
+	require.NoError(t, err)
+
+	reader, err := ts.GetResourceContent(ctx, resource)
+	require.NoError(t, err)
+	got, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, content, got)
+
+	// Replacing the blob drops the previous chunks.
+	size := int64(len("small"))
+	_, err = ts.UpdateResource(ctx, &store.UpdateResource{
+		ID:         resource.ID,
+		Size:       &size,
+		BlobReader: bytes.NewReader([]byte("small")),
+	})
+	require.NoError(t, err)
+	reader, err = ts.GetResourceContent(ctx, resource)
+	require.NoError(t, err)
+	got, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, []byte("small"), got)
+
+	err = ts.DeleteResource(ctx, &store.DeleteResource{
+		ID: resource.ID,
+	})
+	require.NoError(t, err)
+	_, err = ts.GetResourceContent(ctx, resource)
+	require.Error(t, err)
+	ts.Close()
+}
+
+// peekingReader reads the content, then calls peek before failing with err at the end, like a rejected upload.
+type peekingReader struct {
+	r    io.Reader
+	peek func()
+	err  error
+}
+
+func (r *peekingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		r.peek()
+		return n, r.err
+	}
+	return n, err
+}
+
+func TestResourceBlobChunksHiddenUntilWritten(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	defer ts.Close()
+	content := bytes.Repeat([]byte("infected"), 3<<20/8)
+	resourceName := shortuuid.New()
+	peekedContents := [][]byte{}
+	peek := func() {
+		resource, err := ts.GetResource(ctx, &store.FindResource{ResourceName: &resourceName})
+		require.NoError(t, err)
+		reader, err := ts.GetResourceContent(ctx, resource)
+		require.NoError(t, err)
+		defer reader.Close()
+		peeked, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		peekedContents = append(peekedContents, peeked)
+	}
+
+	_, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: resourceName,
+		CreatorID:    101,
+		Filename:     "eicar.bin",
+		BlobReader:   &peekingReader{r: bytes.NewReader(content), peek: peek, err: errors.New("infected")},
+		Type:         "application/octet-stream",
+		Size:         int64(len(content)),
+	})
+	require.ErrorContains(t, err, "infected")
+	// The written chunks aren't read before the whole content is.
+	require.Equal(t, [][]byte{{}}, peekedContents)
+	resource, err := ts.GetResource(ctx, &store.FindResource{ResourceName: &resourceName})
+	require.NoError(t, err)
+	require.Nil(t, resource)
+
+	// The previous content is kept, and served while the replacement is written.
+	resource, err = ts.CreateResource(ctx, &store.Resource{
+		ResourceName: resourceName,
+		CreatorID:    101,
+		Filename:     "eicar.bin",
+		BlobReader:   bytes.NewReader([]byte("clean")),
+		Type:         "application/octet-stream",
+		Size:         5,
+	})
+	require.NoError(t, err)
+	peekedContents = [][]byte{}
+	_, err = ts.UpdateResource(ctx, &store.UpdateResource{
+		ID:         resource.ID,
+		BlobReader: &peekingReader{r: bytes.NewReader(content), peek: peek, err: errors.New("infected")},
+	})
+	require.ErrorContains(t, err, "infected")
+	require.Equal(t, [][]byte{[]byte("clean")}, peekedContents)
+	reader, err := ts.GetResourceContent(ctx, resource)
+	require.NoError(t, err)
+	got, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "clean", string(got))
+}
+
+// heapSamplingReader generates zeroes and records the peak heap usage while being read.
+type heapSamplingReader struct {
+	remaining int64
+	read      int64
+	peakHeap  uint64
+}
+
+func (r *heapSamplingReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	clear(p)
+	if r.read%(16<<20) < int64(len(p)) {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		r.peakHeap = max(r.peakHeap, stats.HeapInuse)
+	}
+	r.remaining -= int64(len(p))
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestResourceBlobChunksLargeStream(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large stream test in short mode")
+	}
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapInuse
+
+	size := int64(256 << 20)
+	source := &heapSamplingReader{remaining: size}
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "large.bin",
+		BlobReader:   source,
+		Type:         "application/octet-stream",
+		Size:         size,
+	})
+	require.NoError(t, err)
+	require.Less(t, source.peakHeap, baseline+64<<20)
+
+	reader, err := ts.GetResourceContent(ctx, resource)
+	require.NoError(t, err)
+	read, err := io.Copy(io.Discard, reader)
+	require.NoError(t, err)
+	require.Equal(t, size, read)
+	ts.Close()
+}
+
+func TestVerifyResources(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	for _, create := range []*store.Resource{
+		{Filename: "a.txt", Blob: []byte("first"), Size: 5},
+		{Filename: "b.txt", BlobReader: bytes.NewReader([]byte("second")), Size: 6},
+		{Filename: "missing.txt", InternalPath: "assets/missing.txt", Size: 7},
+		{Filename: "link.png", ExternalLink: "https://example.com/link.png"},
+		{Filename: "truncated.txt", Blob: []byte("short"), Size: 10},
+		{Filename: "corrupted.txt", Blob: []byte("flipped"), Size: 7, Sha256: strings.Repeat("0", 64)},
+	} {
+		create.ResourceName = shortuuid.New()
+		create.CreatorID = 101
+		create.Type = "text/plain"
+		_, err := ts.CreateResource(ctx, create)
+		require.NoError(t, err)
+	}
+
+	report, err := ts.VerifyResources(ctx, &store.VerifyResources{})
+	require.NoError(t, err)
+	require.Equal(t, 6, report.Checked)
+	require.Equal(t, 1, report.Skipped)
+	require.Equal(t, int32(6), report.LastID)
+	require.Len(t, report.Failures, 3)
+	failedIDs := []int32{}
+	for _, failure := range report.Failures {
+		failedIDs = append(failedIDs, failure.ResourceID)
+		if failure.ResourceID == 6 {
+			require.Contains(t, failure.Error, "hash mismatch")
+		}
+	}
+	require.ElementsMatch(t, []int32{3, 5, 6}, failedIDs)
+
+	// The scan can be resumed from the last verified resource.
+	report, err = ts.VerifyResources(ctx, &store.VerifyResources{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Checked)
+	require.Empty(t, report.Failures)
+	report, err = ts.VerifyResources(ctx, &store.VerifyResources{AfterID: report.LastID, Concurrency: 1})
+	require.NoError(t, err)
+	require.Equal(t, 4, report.Checked)
+	require.Len(t, report.Failures, 3)
+	ts.Close()
+}
+
+func TestResourceCacheControl(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	defer ts.Close()
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "avatar.png",
+		Blob:         []byte("test"),
+		Type:         "image/png",
+		Size:         4,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resource.CacheControl)
+
+	noStore := "no-store"
+	updated, err := ts.UpdateResource(ctx, &store.UpdateResource{
+		ID:           resource.ID,
+		CacheControl: &noStore,
+	})
+	require.NoError(t, err)
+	require.Equal(t, noStore, *updated.CacheControl)
+	found, err := ts.GetResource(ctx, &store.FindResource{ID: &resource.ID})
+	require.NoError(t, err)
+	require.Equal(t, noStore, *found.CacheControl)
+
+	invalid := "no-store\r\nSet-Cookie: session=stolen"
+	_, err = ts.UpdateResource(ctx, &store.UpdateResource{
+		ID:           resource.ID,
+		CacheControl: &invalid,
+	})
+	require.Error(t, err)
+
+	reset := ""
+	updated, err = ts.UpdateResource(ctx, &store.UpdateResource{
+		ID:           resource.ID,
+		CacheControl: &reset,
+	})
+	require.NoError(t, err)
+	require.Nil(t, updated.CacheControl)
+}
+
+func TestGetResourceContentLocalFile(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	defer ts.Close()
+	localPath := filepath.Join(t.TempDir(), "archive.zip")
+	require.NoError(t, os.WriteFile(localPath, []byte("test"), 0644))
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "archive.zip",
+		InternalPath: localPath,
+		Type:         "application/zip",
+		Size:         4,
+	})
+	require.NoError(t, err)
+
+	// The file itself is returned, so it can be sent with sendfile.
+	reader, err := ts.GetResourceContent(ctx, resource)
+	require.NoError(t, err)
+	defer reader.Close()
+	require.IsType(t, &os.File{}, reader)
+}
+
+func TestGetResourceContentCompressedLocalFile(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	defer ts.Close()
+	content := bytes.Repeat([]byte("compressible text "), 100)
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	_, err := writer.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	dir := t.TempDir()
+	for _, test := range []struct {
+		filename    string
+		mimeType    string
+		compression string
+		stored      []byte
+		size        int64
+		want        []byte
+		seekable    bool
+	}{
+		// Small blobs are decompressed in memory so ranges are still served.
+		{filename: "notes.txt.gz", mimeType: "text/plain", compression: store.ResourceCompressionGzip, stored: compressed.Bytes(), size: int64(len(content)), want: content, seekable: true},
+		// Blobs of unknown size are decompressed while read.
+		{filename: "unknown.txt.gz", mimeType: "text/plain", compression: store.ResourceCompressionGzip, stored: compressed.Bytes(), want: content},
+		// Files uploaded compressed are served as uploaded, whatever their name and type.
+		{filename: "backup.tar.gz", mimeType: "application/x-compressed-tar", stored: compressed.Bytes(), size: int64(compressed.Len()), want: compressed.Bytes(), seekable: true},
+		{filename: "notes.txt.gz", mimeType: "text/plain", stored: compressed.Bytes(), size: int64(compressed.Len()), want: compressed.Bytes(), seekable: true},
+	} {
+		localPath := filepath.Join(dir, shortuuid.New()+"_"+test.filename)
+		require.NoError(t, os.WriteFile(localPath, test.stored, 0644))
+		resource, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     test.filename,
+			InternalPath: localPath,
+			Type:         test.mimeType,
+			Size:         test.size,
+			Compression:  test.compression,
+		})
+		require.NoError(t, err)
+		require.Equal(t, test.compression, resource.Compression)
+
+		reader, err := ts.GetResourceContent(ctx, resource)
+		require.NoError(t, err, test.filename)
+		_, seekable := reader.(io.Seeker)
+		require.Equal(t, test.seekable, seekable, test.filename)
+		read, err := io.ReadAll(reader)
+		require.NoError(t, err, test.filename)
+		require.NoError(t, reader.Close())
+		require.Equal(t, test.want, read, test.filename)
+	}
+
+	// Replacing the content resets the compression.
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "notes.txt",
+		InternalPath: filepath.Join(dir, "notes.txt.gz"),
+		Type:         "text/plain",
+		Compression:  store.ResourceCompressionGzip,
+	})
+	require.NoError(t, err)
+	resource, err = ts.UpdateResource(ctx, &store.UpdateResource{ID: resource.ID, Blob: content})
+	require.NoError(t, err)
+	require.Empty(t, resource.Compression)
+}
+
+func TestIsCompressibleResourceType(t *testing.T) {
+	for mimeType, want := range map[string]bool{
+		"text/plain; charset=utf-8":    true,
+		"application/json":             true,
+		"image/svg+xml":                true,
+		"image/png":                    false,
+		"video/mp4":                    false,
+		"application/zip":              false,
+		"application/x-compressed-tar": false,
+		"application/octet-stream":     false,
+		"application/ld+json":          true,
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document": false,
+		"": false,
+	} {
+		require.Equal(t, want, store.IsCompressibleResourceType(mimeType), mimeType)
+	}
+}
+
+func TestResourceOriginalTs(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	defer ts.Close()
+	originalTs := int64(1136214245)
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "scan.pdf",
+		Blob:         []byte("test"),
+		Type:         "application/pdf",
+		Size:         4,
+		OriginalTs:   &originalTs,
+	})
+	require.NoError(t, err)
+	found, err := ts.GetResource(ctx, &store.FindResource{ID: &resource.ID})
+	require.NoError(t, err)
+	require.Equal(t, originalTs, *found.OriginalTs)
+	require.Equal(t, originalTs, found.LastModifiedTs())
+
+	reset := int64(0)
+	updated, err := ts.UpdateResource(ctx, &store.UpdateResource{
+		ID:         resource.ID,
+		OriginalTs: &reset,
+	})
+	require.NoError(t, err)
+	require.Nil(t, updated.OriginalTs)
+	require.Equal(t, updated.UpdatedTs, updated.LastModifiedTs())
+}
+
+func TestListResourcesByStorage(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	defer ts.Close()
+	for _, create := range []*store.Resource{
+		{Filename: "database.txt", Blob: []byte("test")},
+		{Filename: "local.txt", InternalPath: "assets/local.txt"},
+		{Filename: "external.txt", ExternalLink: "https://example.com/external.txt"},
+	} {
+		create.ResourceName = shortuuid.New()
+		create.CreatorID = 101
+		create.Type = "text/plain"
+		_, err := ts.CreateResource(ctx, create)
+		require.NoError(t, err)
+	}
+
+	for _, storage := range []string{store.ResourceStorageDatabase, store.ResourceStorageLocal, store.ResourceStorageExternal} {
+		resources, err := ts.ListResources(ctx, &store.FindResource{Storage: &storage})
+		require.NoError(t, err)
+		require.Len(t, resources, 1, storage)
+		require.Equal(t, storage, resources[0].Storage())
+		require.Equal(t, storage+".txt", resources[0].Filename)
+	}
+	unknown := "s3"
+	resources, err := ts.ListResources(ctx, &store.FindResource{Storage: &unknown})
+	require.NoError(t, err)
+	require.Empty(t, resources)
+}
+
+func TestResourceSizeAggregates(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	defer ts.Close()
+	for _, create := range []*store.Resource{
+		{Filename: "a.png", Type: "image/png", Size: 10, Blob: []byte("a")},
+		{Filename: "b.jpg", Type: "image/jpeg", Size: 20, InternalPath: "assets/b.jpg"},
+		{Filename: "c.mp4", Type: "video/mp4", Size: 30, ExternalLink: "https://example.com/c.mp4"},
+		{Filename: "d", Type: "", Size: 40, Blob: []byte("d")},
+	} {
+		create.ResourceName = shortuuid.New()
+		create.CreatorID = 101
+		_, err := ts.CreateResource(ctx, create)
+		require.NoError(t, err)
+	}
+
+	sum, err := ts.SumResourceSize(ctx, &store.FindResource{})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), sum.Count)
+	require.Equal(t, int64(100), sum.Size)
+
+	byStorage, err := ts.GroupResourceSizeByStorage(ctx, &store.FindResource{})
+	require.NoError(t, err)
+	require.Equal(t, []*store.ResourceSizeSum{
+		{Key: "database", Count: 2, Size: 50},
+		{Key: "external", Count: 1, Size: 30},
+		{Key: "local", Count: 1, Size: 20},
+	}, byStorage)
+
+	byType, err := ts.GroupResourceSizeByType(ctx, &store.FindResource{})
+	require.NoError(t, err)
+	require.Equal(t, []*store.ResourceSizeSum{
+		{Key: "", Count: 1, Size: 40},
+		{Key: "image", Count: 2, Size: 30},
+		{Key: "video", Count: 1, Size: 30},
+	}, byType)
+
+	creatorID := int32(102)
+	sum, err = ts.SumResourceSize(ctx, &store.FindResource{CreatorID: &creatorID})
+	require.NoError(t, err)
+	require.Equal(t, &store.ResourceSizeSum{}, sum)
+}
+
+func TestListResourcesCursor(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	createResource := func(filename string) {
+		_, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     filename,
+			Type:         "text/plain",
+		})
+		require.NoError(t, err)
+	}
+	for _, filename := range []string{"1.txt", "2.txt", "3.txt", "4.txt", "5.txt"} {
+		createResource(filename)
+	}
+
+	limit := 2
+	filenames := []string{}
+	find := &store.FindResource{
+		OrderBy:   store.ResourceOrderByCreatedTs,
+		OrderDesc: true,
+		Limit:     &limit,
+	}
+	for {
+		resources, err := ts.ListResources(ctx, find)
+		require.NoError(t, err)
+		for _, resource := range resources {
+			filenames = append(filenames, resource.Filename)
+		}
+		if len(resources) < limit {
+			break
+		}
+		// Resources added between pages are newer than the cursor and don't shift the next pages.
+		createResource("new.txt")
+		last := resources[len(resources)-1]
+		find.CreatedTsBefore, find.IDBefore = &last.CreatedTs, &last.ID
+	}
+	require.Equal(t, []string{"5.txt", "4.txt", "3.txt", "2.txt", "1.txt"}, filenames)
+	ts.Close()
+}
+
+func TestListResourcesByIDs(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	ids := []int32{}
+	for i := 0; i < 600; i++ {
+		resource, err := ts.CreateResource(ctx, &store.Resource{
+			ResourceName: shortuuid.New(),
+			CreatorID:    101,
+			Filename:     fmt.Sprintf("%d.txt", i),
+			Type:         "text/plain",
+		})
+		require.NoError(t, err)
+		ids = append(ids, resource.ID)
+	}
+
+	resources, err := ts.ListResources(ctx, &store.FindResource{
+		IDs: []int32{ids[2], ids[0], -1, ids[1]},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int32{ids[2], ids[0], ids[1]}, []int32{resources[0].ID, resources[1].ID, resources[2].ID})
+	require.Len(t, resources, 3)
+
+	resources, err = ts.ListResources(ctx, &store.FindResource{
+		IDs: []int32{},
+	})
+	require.NoError(t, err)
+	require.Empty(t, resources)
+
+	// More IDs than fit in a single query.
+	reversed := slices.Clone(ids)
+	slices.Reverse(reversed)
+	resources, err = ts.ListResources(ctx, &store.FindResource{
+		IDs: reversed,
+	})
+	require.NoError(t, err)
+	require.Len(t, resources, len(ids))
+	for i, resource := range resources {
+		require.Equal(t, reversed[i], resource.ID)
+	}
+	limit := 10
+	_, err = ts.ListResources(ctx, &store.FindResource{
+		IDs:   reversed,
+		Limit: &limit,
+	})
+	require.Error(t, err)
+	ts.Close()
+}
+
+func TestObserveResources(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	events := make(chan *store.ResourceEvent, 4)
+	release := make(chan struct{})
+	remove := ts.ObserveResources(func(_ context.Context, event *store.ResourceEvent) {
+		<-release
+		events <- event
+	})
+	ts.ObserveResources(func(context.Context, *store.ResourceEvent) {
+		panic("observer failure")
+	})
+
+	// A blocked or panicking observer doesn't delay or fail the changes.
+	resource, err := ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "test.txt",
+		Blob:         []byte("test"),
+		Type:         "text/plain",
+		Size:         4,
+	})
+	require.NoError(t, err)
+	require.NoError(t, ts.DeleteResource(ctx, &store.DeleteResource{ID: resource.ID}))
+	close(release)
+	require.NoError(t, ts.WaitResourceObservers(ctx))
+
+	received := map[store.ResourceEventType]*store.ResourceEvent{}
+	for i := 0; i < 2; i++ {
+		event := <-events
+		received[event.Type] = event
+	}
+	require.Equal(t, resource.ID, received[store.ResourceEventCreated].Resource.ID)
+	require.Nil(t, received[store.ResourceEventCreated].Resource.Blob)
+	require.Equal(t, "test.txt", received[store.ResourceEventDeleted].Resource.Filename)
+
+	remove()
+	_, err = ts.CreateResource(ctx, &store.Resource{
+		ResourceName: shortuuid.New(),
+		CreatorID:    101,
+		Filename:     "other.txt",
+		Type:         "text/plain",
+	})
+	require.NoError(t, err)
+	require.NoError(t, ts.WaitResourceObservers(ctx))
+	require.Empty(t, events)
+	ts.Close()
+}
+
+func TestResourceSha256(t *testing.T) {
+	ctx := context.Background()
+	ts := NewTestingStore(ctx, t)
+	defer ts.Close()
+	helloHash := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	worldHash := "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7"
+
+	blob, err := ts.CreateResource(ctx, &store.Resource{ResourceName: shortuuid.New(), CreatorID: 101, Filename: "a.txt", Blob: []byte("hello"), Type: "text/plain", Size: 5})
+	require.NoError(t, err)
+	require.Equal(t, helloHash, blob.Sha256)
+	chunked, err := ts.CreateResource(ctx, &store.Resource{ResourceName: shortuuid.New(), CreatorID: 101, Filename: "b.txt", BlobReader: bytes.NewReader([]byte("hello")), Type: "text/plain", Size: 5})
+	require.NoError(t, err)
+	require.Equal(t, helloHash, chunked.Sha256)
+	found, err := ts.GetResource(ctx, &store.FindResource{ID: &chunked.ID})
+	require.NoError(t, err)
+	require.Equal(t, helloHash, found.Sha256)
+
+	// Replacing the content replaces the hash.
+	size := int64(5)
+	updated, err := ts.UpdateResource(ctx, &store.UpdateResource{ID: chunked.ID, Size: &size, BlobReader: bytes.NewReader([]byte("world"))})
+	require.NoError(t, err)
+	require.Equal(t, worldHash, updated.Sha256)
+	updated, err = ts.UpdateResource(ctx, &store.UpdateResource{ID: blob.ID, Blob: []byte("world")})
+	require.NoError(t, err)
+	require.Equal(t, worldHash, updated.Sha256)
+
+	// Local files stored without hash are back-filled, external links are left alone.
+	localPath := filepath.Join(t.TempDir(), "c.txt")
+	require.NoError(t, os.WriteFile(localPath, []byte("hello"), 0644))
+	local, err := ts.CreateResource(ctx, &store.Resource{ResourceName: shortuuid.New(), CreatorID: 101, Filename: "c.txt", InternalPath: localPath, Type: "text/plain", Size: 5})
+	require.NoError(t, err)
+	require.Empty(t, local.Sha256)
+	_, err = ts.CreateResource(ctx, &store.Resource{ResourceName: shortuuid.New(), CreatorID: 101, Filename: "d.png", ExternalLink: "https://example.com/d.png", Type: "image/png"})
+	require.NoError(t, err)
+	_, err = ts.CreateResource(ctx, &store.Resource{ResourceName: shortuuid.New(), CreatorID: 101, Filename: "missing.txt", InternalPath: filepath.Join(t.TempDir(), "missing.txt"), Type: "text/plain", Size: 5})
+	require.NoError(t, err)
+	missing, err := ts.ListResources(ctx, &store.FindResource{MissingSha256: true})
+	require.NoError(t, err)
+	require.Len(t, missing, 2)
+
+	hashed, err := ts.BackfillResourceSha256(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, hashed)
+	found, err = ts.GetResource(ctx, &store.FindResource{ID: &local.ID})
+	require.NoError(t, err)
+	require.Equal(t, helloHash, found.Sha256)
+	missing, err = ts.ListResources(ctx, &store.FindResource{MissingSha256: true})
+	require.NoError(t, err)
+	require.Len(t, missing, 1)
+	require.Equal(t, "missing.txt", missing[0].Filename)
+}
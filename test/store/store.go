@@ -40,6 +40,7 @@ func resetTestingDB(ctx context.Context, profile *profile.Profile, dbDriver stor
 		DROP TABLE IF EXISTS memo_organizer;
 		DROP TABLE IF EXISTS memo_relation;
 		DROP TABLE IF EXISTS resource;
+		DROP TABLE IF EXISTS resource_blob_chunk;
 		DROP TABLE IF EXISTS tag;
 		DROP TABLE IF EXISTS activity;
 		DROP TABLE IF EXISTS storage;
@@ -60,6 +61,7 @@ func resetTestingDB(ctx context.Context, profile *profile.Profile, dbDriver stor
 		DROP TABLE IF EXISTS memo_organizer CASCADE;
 		DROP TABLE IF EXISTS memo_relation CASCADE;
 		DROP TABLE IF EXISTS resource CASCADE;
+		DROP TABLE IF EXISTS resource_blob_chunk CASCADE;
 		DROP TABLE IF EXISTS tag CASCADE;
 		DROP TABLE IF EXISTS activity CASCADE;
 		DROP TABLE IF EXISTS storage CASCADE;